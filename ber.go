@@ -23,21 +23,77 @@ import (
 	"encoding/asn1"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/big"
+	"net"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 	"unicode/utf16"
 	"unicode/utf8"
 )
 
+// A StructuralError suggests that the ASN.1 data is valid, but the Go type
+// which is receiving it doesn't match. Offset is the position in the input of
+// the element which failed to parse.
+type StructuralError struct {
+	Msg    string
+	Offset int
+}
+
+func (e StructuralError) Error() string {
+	return fmt.Sprintf("asn1: structure error: %s at offset %d", e.Msg, e.Offset)
+}
+
+// As allows errors.As to match e with an asn1.StructuralError.
+func (e StructuralError) As(target any) bool {
+	if se, ok := target.(*asn1.StructuralError); ok {
+		*se = asn1.StructuralError{Msg: e.Msg}
+		return true
+	}
+	return false
+}
+
+// A SyntaxError suggests that the ASN.1 data is invalid. Offset is the
+// position in the input of the element which failed to parse.
+type SyntaxError struct {
+	Msg    string
+	Offset int
+
+	// truncated is set if the input ended before the element did.
+	truncated bool
+}
+
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf("asn1: syntax error: %s at offset %d", e.Msg, e.Offset)
+}
+
+// Is reports whether target is io.ErrUnexpectedEOF and the input ended
+// before the element did.
+func (e SyntaxError) Is(target error) bool {
+	return e.truncated && target == io.ErrUnexpectedEOF
+}
+
+// As allows errors.As to match e with an asn1.SyntaxError.
+func (e SyntaxError) As(target any) bool {
+	if se, ok := target.(*asn1.SyntaxError); ok {
+		*se = asn1.SyntaxError{Msg: e.Msg}
+		return true
+	}
+	return false
+}
+
 // We start by dealing with each of the primitive types in turn.
 
 // BOOLEAN
 
 func parseBool(bytes []byte) (ret bool, err error) {
 	if len(bytes) != 1 {
-		err = asn1.SyntaxError{Msg: "invalid boolean"}
+		err = asn1.StructuralError{Msg: "invalid boolean"}
 		return
 	}
 
@@ -50,12 +106,21 @@ func parseBool(bytes []byte) (ret bool, err error) {
 	case 0xff:
 		ret = true
 	default:
-		err = asn1.SyntaxError{Msg: "invalid boolean"}
+		err = asn1.StructuralError{Msg: "invalid boolean"}
 	}
 
 	return
 }
 
+// parseLenientBool parses a BOOLEAN as BER allows, any non-zero contents
+// octet being TRUE.
+func parseLenientBool(bytes []byte) (ret bool, err error) {
+	if len(bytes) != 1 {
+		return false, asn1.StructuralError{Msg: "invalid boolean"}
+	}
+	return bytes[0] != 0, nil
+}
+
 // INTEGER
 
 // checkInteger returns nil if the given bytes are a valid DER-encoded
@@ -73,6 +138,26 @@ func checkInteger(bytes []byte) error {
 	return nil
 }
 
+// minimalInteger returns the INTEGER contents bytes without the redundant
+// leading octets, all zero or all one bits matching the top bit of the one
+// after, which checkInteger rejects.
+func minimalInteger(bytes []byte) []byte {
+	for len(bytes) > 1 && (bytes[0] == 0x00 && bytes[1]&0x80 == 0 || bytes[0] == 0xff && bytes[1]&0x80 != 0) {
+		bytes = bytes[1:]
+	}
+	return bytes
+}
+
+// integer returns the contents bytes of an INTEGER or ENUMERATED ready to be
+// parsed. Unless d.DER is set redundant leading octets, which some BER
+// encoders write, are allowed and removed.
+func (d decoder) integer(bytes []byte) []byte {
+	if d.DER {
+		return bytes
+	}
+	return minimalInteger(bytes)
+}
+
 // parseInt64 treats the given bytes as a big-endian, signed integer and
 // returns the result.
 func parseInt64(bytes []byte) (ret int64, err error) {
@@ -112,6 +197,33 @@ func parseInt32(bytes []byte) (int32, error) {
 	return int32(ret64), nil
 }
 
+// parseUint64 treats the given bytes as a big-endian, signed integer and
+// returns the result, which must not be negative.
+func parseUint64(bytes []byte) (ret uint64, err error) {
+	err = checkInteger(bytes)
+	if err != nil {
+		return
+	}
+	if bytes[0]&0x80 == 0x80 {
+		err = asn1.StructuralError{Msg: "negative integer for unsigned type"}
+		return
+	}
+	if bytes[0] == 0 {
+		// The leading zero octet keeps the top bit of a large value
+		// from making it negative.
+		bytes = bytes[1:]
+	}
+	if len(bytes) > 8 {
+		err = asn1.StructuralError{Msg: "integer too large"}
+		return
+	}
+	for _, b := range bytes {
+		ret <<= 8
+		ret |= uint64(b)
+	}
+	return
+}
+
 var bigOne = big.NewInt(1)
 
 // parseBigInt treats the given bytes as a big-endian, signed integer and returns
@@ -136,26 +248,228 @@ func parseBigInt(bytes []byte) (*big.Int, error) {
 	return ret, nil
 }
 
+// REAL
+
+// parseReal parses an ASN.1 REAL from the given bytes, in any of the binary,
+// decimal or special value forms described in X.690 section 8.5, and returns
+// it.
+func parseReal(bytes []byte) (float64, error) {
+	if len(bytes) == 0 {
+		return 0, nil
+	}
+
+	b := bytes[0]
+	switch {
+	case b&0x80 != 0:
+		return parseBinaryReal(bytes)
+	case b&0xc0 == 0:
+		s, err := parseDecimalReal(bytes)
+		if err != nil {
+			return 0, err
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return 0, asn1.StructuralError{Msg: "invalid REAL"}
+		}
+		return f, nil
+	}
+
+	if len(bytes) != 1 {
+		return 0, asn1.StructuralError{Msg: "invalid REAL special value"}
+	}
+	switch b {
+	case 0x40:
+		return math.Inf(1), nil
+	case 0x41:
+		return math.Inf(-1), nil
+	case 0x42:
+		return math.NaN(), nil
+	case 0x43:
+		return math.Copysign(0, -1), nil
+	}
+	return 0, asn1.StructuralError{Msg: "invalid REAL special value"}
+}
+
+// parseRealRat parses an ASN.1 REAL from the given bytes, as parseReal does,
+// and returns it as an exact fraction. The special values other than minus
+// zero can't be represented.
+func parseRealRat(bytes []byte) (*big.Rat, error) {
+	if len(bytes) > 0 && bytes[0]&0xc0 == 0 {
+		s, err := parseDecimalReal(bytes)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return nil, asn1.StructuralError{Msg: "invalid REAL"}
+		}
+		return r, nil
+	}
+
+	f, err := parseReal(bytes)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, asn1.StructuralError{Msg: "REAL special value can't be a big.Rat"}
+	}
+	return new(big.Rat).SetFloat64(f), nil
+}
+
+// parseBinaryReal parses the binary encoding of an ASN.1 REAL. The first
+// octet gives the sign, base, scale factor and the format of the exponent:
+// the value is then mantissa * 2^scale * base^exponent.
+func parseBinaryReal(bytes []byte) (float64, error) {
+	b := bytes[0]
+	var baseBits int
+	switch (b >> 4) & 0x03 {
+	case 0:
+		baseBits = 1
+	case 1:
+		baseBits = 3
+	case 2:
+		baseBits = 4
+	default:
+		return 0, asn1.StructuralError{Msg: "invalid REAL base"}
+	}
+	scale := int((b >> 2) & 0x03)
+
+	offset := 1
+	expLen := int(b&0x03) + 1
+	if expLen == 4 {
+		if len(bytes) < 2 || bytes[1] == 0 {
+			return 0, asn1.StructuralError{Msg: "invalid REAL exponent"}
+		}
+		expLen = int(bytes[1])
+		offset++
+	}
+	if len(bytes) < offset+expLen {
+		return 0, asn1.StructuralError{Msg: "truncated REAL exponent"}
+	}
+	if expLen > 8 {
+		return 0, asn1.StructuralError{Msg: "REAL exponent too large"}
+	}
+	exp, err := parseInt64(bytes[offset : offset+expLen])
+	if err != nil {
+		return 0, asn1.StructuralError{Msg: "invalid REAL exponent"}
+	}
+	offset += expLen
+
+	if offset == len(bytes) {
+		return 0, asn1.StructuralError{Msg: "missing REAL mantissa"}
+	}
+	mantissa := new(big.Int).SetBytes(bytes[offset:])
+
+	// Any exponent outside of this range over or underflows a float64, so
+	// clamping it keeps the arithmetic below in range of an int.
+	const maxExp = 1 << 20
+	exp = exp*int64(baseBits) + int64(scale)
+	if exp > maxExp {
+		exp = maxExp
+	} else if exp < -maxExp {
+		exp = -maxExp
+	}
+
+	f := new(big.Float).SetInt(mantissa)
+	f.SetMantExp(f, int(exp))
+	ret, _ := f.Float64()
+	if b&0x40 != 0 {
+		ret = -ret
+	}
+	return ret, nil
+}
+
+// parseDecimalReal checks that the given bytes are a decimal encoding of an
+// ASN.1 REAL, in ISO 6093 NR1, NR2 or NR3 form, and returns the number in a
+// form accepted by the strconv and math/big packages.
+func parseDecimalReal(bytes []byte) (string, error) {
+	form := bytes[0] & 0x3f
+	if form < 1 || form > 3 {
+		return "", asn1.StructuralError{Msg: "invalid REAL decimal form"}
+	}
+
+	s := strings.TrimLeft(string(bytes[1:]), " ")
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	digits := 0
+	for ; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+		digits++
+	}
+	if form >= 2 && i < len(s) && (s[i] == '.' || s[i] == ',') {
+		s = s[:i] + "." + s[i+1:]
+		for i++; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return "", asn1.StructuralError{Msg: "invalid REAL mantissa"}
+	}
+	if form == 3 {
+		if i == len(s) || (s[i] != 'E' && s[i] != 'e') {
+			return "", asn1.StructuralError{Msg: "invalid REAL exponent"}
+		}
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		start := i
+		for ; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+		}
+		if i == start {
+			return "", asn1.StructuralError{Msg: "invalid REAL exponent"}
+		}
+	}
+	if i != len(s) {
+		return "", asn1.StructuralError{Msg: "invalid REAL mantissa"}
+	}
+	return s, nil
+}
+
 // BIT STRING
 
 // parseBitString parses an ASN.1 bit string from the given byte slice and returns it.
 func parseBitString(bytes []byte) (ret asn1.BitString, err error) {
+	// DER requires the unused bits of the final octet to be zero, see
+	// X.690 section 11.2.1.
+	if ret, err = parseLenientBitString(bytes); err == nil && bytes[len(bytes)-1]&(1<<bytes[0]-1) != 0 {
+		return asn1.BitString{}, asn1.StructuralError{Msg: "non-zero unused bits in BIT STRING"}
+	}
+	return
+}
+
+// parseLenientBitString parses a BIT STRING as BER allows, with any value for
+// the unused bits of the final octet. They are cleared in the result, which
+// then no longer refers to bytes.
+func parseLenientBitString(bytes []byte) (ret asn1.BitString, err error) {
 	if len(bytes) == 0 {
 		err = asn1.SyntaxError{Msg: "zero length BIT STRING"}
 		return
 	}
 	paddingBits := int(bytes[0])
-	if paddingBits > 7 ||
-		len(bytes) == 1 && paddingBits > 0 ||
-		bytes[len(bytes)-1]&((1<<bytes[0])-1) != 0 {
+	if paddingBits > 7 || len(bytes) == 1 && paddingBits > 0 {
 		err = asn1.SyntaxError{Msg: "invalid padding bits in BIT STRING"}
 		return
 	}
 	ret.BitLength = (len(bytes)-1)*8 - paddingBits
 	ret.Bytes = bytes[1:]
+	if mask := byte(1<<paddingBits - 1); paddingBits > 0 && ret.Bytes[len(ret.Bytes)-1]&mask != 0 {
+		ret.Bytes = append([]byte(nil), ret.Bytes...)
+		ret.Bytes[len(ret.Bytes)-1] &^= mask
+	}
 	return
 }
 
+// parseBitString parses a BIT STRING, allowing non-zero unused bits unless
+// d.DER is set.
+func (d decoder) parseBitString(bytes []byte) (asn1.BitString, error) {
+	if d.DER {
+		return parseBitString(bytes)
+	}
+	return parseLenientBitString(bytes)
+}
+
 // OBJECT IDENTIFIER
 
 // parseObjectIdentifier parses an OBJECT IDENTIFIER from the given bytes and
@@ -231,6 +545,10 @@ func parseBase128Int(bytes []byte, initOffset int) (ret, offset int, err error)
 func _parseBase128Int(bytes []byte, initOffset int) (ret, offset int, err error) {
 	offset = initOffset
 	for shifted := 0; offset < len(bytes); shifted++ {
+		if ret > math.MaxInt>>7 {
+			err = asn1.StructuralError{Msg: "OBJECT IDENTIFIER arc too large for an int"}
+			return
+		}
 		ret <<= 7
 		b := bytes[offset]
 		ret |= int(b & 0x7f)
@@ -255,11 +573,12 @@ func parseUTCTime(bytes []byte) (ret time.Time, err error) {
 		ret, err = time.Parse(formatStr, s)
 	}
 	if err != nil {
+		err = asn1.SyntaxError{Msg: err.Error()}
 		return
 	}
 
 	if serialized := ret.Format(formatStr); serialized != s {
-		err = fmt.Errorf("asn1: time did not serialize back to the original value and may be invalid: given %q, but serialized as %q", s, serialized)
+		err = asn1.SyntaxError{Msg: fmt.Sprintf("time did not serialize back to the original value and may be invalid: given %q, but serialized as %q", s, serialized)}
 		return
 	}
 
@@ -271,20 +590,95 @@ func parseUTCTime(bytes []byte) (ret time.Time, err error) {
 	return
 }
 
+// parseUTCTime parses a UTCTime as the parseUTCTime function does. DER
+// requires the seconds to be given and the time to be in UTC, so that the
+// only form is YYMMDDHHMMSSZ; see X.690 section 11.8.
+func (d decoder) parseUTCTime(bytes []byte) (time.Time, error) {
+	if d.DER && (len(bytes) != len("060102150405Z") || bytes[len(bytes)-1] != 'Z') {
+		return time.Time{}, asn1.StructuralError{Msg: "UTCTime not in the form YYMMDDHHMMSSZ"}
+	}
+	return parseUTCTime(bytes)
+}
+
+// parseTimeLayout parses the contents of a GeneralizedTime written with the
+// given Go layout, from the timelayout tag, rather than the standard form.
+func parseTimeLayout(bytes []byte, layout string) (ret time.Time, err error) {
+	if ret, err = time.Parse(layout, string(bytes)); err != nil {
+		err = asn1.SyntaxError{Msg: err.Error()}
+	}
+	return
+}
+
 // parseGeneralizedTime parses the GeneralizedTime from the given byte slice
-// and returns the resulting time.
+// and returns the resulting time. The seconds may have a fraction of any
+// number of digits, which is truncated to a whole number of nanoseconds.
 func parseGeneralizedTime(bytes []byte) (ret time.Time, err error) {
-	const formatStr = "20060102150405.999999999Z0700"
+	const formatStr = "20060102150405Z0700"
 	s := string(bytes)
 
+	// time.Parse only reads up to nine fractional digits, so the fraction
+	// is taken out and added back afterwards.
+	nsec := 0
+	if i := strings.IndexAny(s, ".,"); i >= 0 {
+		j := i + 1
+		for j < len(s) && '0' <= s[j] && s[j] <= '9' {
+			j++
+		}
+		if i != len("20060102150405") || j == i+1 {
+			err = asn1.SyntaxError{Msg: "invalid GeneralizedTime fraction"}
+			return
+		}
+		nsec, _ = strconv.Atoi((s[i+1:j] + "00000000")[:9])
+		s = s[:i] + s[j:]
+	}
+
 	if ret, err = time.Parse(formatStr, s); err != nil {
+		err = asn1.SyntaxError{Msg: err.Error()}
 		return
 	}
 
 	if serialized := ret.Format(formatStr); serialized != s {
-		err = fmt.Errorf("asn1: time did not serialize back to the original value and may be invalid: given %q, but serialized as %q", s, serialized)
+		err = asn1.SyntaxError{Msg: fmt.Sprintf("time did not serialize back to the original value and may be invalid: given %q, but serialized as %q", s, serialized)}
+		return
+	}
+
+	ret = ret.Add(time.Duration(nsec))
+	return
+}
+
+// parseGeneralizedTime parses a GeneralizedTime as the parseGeneralizedTime
+// function does. DER requires the time to be in UTC and any fraction of a
+// second to follow a '.' without trailing zeros; see X.690 section 11.7.
+func (d decoder) parseGeneralizedTime(bytes []byte) (time.Time, error) {
+	if d.DER {
+		s := string(bytes)
+		frac := ""
+		if i := strings.IndexAny(s, ".,"); i >= 0 {
+			frac = strings.TrimSuffix(s[i:], "Z")
+		}
+		if !strings.HasSuffix(s, "Z") || strings.HasPrefix(frac, ",") || strings.HasSuffix(frac, "0") || frac == "." {
+			return time.Time{}, asn1.StructuralError{Msg: "GeneralizedTime not in the form YYYYMMDDHHMMSS[.f]Z"}
+		}
 	}
+	return parseGeneralizedTime(bytes)
+}
+
+// DATE, TIME-OF-DAY and DATE-TIME
+
+// parseTimeType parses a DATE, TIME-OF-DAY or DATE-TIME, given by tag, from
+// the given byte slice. The time is returned in UTC; a TIME-OF-DAY is on
+// January 1 of year 0.
+func parseTimeType(bytes []byte, tag int) (ret time.Time, err error) {
+	layout := timeTypeLayouts[tag]
+	s := string(bytes)
 
+	ret, err = time.Parse(layout, s)
+	if err == nil && ret.Format(layout) != s {
+		err = errors.New("not in canonical form")
+	}
+	if err != nil {
+		err = asn1.StructuralError{Msg: fmt.Sprintf("invalid %s %q", universalTagName[tag], s)}
+	}
 	return
 }
 
@@ -372,10 +766,34 @@ func parseIA5String(bytes []byte) (ret string, err error) {
 	return
 }
 
+// VisibleString
+
+// parseVisibleString parses an ASN.1 VisibleString (ISO 646 printable
+// characters) from the given byte slice and returns it.
+func parseVisibleString(bytes []byte) (ret string, err error) {
+	for _, b := range bytes {
+		if !isVisible(rune(b)) {
+			err = asn1.SyntaxError{Msg: fmt.Sprintf("VisibleString contains invalid character %q", b)}
+			return
+		}
+	}
+	ret = string(bytes)
+	return
+}
+
+// isVisible reports whether the given r is in the ASN.1 VisibleString set.
+func isVisible(r rune) bool {
+	return 0x20 <= r && r <= 0x7e
+}
+
 // T61String
 
 // parseT61String parses an ASN.1 T61String (8-bit clean string) from the given
 // byte slice and returns it.
+//
+// The bytes are not transcoded: a character outside of ASCII, whether T.61 or
+// Latin-1 encoded, is kept as the original byte values rather than being
+// converted to UTF-8. Callers needing UTF-8 should convert the result.
 func parseT61String(bytes []byte) (ret string, err error) {
 	return string(bytes), nil
 }
@@ -386,7 +804,7 @@ func parseT61String(bytes []byte) (ret string, err error) {
 // array and returns it.
 func parseUTF8String(bytes []byte) (ret string, err error) {
 	if !utf8.Valid(bytes) {
-		return "", errors.New("asn1: invalid UTF-8 string")
+		return "", asn1.SyntaxError{Msg: "invalid UTF-8 string"}
 	}
 	return string(bytes), nil
 }
@@ -397,7 +815,7 @@ func parseUTF8String(bytes []byte) (ret string, err error) {
 // ISO/IEC/ITU 10646-1) from the given byte slice and returns it.
 func parseBMPString(bmpString []byte) (string, error) {
 	if len(bmpString)%2 != 0 {
-		return "", errors.New("pkcs12: odd-length BMP string")
+		return "", asn1.StructuralError{Msg: "BMPString has odd length"}
 	}
 
 	// Strip terminator if present.
@@ -405,22 +823,163 @@ func parseBMPString(bmpString []byte) (string, error) {
 		bmpString = bmpString[:l-2]
 	}
 
-	s := make([]uint16, 0, len(bmpString)/2)
+	s := make([]rune, 0, len(bmpString)/2)
 	for len(bmpString) > 0 {
-		s = append(s, uint16(bmpString[0])<<8+uint16(bmpString[1]))
+		r := rune(bmpString[0])<<8 + rune(bmpString[1])
+		// BMPString is UCS-2, surrogates (and so characters outside of
+		// the Basic Multilingual Plane) cannot appear in it.
+		if utf16.IsSurrogate(r) {
+			return "", asn1.StructuralError{Msg: "BMPString contains surrogate"}
+		}
+		s = append(s, r)
 		bmpString = bmpString[2:]
 	}
 
-	return string(utf16.Decode(s)), nil
+	return string(s), nil
+}
+
+// UniversalString
+
+// parseUniversalString parses an ASN.1 UniversalString (UCS-4 encoded
+// ISO/IEC/ITU 10646-1) from the given byte slice and returns it.
+func parseUniversalString(bytes []byte) (string, error) {
+	if len(bytes)%4 != 0 {
+		return "", asn1.StructuralError{Msg: fmt.Sprintf("UniversalString length %d is not a multiple of four", len(bytes))}
+	}
+
+	s := make([]rune, 0, len(bytes)/4)
+	for i := 0; i < len(bytes); i += 4 {
+		r := uint32(bytes[i])<<24 | uint32(bytes[i+1])<<16 | uint32(bytes[i+2])<<8 | uint32(bytes[i+3])
+		if r > unicode.MaxRune || utf16.IsSurrogate(rune(r)) {
+			return "", asn1.StructuralError{Msg: fmt.Sprintf("UniversalString contains invalid code point %#x in octet %d", r, i)}
+		}
+		s = append(s, rune(r))
+	}
+
+	return string(s), nil
+}
+
+// Constructed strings
+
+// walkConstructedString calls fn with the contents of each primitive segment
+// of a constructed string encoding with the given universal tag. Segments may
+// themselves be constructed, see X.690 section 8.7.3, to at most maxDepth
+// levels.
+func walkConstructedString(bytes []byte, tag, maxDepth int, fn func(segment []byte) error) error {
+	if maxDepth <= 0 {
+		return errNestingTooDeep
+	}
+	for offset := 0; offset < len(bytes); {
+		t, next, err := parseTagAndLengthDepth(bytes, offset, maxDepth)
+		if err != nil {
+			return err
+		}
+		if t.class != asn1.ClassUniversal || t.tag != tag {
+			return asn1.StructuralError{Msg: "constructed string contains invalid segment"}
+		}
+		if invalidLength(next, t.length, len(bytes)) {
+			return asn1.SyntaxError{Msg: "data truncated"}
+		}
+		segment := bytes[next : next+t.length]
+		if t.isCompound {
+			err = walkConstructedString(segment, tag, maxDepth-1, fn)
+		} else {
+			err = fn(segment)
+		}
+		if err != nil {
+			return err
+		}
+		offset = next + t.length
+		if t.isIndefinite {
+			offset += 2
+		}
+	}
+	return nil
+}
+
+// errStringTooLarge is returned when the segments of a constructed string
+// together exceed UnmarshalOptions.MaxElementSize.
+var errStringTooLarge = asn1.StructuralError{Msg: "constructed string too large"}
+
+// appendConstructedString appends the contents of the segments of a
+// constructed string encoding, with the given universal tag, to dst. If
+// maxSize is positive they may total at most maxSize bytes.
+func appendConstructedString(dst, bytes []byte, tag, maxDepth, maxSize int) ([]byte, error) {
+	start := len(dst)
+	err := walkConstructedString(bytes, tag, maxDepth, func(segment []byte) error {
+		if maxSize > 0 && len(segment) > maxSize-(len(dst)-start) {
+			return errStringTooLarge
+		}
+		dst = append(dst, segment...)
+		return nil
+	})
+	return dst, err
+}
+
+// allowConstructed reports whether the constructed encoding of a string with
+// the given universal tag is accepted.
+func (d decoder) allowConstructed(tag int) bool {
+	if d.DER {
+		return false
+	}
+	switch tag {
+	case asn1.TagOctetString, asn1.TagBitString:
+		return true
+	case asn1.TagPrintableString, asn1.TagIA5String, asn1.TagGeneralString, asn1.TagT61String, asn1.TagUTF8String, asn1.TagNumericString, asn1.TagBMPString, tagUniversalString, tagVisibleString:
+		return d.LenientCompound
+	}
+	return false
+}
+
+// parseConstructedBitString joins the segments of a constructed BIT STRING.
+// Only the final segment may have unused bits. If maxSize is positive the
+// segments may total at most maxSize bytes.
+func parseConstructedBitString(bytes []byte, maxDepth, maxSize int) (ret asn1.BitString, err error) {
+	paddingBits := 0
+	err = walkConstructedString(bytes, asn1.TagBitString, maxDepth, func(segment []byte) error {
+		if paddingBits != 0 {
+			return asn1.StructuralError{Msg: "unused bits in non-final segment of BIT STRING"}
+		}
+		s, err := parseLenientBitString(segment)
+		if err != nil {
+			return err
+		}
+		if maxSize > 0 && len(s.Bytes) > maxSize-len(ret.Bytes) {
+			return errStringTooLarge
+		}
+		paddingBits = len(s.Bytes)*8 - s.BitLength
+		ret.Bytes = append(ret.Bytes, s.Bytes...)
+		return nil
+	})
+	if err != nil {
+		return asn1.BitString{}, err
+	}
+	ret.BitLength = len(ret.Bytes)*8 - paddingBits
+	return
 }
 
 // Tagging
 
+// errNestingTooDeep is returned when constructed elements are nested more
+// deeply than allowed.
+var errNestingTooDeep = asn1.StructuralError{Msg: "nesting too deep"}
+
+// defaultMaxDepth is the depth to which constructed elements may be nested if
+// UnmarshalOptions.MaxDepth isn't set.
+const defaultMaxDepth = 128
+
 // parseTagAndLength parses an ASN.1 tag and length pair from the given offset
 // into a byte slice. It returns the parsed data and the new offset. SET and
 // SET OF (tag 17) are mapped to SEQUENCE and SEQUENCE OF (tag 16) since we
 // don't distinguish between ordered and unordered objects in this code.
 func parseTagAndLength(bytes []byte, initOffset int) (ret tagAndLength, offset int, err error) {
+	return parseTagAndLengthDepth(bytes, initOffset, defaultMaxDepth)
+}
+
+// parseTagAndLengthDepth is parseTagAndLength with elements within one of
+// indefinite length, whose end has to be found, nested to at most maxDepth
+// levels.
+func parseTagAndLengthDepth(bytes []byte, initOffset, maxDepth int) (ret tagAndLength, offset int, err error) {
 	offset = initOffset
 	// parseTagAndLength should not be called without at least a single
 	// byte to read. Thus this check is for robustness:
@@ -438,6 +997,9 @@ func parseTagAndLength(bytes []byte, initOffset int) (ret tagAndLength, offset i
 	// encoded afterwards
 	if ret.tag == 0x1f {
 		ret.tag, offset, err = parseBase128Int(bytes, offset)
+		if err == (asn1.SyntaxError{Msg: "truncated base 128 integer"}) {
+			err = truncatedError{"truncated tag or length"}
+		}
 		if err != nil {
 			return
 		}
@@ -448,11 +1010,16 @@ func parseTagAndLength(bytes []byte, initOffset int) (ret tagAndLength, offset i
 		}
 	}
 	if offset >= len(bytes) {
-		err = asn1.SyntaxError{Msg: "truncated tag or length"}
+		err = truncatedError{"truncated tag or length"}
 		return
 	}
 	b = bytes[offset]
 	offset++
+	if b == 0xff {
+		// X.690 section 8.1.3.5 reserves this value.
+		err = asn1.StructuralError{Msg: "reserved length octet"}
+		return
+	}
 	if b&0x80 == 0 {
 		// The length is encoded in the bottom 7 bits.
 		ret.length = int(b & 0x7f)
@@ -465,6 +1032,10 @@ func parseTagAndLength(bytes []byte, initOffset int) (ret tagAndLength, offset i
 				return
 			}
 			ret.isIndefinite = true
+			if maxDepth <= 0 {
+				err = errNestingTooDeep
+				return
+			}
 			innerOffset := offset
 			for innerOffset <= (len(bytes) - 2) {
 				if bytes[innerOffset] == 0x00 && bytes[innerOffset+1] == 0x00 {
@@ -472,89 +1043,209 @@ func parseTagAndLength(bytes []byte, initOffset int) (ret tagAndLength, offset i
 					return
 				}
 				var t tagAndLength
-				t, innerOffset, err = parseTagAndLength(bytes, innerOffset)
+				t, innerOffset, err = parseTagAndLengthDepth(bytes, innerOffset, maxDepth-1)
 				if err != nil {
 					return
 				}
+				if invalidLength(innerOffset, t.length, len(bytes)) {
+					err = truncatedError{"data truncated"}
+					return
+				}
 				innerOffset += t.length
 				if t.isIndefinite {
 					innerOffset += 2
 				}
 			}
-			err = asn1.SyntaxError{Msg: "missing end-of-contents octets"}
+			err = truncatedError{"missing end-of-contents octets"}
 			return
 		}
-		ret.length = 0
+		// The length is read as an int64, whatever the size of an
+		// int, so that it can be checked before it is used.
+		var length int64
 		for i := 0; i < numBytes; i++ {
 			if offset >= len(bytes) {
-				err = asn1.SyntaxError{Msg: "truncated tag or length"}
+				err = truncatedError{"truncated tag or length"}
 				return
 			}
 			b = bytes[offset]
 			offset++
-			if ret.length >= 1<<23 {
-				// We can't shift ret.length up without
+			if length > math.MaxInt64>>8 {
+				// We can't shift length up without
 				// overflowing.
 				err = asn1.StructuralError{Msg: "length too large"}
 				return
 			}
-			ret.length <<= 8
-			ret.length |= int(b)
+			length <<= 8
+			length |= int64(b)
+		}
+		if length > math.MaxInt {
+			err = asn1.StructuralError{Msg: "length too large"}
+			return
 		}
+		ret.length = int(length)
 	}
 
 	return
 }
 
+// parseTagAndLength parses a tag and length pair as the function of the same
+// name does, and then checks the length is encoded as DER requires if d.DER is
+// set.
+func (d decoder) parseTagAndLength(bytes []byte, initOffset int) (ret tagAndLength, offset int, err error) {
+	ret, offset, err = parseTagAndLengthDepth(bytes, initOffset, d.remainingDepth()+1)
+	if err != nil {
+		return
+	}
+	if d.MaxElementSize > 0 && !ret.isCompound && ret.length > d.MaxElementSize {
+		err = asn1.StructuralError{Msg: "element too large"}
+		return
+	}
+	if !d.DER {
+		return
+	}
+	if ret.isIndefinite {
+		err = asn1.StructuralError{Msg: "indefinite length in DER"}
+		return
+	}
+	// A length in the long form when the short form would do, or with
+	// leading zero octets, makes the element longer than its minimal
+	// encoding.
+	var scratch [16]byte
+	if len(appendTagAndLength(scratch[:0], ret)) != offset-initOffset {
+		// A tag number in the long form can't start with an octet
+		// contributing no bits, see X.690 section 8.1.2.4.2.
+		if bytes[initOffset]&0x1f == 0x1f && bytes[initOffset+1] == 0x80 {
+			err = asn1.StructuralError{Msg: "non-minimal tag in DER"}
+		} else {
+			err = asn1.StructuralError{Msg: "non-minimal length in DER"}
+		}
+	}
+	return
+}
+
+// A truncatedError reports that the input ended before the element being
+// parsed did. It becomes a SyntaxError matching io.ErrUnexpectedEOF once its
+// position is known, so callers can distinguish input which may yet be
+// completed.
+type truncatedError struct {
+	msg string
+}
+
+func (e truncatedError) Error() string { return asn1.SyntaxError{Msg: e.msg}.Error() }
+
+// A decoder holds the state of a single call to Unmarshal.
+type decoder struct {
+	UnmarshalOptions
+
+	// base is the offset within the input of the bytes being parsed, so
+	// that errors can report their position.
+	base int
+
+	// depth is the number of elements enclosing, and including, the one
+	// being parsed.
+	depth int
+}
+
+// remainingDepth returns the number of levels elements within the one
+// being parsed may be nested to.
+func (d decoder) remainingDepth() int {
+	maxDepth := d.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxDepth
+	}
+	return maxDepth - d.depth
+}
+
+// at returns a decoder for parsing the bytes which start at offset.
+func (d decoder) at(offset int) decoder {
+	d.base += offset
+	return d
+}
+
+// locate records offset, relative to the bytes being parsed, as the position
+// of an error from the parsing functions. Errors which already have a position
+// are returned unchanged so that the innermost element is reported.
+func (d decoder) locate(err error, offset int) error {
+	switch e := err.(type) {
+	case asn1.StructuralError:
+		return StructuralError{Msg: e.Msg, Offset: d.base + offset}
+	case asn1.SyntaxError:
+		return SyntaxError{Msg: e.Msg, Offset: d.base + offset}
+	case truncatedError:
+		return SyntaxError{Msg: e.msg, Offset: d.base + offset, truncated: true}
+	}
+	return err
+}
+
 // parseSequenceOf is used for SEQUENCE OF and SET OF values. It tries to parse
 // a number of ASN.1 values from the given byte slice and returns them as a
-// slice of Go values of the given type.
-func parseSequenceOf(bytes []byte, sliceType reflect.Type, elemType reflect.Type) (ret reflect.Value, err error) {
+// slice of Go values of the given type. The elements of a SEQUENCE OF CHOICE
+// are matched against the alternatives rather than a single tag.
+func (d decoder) parseSequenceOf(bytes []byte, sliceType reflect.Type, elemType reflect.Type, params fieldParameters) (ret reflect.Value, err error) {
 	matchAny, expectedTag, compoundType, ok := getUniversalType(elemType)
-	if !ok {
+	custom := reflect.PointerTo(elemType).Implements(unmarshalerType)
+	matchAny = matchAny || params.choice || custom
+	if !ok && !custom {
 		err = asn1.StructuralError{Msg: "unknown Go type for slice"}
 		return
 	}
 
 	// First we iterate over the input and count the number of elements,
 	// checking that the types are correct in each case.
+	expectedClass := asn1.ClassUniversal
+	if tag, ok := applicationTag(elemType); ok {
+		expectedClass, expectedTag = asn1.ClassApplication, tag
+	}
 	numElements := 0
+	var prev []byte
 	for offset := 0; offset < len(bytes); {
 		var t tagAndLength
-		t, offset, err = parseTagAndLength(bytes, offset)
+		start := offset
+		t, offset, err = d.parseTagAndLength(bytes, offset)
 		if err != nil {
+			err = d.locate(err, start)
 			return
 		}
 		switch t.tag {
-		case asn1.TagIA5String, asn1.TagGeneralString, asn1.TagT61String, asn1.TagUTF8String, asn1.TagNumericString, asn1.TagBMPString:
+		case asn1.TagIA5String, asn1.TagGeneralString, asn1.TagT61String, asn1.TagUTF8String, asn1.TagNumericString, asn1.TagBMPString, tagUniversalString, tagVisibleString:
 			// We pretend that various other string types are
 			// PRINTABLE STRINGs so that a sequence of them can be
 			// parsed into a []string.
 			t.tag = asn1.TagPrintableString
-		case asn1.TagGeneralizedTime, asn1.TagUTCTime:
-			// Likewise, both time types are treated the same.
+		case asn1.TagGeneralizedTime, asn1.TagUTCTime, tagDate, tagTimeOfDay, tagDateTime:
+			// Likewise, the time types are treated the same.
 			t.tag = asn1.TagUTCTime
 		}
 
-		if !matchAny && (t.class != asn1.ClassUniversal || t.isCompound != compoundType || t.tag != expectedTag) {
-			err = asn1.StructuralError{Msg: "sequence tag mismatch"}
+		constructedString := t.isCompound && d.allowConstructed(t.tag)
+		if !matchAny && (t.class != expectedClass || t.isCompound != compoundType && !constructedString || t.tag != expectedTag) {
+			err = d.locate(asn1.StructuralError{Msg: "sequence tag mismatch"}, start)
 			return
 		}
 		if invalidLength(offset, t.length, len(bytes)) {
-			err = asn1.SyntaxError{Msg: "truncated sequence"}
+			err = d.locate(asn1.SyntaxError{Msg: "truncated sequence"}, start)
 			return
 		}
 		offset += t.length
 		if t.isIndefinite {
 			offset += 2
 		}
+		// DER requires the elements of a SET OF to be sorted by their
+		// encodings, see X.690 section 11.6.
+		if d.DER && params.set {
+			if slices.Compare(prev, bytes[start:offset]) > 0 {
+				err = d.locate(asn1.StructuralError{Msg: "SET OF elements not in ascending order"}, start)
+				return
+			}
+			prev = bytes[start:offset]
+		}
 		numElements++
 	}
 	ret = reflect.MakeSlice(sliceType, numElements, numElements)
-	params := fieldParameters{}
+	elemParams := fieldParameters{choice: params.choice}
 	offset := 0
 	for i := 0; i < numElements; i++ {
-		offset, err = parseField(ret.Index(i), bytes, offset, params)
+		offset, err = d.parseField(ret.Index(i), bytes, offset, elemParams)
 		if err != nil {
 			return
 		}
@@ -563,14 +1254,27 @@ func parseSequenceOf(bytes []byte, sliceType reflect.Type, elemType reflect.Type
 }
 
 var (
-	bitStringType        = reflect.TypeOf(asn1.BitString{})
-	objectIdentifierType = reflect.TypeOf(asn1.ObjectIdentifier{})
-	enumeratedType       = reflect.TypeOf(asn1.Enumerated(0))
-	flagType             = reflect.TypeOf(asn1.Flag(false))
-	timeType             = reflect.TypeOf(time.Time{})
-	rawValueType         = reflect.TypeOf(asn1.RawValue{})
-	rawContentsType      = reflect.TypeOf(asn1.RawContent(nil))
-	bigIntType           = reflect.TypeOf((*big.Int)(nil))
+	bitStringType         = reflect.TypeOf(asn1.BitString{})
+	boolSliceType         = reflect.TypeOf([]bool{})
+	objectIdentifierType  = reflect.TypeOf(asn1.ObjectIdentifier{})
+	bigOIDType            = reflect.TypeOf(BigObjectIdentifier{})
+	relativeOIDType       = reflect.TypeOf(RelativeOID{})
+	enumeratedType        = reflect.TypeOf(asn1.Enumerated(0))
+	flagType              = reflect.TypeOf(asn1.Flag(false))
+	timeType              = reflect.TypeOf(time.Time{})
+	rawValueType          = reflect.TypeOf(asn1.RawValue{})
+	rawValueSliceType     = reflect.TypeOf([]asn1.RawValue(nil))
+	rawContentsType       = reflect.TypeOf(asn1.RawContent(nil))
+	bigIntType            = reflect.TypeOf((*big.Int)(nil))
+	ratType               = reflect.TypeOf((*big.Rat)(nil))
+	ipType                = reflect.TypeOf(net.IP(nil))
+	durationType          = reflect.TypeOf(time.Duration(0))
+	uuidType              = reflect.TypeOf(UUID{})
+	ipAddressType         = reflect.TypeOf(IPAddress{})
+	isoDurationType       = reflect.TypeOf(Duration{})
+	streamOctetStringType = reflect.TypeOf(StreamOctetString{})
+	extensionMarkerType   = reflect.TypeOf(ExtensionMarker{})
+	rawTailType           = reflect.TypeOf(RawTail(nil))
 )
 
 // invalidLength reports whether offset + length > sliceLength, or if the
@@ -582,10 +1286,20 @@ func invalidLength(offset, length, sliceLength int) bool {
 // parseField is the main parsing function. Given a byte slice and an offset
 // into the array, it will try to parse a suitable ASN.1 value out and store it
 // in the given Value.
-func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParameters) (offset int, err error) {
+func (d decoder) parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParameters) (offset int, err error) {
+	defer func() {
+		if err != nil {
+			err = d.locate(err, initOffset)
+		}
+	}()
+
 	offset = initOffset
 	fieldType := v.Type()
 
+	if fieldType.Kind() == reflect.Pointer && fieldType != bigIntType && fieldType != ratType {
+		return d.parsePointer(v, bytes, initOffset, params)
+	}
+
 	// If we have run out of data, it may be that there are optional elements at the end.
 	if offset == len(bytes) {
 		if !setDefaultValue(v, params) {
@@ -594,10 +1308,23 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 		return
 	}
 
+	if d.depth++; d.remainingDepth() < 0 {
+		err = errNestingTooDeep
+		return
+	}
+
+	if len(params.outerTags) > 0 {
+		return d.parseOuterTag(v, bytes, initOffset, params)
+	}
+
+	if u, ok := unmarshaler(v); ok {
+		return d.parseUnmarshaler(u, v, bytes, initOffset, params)
+	}
+
 	// Deal with the ANY type.
 	if ifaceType := fieldType; ifaceType.Kind() == reflect.Interface && ifaceType.NumMethod() == 0 {
 		var t tagAndLength
-		t, offset, err = parseTagAndLength(bytes, offset)
+		t, offset, err = d.parseTagAndLength(bytes, offset)
 		if err != nil {
 			return
 		}
@@ -605,9 +1332,12 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 			err = asn1.SyntaxError{Msg: "data truncated"}
 			return
 		}
+		if typ, ok := lookupChoiceType(t.class, t.tag); ok {
+			return d.parseChoiceType(v, typ, bytes, initOffset, t.class, t.tag)
+		}
 		var result any
+		innerBytes := bytes[offset : offset+t.length]
 		if !t.isCompound && t.class == asn1.ClassUniversal {
-			innerBytes := bytes[offset : offset+t.length]
 			switch t.tag {
 			case asn1.TagPrintableString:
 				result, err = parsePrintableString(innerBytes)
@@ -620,21 +1350,27 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 			case asn1.TagUTF8String:
 				result, err = parseUTF8String(innerBytes)
 			case asn1.TagInteger:
-				result, err = parseInt64(innerBytes)
+				result, err = parseInt64(d.integer(innerBytes))
+			case tagReal:
+				result, err = parseReal(innerBytes)
 			case asn1.TagBitString:
-				result, err = parseBitString(innerBytes)
+				result, err = d.parseBitString(innerBytes)
 			case asn1.TagOID:
 				result, err = parseObjectIdentifier(innerBytes)
 			case asn1.TagUTCTime:
-				result, err = parseUTCTime(innerBytes)
+				result, err = d.parseUTCTime(innerBytes)
 			case asn1.TagGeneralizedTime:
-				result, err = parseGeneralizedTime(innerBytes)
+				result, err = d.parseGeneralizedTime(innerBytes)
 			case asn1.TagOctetString:
 				result = innerBytes
 			case asn1.TagBMPString:
 				result, err = parseBMPString(innerBytes)
-			default:
-				// If we don't know how to handle the type, we just leave Value as nil.
+			case tagUniversalString:
+				result, err = parseUniversalString(innerBytes)
+			case tagVisibleString:
+				result, err = parseVisibleString(innerBytes)
+			case asn1.TagGeneralString:
+				result, err = parseT61String(innerBytes)
 			}
 		}
 		offset += t.length
@@ -644,22 +1380,35 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 		if err != nil {
 			return
 		}
-		if result != nil {
-			v.Set(reflect.ValueOf(result))
+		if result == nil {
+			// An element of a type we don't know how to handle is kept
+			// as a RawValue, unless a registered type is required.
+			if d.RequireChoiceType {
+				err = params.structuralError(fmt.Sprintf("no type registered for tag %d of class %d", t.tag, t.class))
+				return
+			}
+			result = asn1.RawValue{Class: t.class, Tag: t.tag, IsCompound: t.isCompound, Bytes: innerBytes, FullBytes: bytes[initOffset:offset]}
 		}
+		v.Set(reflect.ValueOf(result))
 		return
 	}
 
-	t, offset, err := parseTagAndLength(bytes, offset)
+	// A CHOICE is a struct whose fields are the alternatives. A tagged
+	// CHOICE is always explicitly tagged.
+	if params.choice && fieldType.Kind() == reflect.Struct {
+		if params.tag == nil {
+			return d.parseChoice(v, bytes, initOffset, params)
+		}
+		params.explicit = true
+	}
+
+	t, offset, err := d.parseTagAndLength(bytes, offset)
 	if err != nil {
 		return
 	}
 	explicitIsIndefinite := params.explicit && t.isIndefinite
 	if params.explicit {
-		expectedClass := asn1.ClassContextSpecific
-		if params.application {
-			expectedClass = asn1.ClassApplication
-		}
+		expectedClass := params.tagClass()
 		if offset == len(bytes) {
 			err = asn1.StructuralError{Msg: "explicit tag has no child"}
 			return
@@ -667,8 +1416,22 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 		if t.class == expectedClass && t.tag == *params.tag && (t.length == 0 || t.isCompound) {
 			if fieldType == rawValueType {
 				// The inner element should not be parsed for RawValues.
+			} else if params.choice && t.length > 0 {
+				if invalidLength(offset, t.length, len(bytes)) {
+					err = asn1.SyntaxError{Msg: "data truncated"}
+					return
+				}
+				end := offset + t.length
+				if _, err = d.parseChoice(v, bytes[:end], offset, fieldParameters{name: params.name}); err != nil {
+					return
+				}
+				offset = end
+				if t.isIndefinite {
+					offset += 2
+				}
+				return
 			} else if t.length > 0 {
-				t, offset, err = parseTagAndLength(bytes, offset)
+				t, offset, err = d.parseTagAndLength(bytes, offset)
 				if err != nil {
 					return
 				}
@@ -705,7 +1468,7 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 	if universalTag == asn1.TagPrintableString {
 		if t.class == asn1.ClassUniversal {
 			switch t.tag {
-			case asn1.TagIA5String, asn1.TagGeneralString, asn1.TagT61String, asn1.TagUTF8String, asn1.TagNumericString, asn1.TagBMPString:
+			case asn1.TagIA5String, asn1.TagGeneralString, asn1.TagT61String, asn1.TagUTF8String, asn1.TagNumericString, asn1.TagBMPString, tagUniversalString, tagVisibleString:
 				universalTag = t.tag
 			}
 		} else if params.stringType != 0 {
@@ -719,35 +1482,62 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 		universalTag = asn1.TagGeneralizedTime
 	}
 
+	// The DATE, TIME-OF-DAY and DATE-TIME types also map to time.Time,
+	// either by their universal tag or the time type given for an
+	// implicitly tagged field.
+	if universalTag == asn1.TagUTCTime {
+		if _, ok := timeTypeLayouts[t.tag]; ok && t.class == asn1.ClassUniversal {
+			universalTag = t.tag
+		} else if _, ok := timeTypeLayouts[params.timeType]; ok && params.tag != nil && !params.explicit {
+			universalTag = params.timeType
+		}
+	}
+
 	if params.set {
 		universalTag = asn1.TagSet
 	}
 
+	if params.relative && universalTag == asn1.TagOID {
+		universalTag = tagRelativeOID
+	}
+
+	if params.enumerated {
+		if !isIntegerKind(fieldType.Kind()) {
+			err = asn1.StructuralError{Msg: "enumerated given to non-integer member"}
+			return
+		}
+		universalTag = asn1.TagEnum
+	}
+
+	if params.bits {
+		if fieldType != boolSliceType {
+			err = asn1.StructuralError{Msg: "bits given to non-[]bool member"}
+			return
+		}
+		universalTag, compoundType = asn1.TagBitString, false
+	}
+
 	matchAnyClassAndTag := matchAny
 	expectedClass := asn1.ClassUniversal
 	expectedTag := universalTag
-
-	if !params.explicit && params.tag != nil {
-		expectedClass = asn1.ClassContextSpecific
-		expectedTag = *params.tag
-		matchAnyClassAndTag = false
+	if tag, ok := applicationTag(fieldType); ok {
+		expectedClass, expectedTag = asn1.ClassApplication, tag
 	}
 
-	if !params.explicit && params.application && params.tag != nil {
-		expectedClass = asn1.ClassApplication
+	if !params.explicit && params.tag != nil {
+		expectedClass = params.tagClass()
 		expectedTag = *params.tag
 		matchAnyClassAndTag = false
 	}
 
-	if !params.explicit && params.private && params.tag != nil {
-		expectedClass = asn1.ClassPrivate
-		expectedTag = *params.tag
-		matchAnyClassAndTag = false
-	}
+	// BER permits a string to be split into segments carried by a
+	// constructed encoding, DER does not. These are reassembled by
+	// parseFieldContents.
+	constructedString := t.isCompound && d.allowConstructed(universalTag)
 
 	// We have unwrapped any explicit tagging at this point.
 	if !matchAnyClassAndTag && (t.class != expectedClass || t.tag != expectedTag) ||
-		(!matchAny && t.isCompound != compoundType) {
+		(!matchAny && t.isCompound != compoundType && !constructedString) {
 		// Tags don't match. Again, it could be an optional element.
 		ok := setDefaultValue(v, params)
 		if ok {
@@ -761,41 +1551,359 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 		err = asn1.SyntaxError{Msg: "data truncated"}
 		return
 	}
-	err = parseFieldContents(t, v, universalTag, bytes[initOffset:offset+t.length], offset-initOffset)
+	// The full bytes of an indefinite length element include its
+	// end-of-contents octets.
+	end := offset + t.length
+	if t.isIndefinite {
+		end += 2
+	}
+	err = d.at(initOffset).parseFieldContents(t, v, universalTag, bytes[initOffset:end], offset-initOffset, params)
 	if err != nil {
 		return
 	}
-	offset += t.length
-	if t.isIndefinite {
-		offset += 2
+	if err = params.checkConstraints(v); err != nil {
+		return
 	}
+	offset = end
 	if explicitIsIndefinite {
 		offset += 2
 	}
 	return
 }
 
-func parseFieldContents(t tagAndLength, v reflect.Value, universalTag int, bytes []byte, offset int) (err error) {
-	innerBytes := bytes[offset:]
+// parseOuterTag parses the outermost of several explicit tags from the given
+// offset into a byte slice, and then the value it wraps, which must be the only
+// element it contains.
+func (d decoder) parseOuterTag(v reflect.Value, bytes []byte, initOffset int, params fieldParameters) (offset int, err error) {
+	t, offset, err := d.parseTagAndLength(bytes, initOffset)
+	if err != nil {
+		return
+	}
+	if t.class != params.tagClass() || t.tag != params.outerTags[0] || !t.isCompound {
+		// The tags didn't match, it might be an optional element.
+		if !setDefaultValue(v, params) {
+			err = asn1.StructuralError{Msg: "explicitly tagged member didn't match"}
+		}
+		return initOffset, err
+	}
+	if invalidLength(offset, t.length, len(bytes)) {
+		err = asn1.SyntaxError{Msg: "data truncated"}
+		return
+	}
+
+	end := offset + t.length
+	inner := params
+	inner.outerTags = params.outerTags[1:]
+	inner.optional = false
+	next, err := d.parseField(v, bytes[:end], offset, inner)
+	if err != nil {
+		return
+	}
+	if next != end {
+		err = asn1.StructuralError{Msg: "explicit tag contains more than one element"}
+		return
+	}
+	if t.isIndefinite {
+		end += 2
+	}
+	return end, nil
+}
+
+// Unmarshaler is the interface implemented by types that can unmarshal a BER
+// element themselves. It is implemented by a pointer to the type receiving the
+// element, as Unmarshal must be able to modify it.
+//
+// UnmarshalBER is given the tag and class of the element and its complete
+// encoding, tag and length included. The bytes must be copied if they are
+// kept after returning. When the field has an explicit tag the element is the
+// one it wraps, and an implicit tag must match the element's.
+type Unmarshaler interface {
+	UnmarshalBER(tag, class int, fullBytes []byte) error
+}
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+// unmarshaler returns the Unmarshaler for v if a pointer to its type
+// implements the interface.
+func unmarshaler(v reflect.Value) (Unmarshaler, bool) {
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		return v.Addr().Interface().(Unmarshaler), true
+	}
+	return nil, false
+}
+
+// parseSet parses the elements of a SET, in bytes, into the fields of the
+// struct v, matching each element to the first field without one that it
+// can be written to, whatever their order. Each element must match a field
+// unless unmatched is set, when those left over go to the last field as for
+// a SEQUENCE, and a field which isn't optional must be matched.
+func (d decoder) parseSet(v reflect.Value, fields []sequenceField, unmatched bool, bytes []byte) (err error) {
+	var rest []asn1.RawValue
+	var restField sequenceField
+	if unmatched {
+		restField, fields = fields[len(fields)-1], fields[:len(fields)-1]
+	}
+	matched := make([]bool, len(fields))
+	var prev tagAndLength
+	for offset := 0; offset < len(bytes); {
+		start := offset
+		// DER requires the elements in the canonical order of their
+		// tags, see X.690 section 10.3.
+		if d.DER {
+			var t tagAndLength
+			if t, _, err = d.parseTagAndLength(bytes, start); err != nil {
+				return d.locate(err, start)
+			}
+			if start > 0 && compareTags(prev, t) >= 0 {
+				return d.locate(asn1.StructuralError{Msg: "SET elements not in ascending order of tags"}, start)
+			}
+			prev = t
+		}
+		for i, f := range fields {
+			if matched[i] {
+				continue
+			}
+			// An element which doesn't match leaves offset at the
+			// start, as for an absent optional element.
+			params := f.params
+			params.optional = true
+			if offset, err = d.parseField(v.FieldByIndex(f.index), bytes, start, params); err != nil {
+				return
+			}
+			if offset != start {
+				matched[i] = true
+				break
+			}
+		}
+		if offset == start {
+			if !unmatched {
+				return d.locate(asn1.StructuralError{Msg: "SET element unexpected or repeated"}, start)
+			}
+			var rv asn1.RawValue
+			if offset, err = d.parseField(reflect.ValueOf(&rv).Elem(), bytes, start, fieldParameters{}); err != nil {
+				return
+			}
+			rest = append(rest, rv)
+		}
+	}
+	for i, f := range fields {
+		if !matched[i] && !f.params.optional && !f.extension {
+			return d.locate(f.params.structuralError("SET element missing"), 0)
+		}
+	}
+	if unmatched {
+		v.FieldByIndex(restField.index).Set(reflect.ValueOf(rest))
+	}
+	return
+}
+
+// parseUnmatched sets v, a []asn1.RawValue, to the elements from the given
+// offset to the end of a byte slice, in their order there.
+func (d decoder) parseUnmatched(v reflect.Value, bytes []byte, offset int) (err error) {
+	var elements []asn1.RawValue
+	for offset < len(bytes) {
+		var rv asn1.RawValue
+		if offset, err = d.parseField(reflect.ValueOf(&rv).Elem(), bytes, offset, fieldParameters{}); err != nil {
+			return
+		}
+		elements = append(elements, rv)
+	}
+	v.Set(reflect.ValueOf(elements))
+	return
+}
+
+// parseChoiceType parses the element at the given offset into a byte slice,
+// which has the given class and tag, into a new value of the type registered
+// for them, and stores it in the interface v.
+func (d decoder) parseChoiceType(v reflect.Value, typ reflect.Type, bytes []byte, initOffset int, class, tag int) (offset int, err error) {
+	var params fieldParameters
+	params.setClass(class)
+	*params.tag = tag
+	// parseField counts the element's depth again.
+	d.depth--
+	elem := reflect.New(typ).Elem()
+	if offset, err = d.parseField(elem, bytes, initOffset, params); err != nil {
+		return
+	}
+	v.Set(elem)
+	return
+}
+
+// parsePointer parses the element at the given offset into a byte slice into
+// a newly allocated value for the pointer v to point to. If it is an absent
+// optional element v is set to nil instead.
+func (d decoder) parsePointer(v reflect.Value, bytes []byte, initOffset int, params fieldParameters) (offset int, err error) {
+	elem := reflect.New(v.Type().Elem())
+	if offset, err = d.parseField(elem.Elem(), bytes, initOffset, params); err != nil {
+		return
+	}
+	if offset == initOffset {
+		v.SetZero()
+	} else {
+		v.Set(elem)
+	}
+	return
+}
+
+// parseUnmarshaler hands the element at the given offset into a byte slice to
+// the Unmarshaler u for the value v, after checking any tag given for it.
+func (d decoder) parseUnmarshaler(u Unmarshaler, v reflect.Value, bytes []byte, initOffset int, params fieldParameters) (offset int, err error) {
+	t, offset, err := d.parseTagAndLength(bytes, initOffset)
+	if err != nil {
+		return
+	}
+	start, end := initOffset, offset+t.length
+	if invalidLength(offset, t.length, len(bytes)) {
+		err = asn1.SyntaxError{Msg: "data truncated"}
+		return
+	}
+	if t.isIndefinite {
+		end += 2
+	}
+	next := end
+
+	if params.tag != nil {
+		if t.class != params.tagClass() || t.tag != *params.tag {
+			// The tags didn't match, it might be an optional element.
+			if !setDefaultValue(v, params) {
+				err = asn1.StructuralError{Msg: "tagged member didn't match"}
+			}
+			return initOffset, err
+		}
+		if params.explicit {
+			if !t.isCompound || t.length == 0 {
+				err = asn1.StructuralError{Msg: "explicit tag has no child"}
+				return
+			}
+			start = offset
+			t, offset, err = d.parseTagAndLength(bytes[:offset+t.length], offset)
+			if err != nil {
+				return
+			}
+			end = offset + t.length
+			if t.isIndefinite {
+				end += 2
+			}
+			if end > next {
+				err = asn1.SyntaxError{Msg: "data truncated"}
+				return
+			}
+		}
+	}
+
+	err = u.UnmarshalBER(t.tag, t.class, bytes[start:end])
+	return next, err
+}
+
+// parseChoice parses an ASN.1 CHOICE from the given offset into a byte slice.
+// Each field of the struct v is an alternative; the first one which matches
+// the element found is set, leaving the other fields untouched.
+func (d decoder) parseChoice(v reflect.Value, bytes []byte, initOffset int, params fieldParameters) (offset int, err error) {
+	structType := v.Type()
+	if err = checkChoice(structType); err != nil {
+		return
+	}
+
+	if initOffset < len(bytes) {
+		for i := 0; i < structType.NumField(); i++ {
+			if isSkippedField(structType.Field(i)) {
+				continue
+			}
+			// Each alternative is tried as if it were optional, so a
+			// mismatch moves on to the next one.
+			fp := structFieldParameters(structType.Field(i))
+			fp.optional = true
+			fp.defaultValue = nil
+			offset, err = d.parseField(v.Field(i), bytes, initOffset, fp)
+			if err != nil {
+				return
+			}
+			if offset != initOffset {
+				// Only the alternative decoded is left set.
+				for j := 0; j < structType.NumField(); j++ {
+					if j != i && !isSkippedField(structType.Field(j)) {
+						v.Field(j).SetZero()
+					}
+				}
+				return
+			}
+		}
+	}
+
+	offset = initOffset
+	switch {
+	case params.optional:
+	case initOffset == len(bytes):
+		err = asn1.SyntaxError{Msg: "sequence truncated"}
+	default:
+		err = params.structuralError("no CHOICE alternative matches tag")
+	}
+	return
+}
+
+func (d decoder) parseFieldContents(t tagAndLength, v reflect.Value, universalTag int, bytes []byte, offset int, params fieldParameters) (err error) {
+	innerBytes := bytes[offset : offset+t.length]
 	fieldType := v.Type()
 
+	if fieldType == streamOctetStringType {
+		w, _ := v.Field(0).Interface().(io.Writer)
+		return d.writeOctetString(w, t, innerBytes)
+	}
+
+	// The segments of a constructed character string are OCTET STRINGs, as
+	// the string types are defined as implicitly tagged OCTET STRINGs.
+	if t.isCompound && universalTag != asn1.TagBitString && d.allowConstructed(universalTag) {
+		if innerBytes, err = appendConstructedString(nil, innerBytes, asn1.TagOctetString, d.remainingDepth(), d.MaxElementSize); err != nil {
+			return
+		}
+	}
+
+	if universalTag == asn1.TagInteger || universalTag == asn1.TagEnum {
+		innerBytes = d.integer(innerBytes)
+	}
+
 	// We deal with the structures defined in this package first.
 	switch v := v.Addr().Interface().(type) {
 	case *asn1.RawValue:
 		*v = asn1.RawValue{Class: t.class, Tag: t.tag, IsCompound: t.isCompound, Bytes: innerBytes, FullBytes: bytes}
 		return
 	case *asn1.ObjectIdentifier:
+		if universalTag == tagRelativeOID {
+			*v, err = parseRelativeOID(innerBytes)
+			return
+		}
 		*v, err = parseObjectIdentifier(innerBytes)
 		return
+	case *RelativeOID:
+		*v, err = parseRelativeOID(innerBytes)
+		return
 	case *asn1.BitString:
-		*v, err = parseBitString(innerBytes)
+		if t.isCompound {
+			*v, err = parseConstructedBitString(innerBytes, d.remainingDepth(), d.MaxElementSize)
+			return
+		}
+		*v, err = d.parseBitString(innerBytes)
 		return
 	case *time.Time:
-		if universalTag == asn1.TagUTCTime {
-			*v, err = parseUTCTime(innerBytes)
+		if params.timeLayout != "" && (universalTag == asn1.TagGeneralizedTime || params.tag != nil && !params.explicit) {
+			*v, err = parseTimeLayout(innerBytes, params.timeLayout)
 			return
 		}
-		*v, err = parseGeneralizedTime(innerBytes)
+		switch universalTag {
+		case asn1.TagUTCTime:
+			*v, err = d.parseUTCTime(innerBytes)
+			if err != nil && params.timeType == timeTypeAuto && params.tag != nil && !params.explicit {
+				// An implicit tag hides which of the two was chosen.
+				*v, err = d.parseGeneralizedTime(innerBytes)
+			}
+		case tagDate, tagTimeOfDay, tagDateTime:
+			*v, err = parseTimeType(innerBytes, universalTag)
+		default:
+			*v, err = d.parseGeneralizedTime(innerBytes)
+		}
+		return
+	case *KerberosTime:
+		*v, err = parseKerberosTime(innerBytes)
 		return
 	case *asn1.Enumerated:
 		parsedInt, err1 := parseInt32(innerBytes)
@@ -814,16 +1922,64 @@ func parseFieldContents(t tagAndLength, v reflect.Value, universalTag int, bytes
 		}
 		err = err1
 		return
+	case *Duration:
+		*v, err = parseDuration(innerBytes)
+		return
+	case *BigObjectIdentifier:
+		*v, err = parseBigObjectIdentifier(innerBytes)
+		return
+	case *UUID:
+		if len(innerBytes) != len(v) {
+			err = asn1.StructuralError{Msg: fmt.Sprintf("invalid UUID length %d", len(innerBytes))}
+			return
+		}
+		copy(v[:], innerBytes)
+		return
+	case *IPAddress:
+		if len(innerBytes) != len(v) {
+			err = asn1.StructuralError{Msg: fmt.Sprintf("invalid IpAddress length %d", len(innerBytes))}
+			return
+		}
+		copy(v[:], innerBytes)
+		return
+	case **big.Rat:
+		parsedRat, err1 := parseRealRat(innerBytes)
+		if err1 == nil {
+			*v = parsedRat
+		}
+		err = err1
+		return
+	case *time.Duration:
+		parsedInt, err1 := parseInt64(innerBytes)
+		if err1 == nil && (parsedInt > math.MaxInt64/int64(time.Second) || parsedInt < math.MinInt64/int64(time.Second)) {
+			err1 = asn1.StructuralError{Msg: "duration too large"}
+		}
+		if err1 == nil {
+			*v = time.Duration(parsedInt) * time.Second
+		}
+		err = err1
+		return
+	case *net.IP:
+		if len(innerBytes) != net.IPv4len && len(innerBytes) != net.IPv6len {
+			err = asn1.StructuralError{Msg: fmt.Sprintf("invalid IP address length %d", len(innerBytes))}
+			return
+		}
+		*v = append(net.IP(nil), innerBytes...)
+		return
 	}
 	switch val := v; val.Kind() {
 	case reflect.Bool:
-		parsedBool, err1 := parseBool(innerBytes)
+		parse := parseLenientBool
+		if d.StrictBoolean || d.DER {
+			parse = parseBool
+		}
+		parsedBool, err1 := parse(innerBytes)
 		if err1 == nil {
 			val.SetBool(parsedBool)
 		}
 		err = err1
 		return
-	case reflect.Int, reflect.Int32, reflect.Int64:
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		if val.Type().Size() == 4 {
 			parsedInt, err1 := parseInt32(innerBytes)
 			if err1 == nil {
@@ -832,35 +1988,92 @@ func parseFieldContents(t tagAndLength, v reflect.Value, universalTag int, bytes
 			err = err1
 		} else {
 			parsedInt, err1 := parseInt64(innerBytes)
+			if err1 == nil && val.OverflowInt(parsedInt) {
+				err1 = asn1.StructuralError{Msg: "integer too large"}
+			}
 			if err1 == nil {
 				val.SetInt(parsedInt)
 			}
 			err = err1
 		}
 		return
-	// TODO(dfc) Add support for the remaining integer types
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsedUint, err1 := parseUint64(innerBytes)
+		if err1 == nil && val.OverflowUint(parsedUint) {
+			err1 = asn1.StructuralError{Msg: "integer too large"}
+		}
+		if err1 == nil {
+			val.SetUint(parsedUint)
+		}
+		err = err1
+		return
+	case reflect.Float32, reflect.Float64:
+		parsedFloat, err1 := parseReal(innerBytes)
+		if err1 == nil {
+			if val.OverflowFloat(parsedFloat) {
+				err1 = asn1.StructuralError{Msg: "REAL too large"}
+			} else {
+				val.SetFloat(parsedFloat)
+			}
+		}
+		err = err1
+		return
 	case reflect.Struct:
 		structType := fieldType
-
-		for i := 0; i < structType.NumField(); i++ {
-			if !structType.Field(i).IsExported() {
-				err = asn1.StructuralError{Msg: "struct contains unexported fields"}
-				return
+		info := getStructInfo(structType)
+		if info.namedBits {
+			var bs asn1.BitString
+			if t.isCompound {
+				bs, err = parseConstructedBitString(innerBytes, d.remainingDepth(), d.MaxElementSize)
+			} else {
+				bs, err = d.parseBitString(innerBytes)
 			}
+			if err == nil {
+				err = setNamedBits(val, bs)
+			}
+			return
+		}
+
+		if info.err != nil {
+			err = info.err
+			return
 		}
 
-		if structType.NumField() > 0 &&
-			structType.Field(0).Type == rawContentsType {
-			val.Field(0).Set(reflect.ValueOf(asn1.RawContent(bytes)))
+		fields := info.fields
+		if info.rawContents {
+			val.FieldByIndex(fields[0].index).Set(reflect.ValueOf(asn1.RawContent(bytes)))
+			fields = fields[1:]
+		}
+
+		if universalTag == asn1.TagSet {
+			if info.tail {
+				err = fields[len(fields)-1].params.structuralError("tail given to member of a SET")
+				return
+			}
+			err = d.at(offset).parseSet(val, fields, info.unmatched, innerBytes)
+			return
 		}
 
 		innerOffset := 0
-		for i := 0; i < structType.NumField(); i++ {
-			field := structType.Field(i)
-			if i == 0 && field.Type == rawContentsType {
+		for _, f := range fields {
+			if f.params.tail {
+				if tail := val.FieldByIndex(f.index); innerOffset < len(innerBytes) {
+					tail.SetBytes(innerBytes[innerOffset:])
+				} else {
+					tail.SetZero()
+				}
+				return
+			}
+			if f.params.unmatched {
+				err = d.at(offset).parseUnmatched(val.FieldByIndex(f.index), innerBytes, innerOffset)
+				return
+			}
+			if f.extension && innerOffset == len(innerBytes) {
+				// An extension addition absent from an older version.
+				setDefaultValue(val.FieldByIndex(f.index), f.params)
 				continue
 			}
-			innerOffset, err = parseField(val.Field(i), innerBytes, innerOffset, parseFieldParameters(field.Tag.Get("asn1")))
+			innerOffset, err = d.at(offset).parseField(val.FieldByIndex(f.index), innerBytes, innerOffset, f.params)
 			if err != nil {
 				return
 			}
@@ -869,19 +2082,66 @@ func parseFieldContents(t tagAndLength, v reflect.Value, universalTag int, bytes
 		// adding elements to the end has been used in X.509 as the
 		// version numbers have increased.
 		return
+	case reflect.Array:
+		if fieldType.Elem().Kind() == reflect.Uint8 {
+			if len(innerBytes) != val.Len() {
+				err = asn1.StructuralError{Msg: fmt.Sprintf("invalid length %d for %s", len(innerBytes), fieldType)}
+				return
+			}
+			reflect.Copy(val, reflect.ValueOf(innerBytes))
+			return
+		}
 	case reflect.Slice:
 		sliceType := fieldType
+		if universalTag == asn1.TagBitString {
+			var bs asn1.BitString
+			if t.isCompound {
+				bs, err = parseConstructedBitString(innerBytes, d.remainingDepth(), d.MaxElementSize)
+			} else {
+				bs, err = d.parseBitString(innerBytes)
+			}
+			if err == nil {
+				setBoolBits(val, bs)
+			}
+			return
+		}
 		if sliceType.Elem().Kind() == reflect.Uint8 {
 			val.Set(reflect.MakeSlice(sliceType, len(innerBytes), len(innerBytes)))
 			reflect.Copy(val, reflect.ValueOf(innerBytes))
 			return
 		}
-		newSlice, err1 := parseSequenceOf(innerBytes, sliceType, sliceType.Elem())
+		params.set = universalTag == asn1.TagSet
+		newSlice, err1 := d.at(offset).parseSequenceOf(innerBytes, sliceType, sliceType.Elem(), params)
 		if err1 == nil {
 			val.Set(newSlice)
 		}
 		err = err1
 		return
+	case reflect.Map:
+		entryType := mapEntryType(fieldType)
+		params.set = true
+		entries, err1 := d.at(offset).parseSequenceOf(innerBytes, reflect.SliceOf(entryType), entryType, params)
+		if err1 != nil {
+			err = err1
+			return
+		}
+		m := reflect.MakeMapWithSize(fieldType, entries.Len())
+		for i := 0; i < entries.Len(); i++ {
+			key := entries.Index(i).Field(0)
+			if d.DER && m.MapIndex(key).IsValid() {
+				err = asn1.StructuralError{Msg: "duplicate map key"}
+				return
+			}
+			m.SetMapIndex(key, entries.Index(i).Field(1))
+		}
+		if val.IsNil() {
+			val.Set(m)
+			return
+		}
+		for iter := m.MapRange(); iter.Next(); {
+			val.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return
 	case reflect.String:
 		var v string
 		switch universalTag {
@@ -903,6 +2163,10 @@ func parseFieldContents(t tagAndLength, v reflect.Value, universalTag int, bytes
 			v, err = parseT61String(innerBytes)
 		case asn1.TagBMPString:
 			v, err = parseBMPString(innerBytes)
+		case tagUniversalString:
+			v, err = parseUniversalString(innerBytes)
+		case tagVisibleString:
+			v, err = parseVisibleString(innerBytes)
 
 		default:
 			err = asn1.SyntaxError{Msg: fmt.Sprintf("internal error: unknown string type %d", universalTag)}
@@ -916,17 +2180,29 @@ func parseFieldContents(t tagAndLength, v reflect.Value, universalTag int, bytes
 	return
 }
 
-// canHaveDefaultValue reports whether k is a Kind that we will set a default
-// value for. (A signed integer, essentially.)
-func canHaveDefaultValue(k reflect.Kind) bool {
+// canHaveDefaultValue reports whether k is a Kind that we will set the default
+// value n for: an integer able to hold n.
+func canHaveDefaultValue(k reflect.Kind, n int64) bool {
 	switch k {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return n >= 0
 	}
 
 	return false
 }
 
+// setInt stores n in v, which must be of a Kind accepted by
+// canHaveDefaultValue.
+func setInt(v reflect.Value, n int64) {
+	if v.CanInt() {
+		v.SetInt(n)
+	} else {
+		v.SetUint(uint64(n))
+	}
+}
+
 // setDefaultValue is used to install a default value, from a tag string, into
 // a Value. It is successful if the field was optional, even if a default value
 // wasn't provided or it failed to install it into the Value.
@@ -938,8 +2214,8 @@ func setDefaultValue(v reflect.Value, params fieldParameters) (ok bool) {
 	if params.defaultValue == nil {
 		return
 	}
-	if canHaveDefaultValue(v.Kind()) {
-		v.SetInt(*params.defaultValue)
+	if canHaveDefaultValue(v.Kind(), *params.defaultValue) {
+		setInt(v, *params.defaultValue)
 	}
 	return
 }
@@ -949,47 +2225,159 @@ func setDefaultValue(v reflect.Value, params fieldParameters) (ok bool) {
 // Because Unmarshal uses the reflect package, the structs
 // being written to must use upper case field names.
 //
-// An ASN.1 INTEGER can be written to an int, int32, int64,
-// or *big.Int (from the math/big package).
+// Unmarshal parses a single element from the start of b and returns the data
+// after it as rest, whatever the type of val, so that a stream of elements
+// can be parsed one after another.
+//
+// An ASN.1 INTEGER can be written to any signed or unsigned integer type,
+// including types defined from them, or to a *big.Int (from the math/big
+// package).
 // If the encoded value does not fit in the Go type,
 // Unmarshal returns a parse error.
 //
-// An ASN.1 BIT STRING can be written to a BitString.
+// An ASN.1 INTEGER can also be written to a time.Duration, in which case it
+// is taken to be a number of seconds.
+//
+// An ASN.1 REAL can be written to a float32, float64 or *big.Rat. A *big.Rat
+// receives the exact value of the REAL.
+//
+// An ASN.1 BIT STRING can be written to a BitString, joining the segments of a
+// constructed BIT STRING. It can also be written to a struct of bool fields
+// with the bit tag, each field being set from the named bit it gives. Bits
+// beyond the end of the BIT STRING are taken to be zero. A []bool with the
+// bits tag receives one element for each bit.
+//
+// An ASN.1 OCTET STRING can be written to a []byte. An OCTET STRING of 4 or 16
+// bytes can be written to a net.IP, and one of 16 bytes to a UUID. An OCTET
+// STRING of exactly N bytes can be written to a [N]byte. The segments of a
+// constructed OCTET STRING are joined together. The contents of an OCTET
+// STRING can also be written to the io.Writer of a StreamOctetString.
 //
-// An ASN.1 OCTET STRING can be written to a []byte.
+// An ASN.1 OBJECT IDENTIFIER can be written to an ObjectIdentifier, or to a
+// BigObjectIdentifier if its arcs may not fit in an int. An ASN.1
+// RELATIVE-OID can be written to a RelativeOID, or to an ObjectIdentifier
+// with the relative tag.
 //
-// An ASN.1 OBJECT IDENTIFIER can be written to an
-// ObjectIdentifier.
+// An ASN.1 ENUMERATED can be written to an Enumerated, or to any integer
+// type with the enumerated tag. A type defined from Enumerated, as in
+// type Status asn1.Enumerated, is an integer type, so it needs the tag too.
 //
-// An ASN.1 ENUMERATED can be written to an Enumerated.
+// An ASN.1 UTCTIME or GENERALIZEDTIME can be written to a time.Time, as can a
+// DATE, TIME-OF-DAY or DATE-TIME. These take their ISO 8601 forms, such as
+// 2006-01-02T15:04:05, and are given in UTC.
 //
-// An ASN.1 UTCTIME or GENERALIZEDTIME can be written to a time.Time.
+// An ASN.1 DURATION can be written to a Duration.
 //
-// An ASN.1 PrintableString, IA5String, or NumericString can be written to a string.
+// A GENERALIZEDTIME of the form 19700101000000Z, with no fraction of a second,
+// can be written to a KerberosTime.
+//
+// The SNMP types IpAddress, Counter32, Gauge32, TimeTicks, Opaque and
+// Counter64 can be written to the types of the same names, which expect their
+// APPLICATION tags rather than the universal tags of the types they are
+// derived from.
+//
+// An ASN.1 PrintableString, IA5String, NumericString, UTF8String, BMPString,
+// UniversalString, VisibleString, GeneralString or T61String can be written to
+// a string. The octets of a GeneralString or T61String are copied into the
+// string unchanged.
+// A constructed encoding of a character string is only accepted with the
+// LenientCompound option.
 //
 // Any of the above ASN.1 values can be written to an interface{}.
 // The value stored in the interface has the corresponding Go type.
-// For integers, that type is int64.
+// For integers, that type is int64. An element of a class and tag given to
+// RegisterChoiceType is written to a new value of the type registered for
+// it instead, so that the alternatives of a CHOICE can be decoded into an
+// interface{}. Any other element is stored as an asn1.RawValue, or is an
+// error with the RequireChoiceType option.
 //
 // An ASN.1 SEQUENCE OF x or SET OF x can be written
-// to a slice if an x can be written to the slice's element type.
+// to a slice if an x can be written to the slice's element type. The elements
+// are stored in the order they appear in b, even those of a SET OF, so that
+// its encoding can be checked against a signature. Marshal doesn't keep that
+// order, as it sorts the elements of a SET OF by their encodings, which DER
+// requires; with DER Unmarshal rejects a SET OF which isn't sorted.
 //
 // An ASN.1 SEQUENCE or SET can be written to a struct
 // if each of the elements in the sequence can be
 // written to the corresponding element in the struct.
+// Elements after those which correspond to the fields of the struct are
+// skipped, so that a SEQUENCE extended with new elements can still be decoded.
+// Conversely the fields after an ExtensionMarker may be missing from the end
+// of a SEQUENCE or from a SET, as when it was encoded before they were added.
+// The fields of an embedded struct without a tag are taken as fields of the
+// struct embedding it, rather than as a SEQUENCE of their own.
 //
 // The following tags on struct fields have special meaning to Unmarshal:
 //
 //	application specifies that an APPLICATION tag is used
+//	class:x     specifies the class of the tag, 0 to 3 for UNIVERSAL, APPLICATION, CONTEXT SPECIFIC and PRIVATE
+//	context     specifies that a CONTEXT SPECIFIC tag is used, which is the default
+//	choice      specifies that a struct is a CHOICE of its fields; given on a slice it applies to the elements
+//	any         collects the elements after those of the other fields into a final []asn1.RawValue
+//	tail        collects the contents after the elements of the other fields into a final RawTail
 //	private     specifies that a PRIVATE tag is used
 //	default:x   sets the default value for optional integer fields (only used if optional is also present)
 //	explicit    specifies that an additional, explicit tag wraps the implicit one
 //	optional    marks the field as ASN.1 OPTIONAL
 //	set         causes a SET, rather than a SEQUENCE type to be expected
 //	tag:x       specifies the ASN.1 tag number; implies ASN.1 CONTEXT SPECIFIC
+//	min:x       the least value of an integer
+//	max:x       the greatest value of an integer
+//	minsize:x   the least number of characters of a string or elements of a slice
+//	maxsize:x   the greatest number of characters of a string or elements of a slice
+//	date        causes an implicitly tagged time.Time to be parsed as a DATE
+//	timeofday   causes an implicitly tagged time.Time to be parsed as a TIME-OF-DAY
+//	datetime    causes an implicitly tagged time.Time to be parsed as a DATE-TIME
+//	autotime    causes an implicitly tagged time.Time to be parsed as a UTCTime or, failing that, a GeneralizedTime
+//	timelayout:x causes the contents of a GeneralizedTime to be parsed with the Go time layout x, which can't contain a comma
+//	relative    causes an ObjectIdentifier to be parsed as a RELATIVE-OID
+//	enumerated  causes an integer to be parsed as an ENUMERATED
+//	explicit:x:y specifies explicit tags [x] and then [y] wrapping the value
+//	bit:x       specifies the named bit, numbered from 0, of a BIT STRING which a bool field is
+//	bits        causes a []bool to be parsed as a BIT STRING
+//
+// If the type of the first field of a structure is RawContent then the
+// complete encoding of the struct, its tag and length included, will be
+// stored in it, so that a signature over it can be checked.
+//
+// A field with the tag "-", or named "_", is skipped: no element is read for
+// it and it is left untouched.
 //
-// If the type of the first field of a structure is RawContent then the raw
-// ASN1 contents of the struct will be stored in it.
+// A []asn1.RawValue field with the tag "any", which must be the last field,
+// receives the elements of the SEQUENCE left after those of the other
+// fields, in order, rather than their being skipped. Marshal writes them
+// after the other fields, so unknown extensions pass through unchanged.
+//
+// A RawTail field with the tag "tail", which must also be the last field,
+// instead receives the contents of the SEQUENCE after the elements of the
+// other fields as they are, without their being parsed, and Marshal writes
+// them back verbatim. It refers to b, as a RawContent does, and is left nil
+// if there are none. It can't be used in a SET.
+//
+// A pointer, other than a *big.Int or *big.Rat, is set to a newly allocated
+// value holding the element, or to nil if the field is optional and the
+// element absent.
+//
+// A SET can be written to a struct with the set tag, each element going to
+// the first field it can be written to which has no element yet, whatever
+// their order. An element matching no field is an error, unless the last
+// field has the any tag, as is a missing element for a field which isn't
+// optional. With DER the elements must be in the canonical order of their
+// tags.
+//
+// A SET OF SEQUENCE { key, value } can be written to a map, adding its entries
+// to those already present. The value of a key which appears more than once is
+// the last given, unless UnmarshalOptions.DER is set when it is an error.
+//
+// A value whose address implements Unmarshaler is decoded by its UnmarshalBER
+// method.
+//
+// A struct with the "choice" tag is an ASN.1 CHOICE: each of its fields is an
+// alternative, usually distinguished by its tag, and only the field matching
+// the element on the wire is set, the others being set to nil. Each field must
+// be a pointer, slice or interface, so that Marshal can tell which one is set.
+// A tagged CHOICE is always explicitly tagged.
 //
 // If the type name of a slice element ends with "SET" then it's treated as if
 // the "set" tag was set on it. This can be used with nested slices where a
@@ -997,8 +2385,92 @@ func setDefaultValue(v reflect.Value, params fieldParameters) (ok bool) {
 //
 // Other ASN.1 types are not supported; if it encounters them,
 // Unmarshal returns a parse error.
+//
+// Parse errors are a StructuralError or SyntaxError whose Offset is the
+// position in b of the innermost element which failed to parse. If b ends
+// before the element does, but is well formed so far, the error is a
+// SyntaxError matching io.ErrUnexpectedEOF under errors.Is, so that a caller
+// reading from a connection can tell it needs more input. Under errors.As
+// each also matches the encoding/asn1 error type of the same name.
 func Unmarshal(b []byte, val any) (rest []byte, err error) {
-	return UnmarshalWithParams(b, val, "")
+	return UnmarshalOptions{}.Unmarshal(b, val)
+}
+
+// UnmarshalOptions configures how data is unmarshaled. The zero value accepts
+// any BER encoding, as Unmarshal does.
+type UnmarshalOptions struct {
+	// DER restricts the input to the Distinguished Encoding Rules. Lengths
+	// must be definite and minimally encoded, OCTET STRING and BIT STRING
+	// values must be primitive, and the elements of a SET OF must be in
+	// ascending order of their encodings. A key may only appear once in a
+	// map, and a UTCTime must be in UTC with the seconds given. A
+	// GeneralizedTime must be in UTC, with any fraction of a second after a
+	// '.' and without trailing zeros. INTEGER
+	// and ENUMERATED values must be minimally encoded. A BOOLEAN must be 0x00 or
+	// 0xff, as with StrictBoolean, and the unused bits of a BIT STRING
+	// must be zero.
+	DER bool
+
+	// MaxDepth limits how deeply elements may be nested, so that crafted
+	// input can't exhaust the stack. If it is zero, 128 levels are
+	// allowed.
+	MaxDepth int
+
+	// MaxSize limits the number of bytes a Decoder reads for an element,
+	// including those it contains, so that crafted input can't make it
+	// buffer without bound. If it is zero there is no limit. It has no
+	// effect on Unmarshal, whose input is already in memory.
+	MaxSize int
+
+	// MaxElementSize limits the length of the contents of any primitive
+	// element, and the joined contents of a constructed string, so that
+	// a single element can't claim more memory than expected. It applies
+	// to Unmarshal and a Decoder alike. If it is zero there is no limit.
+	MaxElementSize int
+
+	// LenientCompound accepts the constructed encoding of the character
+	// string types, not only of OCTET STRING and BIT STRING, and joins its
+	// segments as for an OCTET STRING. Some BER encoders split long strings
+	// of any type this way. It has no effect with DER.
+	LenientCompound bool
+
+	// StrictBoolean requires the contents of a BOOLEAN to be 0x00 or 0xff,
+	// as DER does, rather than taking any non-zero octet as TRUE.
+	StrictBoolean bool
+
+	// ExpectExactLength makes it an error for any data to follow the
+	// element unmarshaled, rather than returning it as the rest.
+	ExpectExactLength bool
+
+	// RequireChoiceType makes it an error to unmarshal an element into an
+	// interface{} unless it is of a universal type with a Go counterpart
+	// or its class and tag were given to RegisterChoiceType, rather than
+	// storing it as an asn1.RawValue.
+	RequireChoiceType bool
+}
+
+// Unmarshal parses the ASN.1 data structure b as the Unmarshal function does,
+// using the options o.
+func (o UnmarshalOptions) Unmarshal(b []byte, val any) (rest []byte, err error) {
+	return o.UnmarshalWithParams(b, val, "")
+}
+
+// truncation returns err, from parsing the element at the start of b, as a
+// SyntaxError matching io.ErrUnexpectedEOF if b ends before that element
+// does, and otherwise as one which doesn't. Within a complete element a
+// truncated inner element is malformed rather than waiting for more input.
+func truncation(b []byte, err error) error {
+	truncated := len(b) == 0
+	if t, offset, err := parseTagAndLength(b, 0); err == nil {
+		truncated = invalidLength(offset, t.length, len(b))
+	} else if _, ok := err.(truncatedError); ok {
+		truncated = true
+	}
+	if se, ok := err.(SyntaxError); ok {
+		se.truncated = truncated
+		return se
+	}
+	return err
 }
 
 // An invalidUnmarshalError describes an invalid argument passed to Unmarshal.
@@ -1021,13 +2493,22 @@ func (e *invalidUnmarshalError) Error() string {
 // UnmarshalWithParams allows field parameters to be specified for the
 // top-level element. The form of the params is the same as the field tags.
 func UnmarshalWithParams(b []byte, val any, params string) (rest []byte, err error) {
+	return UnmarshalOptions{}.UnmarshalWithParams(b, val, params)
+}
+
+// UnmarshalWithParams is like Unmarshal but allows field parameters to be
+// specified for the top-level element.
+func (o UnmarshalOptions) UnmarshalWithParams(b []byte, val any, params string) (rest []byte, err error) {
 	v := reflect.ValueOf(val)
 	if v.Kind() != reflect.Pointer || v.IsNil() {
 		return nil, &invalidUnmarshalError{reflect.TypeOf(val)}
 	}
-	offset, err := parseField(v.Elem(), b, 0, parseFieldParameters(params))
+	offset, err := decoder{UnmarshalOptions: o}.parseField(v.Elem(), b, 0, parseFieldParameters(params))
 	if err != nil {
-		return nil, err
+		return nil, truncation(b, err)
+	}
+	if o.ExpectExactLength && offset != len(b) {
+		return nil, SyntaxError{Msg: "trailing data", Offset: offset}
 	}
 	return b[offset:], nil
 }
@@ -2,7 +2,10 @@
 
 package ber
 
-import "encoding/asn1"
+import (
+	"encoding/asn1"
+	"math"
+)
 
 func init() {
 	objectIdentifierTestData = append(objectIdentifierTestData, objectIdentifierTest{
@@ -14,6 +17,14 @@ func init() {
 		[]int{1, 3, 6, 1, 4, 1, 9, 10, 138, 1, 4, 1, 2, 1, 3221225473},
 	})
 
+	// Lengths beyond those of a 32-bit int are parsed, leaving their
+	// callers to check them against the data they have.
+	berTagAndLengthData = append(berTagAndLengthData,
+		tagAndLengthTest{[]byte{0xa0, 0x84, 0x80, 0x00, 0x00, 0x00}, true, tagAndLength{2, 0, 0x80000000, true, false}},
+		tagAndLengthTest{[]byte{0xa0, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00}, true, tagAndLength{2, 0, 1 << 32, true, false}},
+		tagAndLengthTest{[]byte{0xa0, 0x88, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, true, tagAndLength{2, 0, math.MaxInt64, true, false}},
+	)
+
 	marshalTests = append(marshalTests, marshalTest{
 		asn1.ObjectIdentifier([]int{1, 3, 6, 1, 4, 1, 9, 10, 138, 1, 4, 1, 2, 1, 3221225473}),
 		"06132b06010401090a810a01040102018c80808001",
@@ -271,6 +271,12 @@ var utcTestData = []timeTest{
 	{"910506234540Z", true, time.Date(1991, 05, 06, 23, 45, 40, 0, time.UTC)},
 	{"9105062345Z", true, time.Date(1991, 05, 06, 23, 45, 0, 0, time.UTC)},
 	{"5105062345Z", true, time.Date(1951, 05, 06, 23, 45, 0, 0, time.UTC)},
+	{"491231235959Z", true, time.Date(2049, 12, 31, 23, 59, 59, 0, time.UTC)},
+	{"500101000000Z", true, time.Date(1950, 01, 01, 00, 00, 00, 0, time.UTC)},
+	{"991231235959Z", true, time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC)},
+	{"000101000000Z", true, time.Date(2000, 01, 01, 00, 00, 00, 0, time.UTC)},
+	{"4912312359-0100", true, time.Date(2049, 12, 31, 23, 59, 0, 0, time.FixedZone("", -60*60))},
+	{"910506164540", false, time.Time{}},
 	{"a10506234540Z", false, time.Time{}},
 	{"91a506234540Z", false, time.Time{}},
 	{"9105a6234540Z", false, time.Time{}},
@@ -326,6 +332,12 @@ var generalizedTimeTestData = []timeTest{
 	{"20100102030405", false, time.Time{}},
 	{"20100102030405+0607", true, time.Date(2010, 01, 02, 03, 04, 05, 0, time.FixedZone("", 6*60*60+7*60))},
 	{"20100102030405-0607", true, time.Date(2010, 01, 02, 03, 04, 05, 0, time.FixedZone("", -6*60*60-7*60))},
+	{"20100102030405.123Z", true, time.Date(2010, 01, 02, 03, 04, 05, 123e6, time.UTC)},
+	{"20100102030405,5Z", true, time.Date(2010, 01, 02, 03, 04, 05, 5e8, time.UTC)},
+	{"20100102030405.1234567891Z", true, time.Date(2010, 01, 02, 03, 04, 05, 123456789, time.UTC)},
+	{"20100102030405.5+0607", true, time.Date(2010, 01, 02, 03, 04, 05, 5e8, time.FixedZone("", 6*60*60+7*60))},
+	{"20100102030405.Z", false, time.Time{}},
+	{"201001020304.5Z", false, time.Time{}},
 	/* These are invalid times. However, the time package normalises times
 	 * and they were accepted in some versions. See #11134. */
 	{"00000100000000Z", false, time.Time{}},
@@ -425,8 +437,20 @@ var parseFieldParametersTestData []parseFieldParametersTest = []parseFieldParame
 	{"ia5", fieldParameters{stringType: asn1.TagIA5String}},
 	{"generalized", fieldParameters{timeType: asn1.TagGeneralizedTime}},
 	{"utc", fieldParameters{timeType: asn1.TagUTCTime}},
+	{"date", fieldParameters{timeType: tagDate}},
+	{"timeofday", fieldParameters{timeType: tagTimeOfDay}},
+	{"datetime", fieldParameters{timeType: tagDateTime}},
+	{"relative", fieldParameters{relative: true}},
+	{"explicit:0:1", fieldParameters{explicit: true, tag: newInt(1), outerTags: []int{0}}},
+	{"application,explicit:3:2:1", fieldParameters{application: true, explicit: true, tag: newInt(1), outerTags: []int{3, 2}}},
+	{"explicit:0:x", fieldParameters{}},
 	{"printable", fieldParameters{stringType: asn1.TagPrintableString}},
 	{"numeric", fieldParameters{stringType: asn1.TagNumericString}},
+	{"bmp", fieldParameters{stringType: asn1.TagBMPString}},
+	{"universal", fieldParameters{stringType: tagUniversalString}},
+	{"visible", fieldParameters{stringType: tagVisibleString}},
+	{"general", fieldParameters{stringType: asn1.TagGeneralString}},
+	{"t61", fieldParameters{stringType: asn1.TagT61String}},
 	{"optional", fieldParameters{optional: true}},
 	{"explicit", fieldParameters{explicit: true, tag: new(int)}},
 	{"application", fieldParameters{application: true, tag: new(int)}},
@@ -437,6 +461,16 @@ var parseFieldParametersTestData []parseFieldParametersTest = []parseFieldParame
 	{"optional,explicit,default:42,tag:17", fieldParameters{optional: true, explicit: true, defaultValue: newInt64(42), tag: newInt(17)}},
 	{"optional,explicit,default:42,tag:17,rubbish1", fieldParameters{optional: true, explicit: true, application: false, defaultValue: newInt64(42), tag: newInt(17), stringType: 0, timeType: 0, set: false, omitEmpty: false}},
 	{"set", fieldParameters{set: true}},
+	{"choice", fieldParameters{choice: true}},
+	{"minsize:1,maxsize:64", fieldParameters{minSize: newInt(1), maxSize: newInt(64)}},
+	{"min:-1,max:3", fieldParameters{min: newInt64(-1), max: newInt64(3)}},
+	{"precision:4", fieldParameters{precision: newInt(4)}},
+	{"class:1,tag:5", fieldParameters{application: true, tag: newInt(5)}},
+	{"context,tag:5", fieldParameters{tag: newInt(5)}},
+	{"application,class:2,tag:5", fieldParameters{tag: newInt(5)}},
+	{"class:0,tag:22", fieldParameters{universal: true, tag: newInt(22)}},
+	{"class:3", fieldParameters{private: true, tag: new(int)}},
+	{"class:4", fieldParameters{}},
 }
 
 func TestParseFieldParameters(t *testing.T) {
@@ -1011,7 +1045,7 @@ func TestUnexportedStructField(t *testing.T) {
 	}
 	var u unexported
 	_, err = Unmarshal(bs, &u)
-	if err != want {
+	if want := (StructuralError{Msg: "struct contains unexported fields"}); err != want {
 		t.Errorf("got %v, want %v", err, want)
 	}
 }
@@ -1,7 +1,20 @@
 package ber
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
 )
 
 func init() {
@@ -40,8 +53,9 @@ var berTagAndLengthData = []tagAndLengthTest{
 	// {[]byte{0x30, 0x80}, false, tagAndLength{}},
 	// Lengths up to the maximum size of an int should work.
 	{[]byte{0xa0, 0x84, 0x7f, 0xff, 0xff, 0xff}, true, tagAndLength{2, 0, 0x7fffffff, true, false}},
-	// Lengths that would overflow an int should be rejected.
-	{[]byte{0xa0, 0x84, 0x80, 0x00, 0x00, 0x00}, false, tagAndLength{}},
+	// Lengths that would overflow an int64 should be rejected. See
+	// ber_64bit_test.go for those which only fit a 64-bit int.
+	{[]byte{0xa0, 0x88, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, false, tagAndLength{}},
 	// Tag numbers which would overflow int32 are rejected. (The value below is 2^31.)
 	{[]byte{0x1f, 0x88, 0x80, 0x80, 0x80, 0x00, 0x00}, false, tagAndLength{}},
 	// Tag numbers that fit in an int32 are valid. (The value below is 2^31 - 1.)
@@ -51,13 +65,18 @@ var berTagAndLengthData = []tagAndLengthTest{
 	// Superfluous zeros in the length should be a accepted (different from DER).
 	{[]byte{0xa0, 0x82, 0x00, 0xff}, true, tagAndLength{2, 0, 0xff, true, false}},
 	// Lengths that would overflow an int should be rejected.
-	{[]byte{0xa0, 0x84, 0x88, 0x90, 0x80, 0x23}, false, tagAndLength{}},
+	{[]byte{0xa0, 0x89, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, false, tagAndLength{}},
 	// Long length form may be used for lengths that fit in short form (different from DER).
 	{[]byte{0xa0, 0x81, 0x7f}, true, tagAndLength{2, 0, 0x7f, true, false}},
 	// Indefinite length.
 	{[]byte{0x30, 0x80, 0x02, 0x01, 0x04, 0x00, 0x00}, true, tagAndLength{0, 16, 3, true, true}},
 }
 
+type TestNestedIndefinite struct {
+	Inner TestContextSpecificTags2
+	C     int
+}
+
 type TestExplicitIndefinite struct {
 	T    TestContextSpecificTags2 `asn1:"explicit,tag:2,set"`
 	Ints []int                    `asn1:"explicit,application"`
@@ -72,4 +91,1516 @@ var berUnmarshalTestData = []struct {
 	{[]byte{0x30, 0x80, 0xa2, 0x80, 0x31, 0x08, 0xa1, 0x03, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02, 0x00, 0x00,
 		0x60, 0x80, 0x30, 0x80, 0x02, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
 		&TestExplicitIndefinite{TestContextSpecificTags2{1, 2}, []int{2}}},
+	{[]byte{0x30, 0x80, 0x30, 0x80, 0xa1, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00, 0x02, 0x01, 0x02, 0x00, 0x00,
+		0x02, 0x01, 0x03, 0x00, 0x00},
+		&TestNestedIndefinite{TestContextSpecificTags2{1, 2}, 3}},
+}
+
+func TestIndefiniteLengthMissingEOC(t *testing.T) {
+	tests := [][]byte{
+		{0x30, 0x80, 0x02, 0x01, 0x01},
+		{0x30, 0x80, 0x30, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00},
+		{0x30, 0x80, 0x02, 0x05, 0x01},
+	}
+	for i, test := range tests {
+		var result TestNestedIndefinite
+		_, err := Unmarshal(test, &result)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("#%d: got %v, want io.ErrUnexpectedEOF", i, err)
+		}
+		var se asn1.SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("#%d: got %v, want SyntaxError", i, err)
+		}
+	}
+}
+
+func TestIndefiniteLengthRawValue(t *testing.T) {
+	in := []byte{0x30, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00, 0x02, 0x01, 0x02}
+	var rv asn1.RawValue
+	rest, err := Unmarshal(in, &rv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rv.FullBytes, in[:7]) {
+		t.Errorf("got FullBytes %x want %x", rv.FullBytes, in[:7])
+	}
+	if !bytes.Equal(rv.Bytes, in[2:5]) {
+		t.Errorf("got Bytes %x want %x", rv.Bytes, in[2:5])
+	}
+	if !bytes.Equal(rest, in[7:]) {
+		t.Errorf("got rest %x want %x", rest, in[7:])
+	}
+}
+
+type ldapAttributeValueAssertion struct {
+	AttributeDesc  []byte
+	AssertionValue []byte
+}
+
+// ldapFilter models a subset of the LDAP Filter CHOICE from RFC 4511.
+type ldapFilter struct {
+	And           []ldapFilter                 `asn1:"tag:0,set,choice"`
+	Or            []ldapFilter                 `asn1:"tag:1,set,choice"`
+	EqualityMatch *ldapAttributeValueAssertion `asn1:"tag:3"`
+	Present       []byte                       `asn1:"tag:7"`
+}
+
+type ldapSearchRequest struct {
+	BaseObject []byte
+	Filter     ldapFilter `asn1:"choice"`
+}
+
+func TestChoice(t *testing.T) {
+	// (&(objectClass=*)(cn=foo))
+	in := ldapSearchRequest{
+		BaseObject: []byte("dc=example"),
+		Filter: ldapFilter{And: []ldapFilter{
+			{Present: []byte("objectClass")},
+			{EqualityMatch: &ldapAttributeValueAssertion{[]byte("cn"), []byte("foo")}},
+		}},
+	}
+	want, _ := hex.DecodeString("3026040a64633d6578616d706c65" +
+		"a018870b6f626a656374436c617373a3090402636e0403666f6f")
+
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, data) {
+		t.Errorf("got: %x want %x", data, want)
+	}
+
+	var got ldapSearchRequest
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("got %#v want %#v", got, in)
+	}
+}
+
+type explicitChoiceTest struct {
+	Choice ldapFilter `asn1:"tag:2,choice"`
+}
+
+func TestExplicitChoice(t *testing.T) {
+	in := explicitChoiceTest{ldapFilter{Present: []byte("cn")}}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x30, 0x06, 0xa2, 0x04, 0x87, 0x02, 'c', 'n'}
+	if !bytes.Equal(want, data) {
+		t.Errorf("got: %x want %x", data, want)
+	}
+	var got explicitChoiceTest
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("got %#v want %#v", got, in)
+	}
+}
+
+func TestChoiceErrors(t *testing.T) {
+	marshalTests := []ldapSearchRequest{
+		{Filter: ldapFilter{}},
+		{Filter: ldapFilter{Present: []byte("cn"), Or: []ldapFilter{{Present: []byte("sn")}}}},
+	}
+	for i, test := range marshalTests {
+		if _, err := Marshal(test); err == nil {
+			t.Errorf("#%d: Marshal succeeded, should have failed", i)
+		}
+	}
+
+	// A context specific [9] matches none of the alternatives.
+	var got ldapSearchRequest
+	_, err := Unmarshal([]byte{0x30, 0x06, 0x04, 0x00, 0x89, 0x02, 'c', 'n'}, &got)
+	if _, ok := err.(StructuralError); !ok {
+		t.Errorf("got %v, want StructuralError", err)
+	}
+}
+
+func TestChoiceZeroValue(t *testing.T) {
+	type choice struct {
+		N    *int    `asn1:"tag:0"`
+		Flag *bool   `asn1:"tag:1"`
+		Name *string `asn1:"tag:2,utf8"`
+		Data []byte  `asn1:"tag:3"`
+	}
+	type message struct {
+		Alt choice `asn1:"choice"`
+	}
+	// An alternative holding its zero value is set, since it isn't nil.
+	n, flag, name := 0, false, ""
+	for _, test := range []struct {
+		in  message
+		out string
+	}{
+		{message{choice{N: &n}}, "3003800100"},
+		{message{choice{Flag: &flag}}, "3003810100"},
+		{message{choice{Name: &name}}, "30028200"},
+		{message{choice{Data: []byte{}}}, "30028300"},
+	} {
+		out, err := Marshal(test.in)
+		if err != nil {
+			t.Errorf("%+v: %v", test.in.Alt, err)
+			continue
+		}
+		if hex.EncodeToString(out) != test.out {
+			t.Errorf("%+v: got %x, want %s", test.in.Alt, out, test.out)
+		}
+		// Decoding sets only that alternative, so the value marshals
+		// the same again.
+		got := message{choice{N: new(int)}}
+		if _, err := Unmarshal(out, &got); err != nil {
+			t.Errorf("%s: %v", test.out, err)
+			continue
+		}
+		if again, err := Marshal(got); err != nil || !bytes.Equal(again, out) {
+			t.Errorf("%s: marshaled again as %x, %v", test.out, again, err)
+		}
+	}
+
+	// An alternative which can't be nil is rejected.
+	var bad struct {
+		Alt struct {
+			N int `asn1:"tag:0"`
+		} `asn1:"choice"`
+	}
+	want := asn1.StructuralError{Msg: "CHOICE alternative N is not a pointer, slice or interface"}
+	if _, err := Marshal(bad); err != want {
+		t.Errorf("Marshal: got %v, want %v", err, want)
+	}
+	if _, err := Unmarshal([]byte{0x30, 0x03, 0x80, 0x01, 0x00}, &bad); err == nil {
+		t.Error("Unmarshal accepted a CHOICE alternative which can't be nil")
+	}
+}
+
+func TestConstructedOctetString(t *testing.T) {
+	want := []byte("Hello, world!")
+	tests := [][]byte{
+		// Three segments in a definite length encoding.
+		{0x24, 0x13, 0x04, 0x05, 'H', 'e', 'l', 'l', 'o', 0x04, 0x02, ',', ' ', 0x04, 0x06, 'w', 'o', 'r', 'l', 'd', '!'},
+		// The same segments in an indefinite length encoding, with the
+		// last two nested in a further constructed segment.
+		{0x24, 0x80, 0x04, 0x05, 'H', 'e', 'l', 'l', 'o', 0x24, 0x80, 0x04, 0x02, ',', ' ', 0x00, 0x00,
+			0x04, 0x06, 'w', 'o', 'r', 'l', 'd', '!', 0x00, 0x00},
+	}
+	for i, test := range tests {
+		var got []byte
+		if _, err := Unmarshal(test, &got); err != nil {
+			t.Errorf("#%d: Unmarshal failed: %s", i, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("#%d: got %q want %q", i, got, want)
+		}
+	}
+
+	// Segments must themselves be OCTET STRINGs.
+	var got []byte
+	_, err := Unmarshal([]byte{0x24, 0x06, 0x04, 0x01, 'a', 0x02, 0x01, 0x01}, &got)
+	if _, ok := err.(StructuralError); !ok {
+		t.Errorf("got %v, want StructuralError", err)
+	}
+}
+
+func TestConstructedBitString(t *testing.T) {
+	// The bits 0110 1110 0101 1101 11 split so that the final segment
+	// carries the six unused bits.
+	in := []byte{0x23, 0x80, 0x03, 0x02, 0x00, 0x6e, 0x03, 0x03, 0x06, 0x5d, 0xc0, 0x00, 0x00}
+	want := asn1.BitString{Bytes: []byte{0x6e, 0x5d, 0xc0}, BitLength: 18}
+	var got asn1.BitString
+	if _, err := Unmarshal(in, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v want %#v", got, want)
+	}
+
+	// Unused bits may only appear in the final segment.
+	in = []byte{0x23, 0x08, 0x03, 0x02, 0x04, 0x60, 0x03, 0x02, 0x00, 0x5d}
+	_, err := Unmarshal(in, &got)
+	if _, ok := err.(StructuralError); !ok {
+		t.Errorf("got %v, want StructuralError", err)
+	}
+}
+
+type derSetOf struct {
+	A []int `asn1:"set"`
+}
+
+func TestUnmarshalDER(t *testing.T) {
+	tests := []struct {
+		in       string // hex encoded
+		out      any
+		der, ber bool // whether the encoding is valid DER and BER
+	}{
+		{"0401ab", new([]byte), true, true},
+		{"3008310602010102010a", new(derSetOf), true, true},
+		{"0481 01ab", new([]byte), false, true},
+		{"048200 01ab", new([]byte), false, true},
+		{"3080 0401ab 0000", new([]byte), false, false},
+		{"2403 0401ab", new([]byte), false, true},
+		{"3008 3106 02010a 020101", new(derSetOf), false, true},
+		{"02020001", new(int), false, true},
+		{"170d 393130353036313634353430 5a", new(time.Time), true, true},
+		{"170b 39313035303631363435 5a", new(time.Time), false, true},
+		{"1711 393130353036313634353430 2d30373030", new(time.Time), false, true},
+		{"180f " + hex.EncodeToString([]byte("20230101120000Z")), new(time.Time), true, true},
+		{"1811 " + hex.EncodeToString([]byte("20230101120000.5Z")), new(time.Time), true, true},
+		{"1813 " + hex.EncodeToString([]byte("20230101120000+0100")), new(time.Time), false, true},
+		{"1811 " + hex.EncodeToString([]byte("20230101120000,5Z")), new(time.Time), false, true},
+		{"1812 " + hex.EncodeToString([]byte("20230101120000.50Z")), new(time.Time), false, true},
+	}
+	for i, test := range tests {
+		in, err := hex.DecodeString(strings.ReplaceAll(test.in, " ", ""))
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		_, err = UnmarshalOptions{DER: true}.Unmarshal(in, test.out)
+		if test.der {
+			if err != nil {
+				t.Errorf("#%d: DER rejected valid input: %s", i, err)
+			}
+		} else if _, ok := err.(StructuralError); !ok {
+			t.Errorf("#%d: got %v, want StructuralError", i, err)
+		}
+		if _, err := Unmarshal(in, test.out); (err == nil) != test.ber {
+			t.Errorf("#%d: BER decoding returned %v", i, err)
+		}
+	}
+}
+
+func TestMarshalDER(t *testing.T) {
+	in := struct {
+		B bool
+		S derSetOf
+	}{true, derSetOf{[]int{10, 1}}}
+	data, err := MarshalOptions{DER: true}.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("300d0101ff3008310602010102010a")
+	if !bytes.Equal(data, want) {
+		t.Errorf("got %x want %x", data, want)
+	}
+	if _, err := (UnmarshalOptions{DER: true}).Unmarshal(data, &in); err != nil {
+		t.Errorf("DER output rejected: %s", err)
+	}
+
+	if _, err := (MarshalOptions{CER: true, DER: true}).Marshal(in); err == nil {
+		t.Error("Marshal succeeded with both CER and DER set")
+	}
+
+	// A GeneralizedTime is converted to UTC.
+	tm := struct {
+		T time.Time `asn1:"generalized"`
+	}{time.Date(2023, 1, 1, 12, 0, 0, 0, time.FixedZone("", 60*60))}
+	data, err = MarshalOptions{DER: true}.Marshal(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "3011180f" + hex.EncodeToString([]byte("20230101110000Z")); hex.EncodeToString(data) != want {
+		t.Errorf("got %x want %s", data, want)
+	}
+	if _, err := (UnmarshalOptions{DER: true}).Unmarshal(data, &tm); err != nil {
+		t.Errorf("DER output rejected: %s", err)
+	}
+}
+
+type errorOffsetStruct struct {
+	A int
+	B struct {
+		C int
+		D bool
+	}
+}
+
+func TestErrorOffset(t *testing.T) {
+	// A bad tag for D.
+	in := []byte{0x30, 0x0b, 0x02, 0x01, 0x01, 0x30, 0x06, 0x02, 0x01, 0x02, 0x04, 0x01, 0x00}
+	var v errorOffsetStruct
+	_, err := Unmarshal(in, &v)
+	se, ok := err.(StructuralError)
+	if !ok {
+		t.Fatalf("got %v, want StructuralError", err)
+	}
+	if se.Offset != 10 {
+		t.Errorf("got offset %d, want 10", se.Offset)
+	}
+	if !strings.HasPrefix(se.Error(), "asn1: structure error: tags don't match") {
+		t.Errorf("unexpected message: %s", se)
+	}
+	var ase asn1.StructuralError
+	if !errors.As(err, &ase) {
+		t.Errorf("%v does not match asn1.StructuralError", err)
+	}
+
+	// A truncated length for D.
+	in = []byte{0x30, 0x0b, 0x02, 0x01, 0x01, 0x30, 0x06, 0x02, 0x01, 0x02, 0x01, 0x84, 0x00}
+	_, err = Unmarshal(in, &v)
+	syn, ok := err.(SyntaxError)
+	if !ok {
+		t.Fatalf("got %v, want SyntaxError", err)
+	}
+	if syn.Offset != 10 {
+		t.Errorf("got offset %d, want 10", syn.Offset)
+	}
+	if want := "asn1: syntax error: truncated tag or length at offset 10"; syn.Error() != want {
+		t.Errorf("got %q want %q", syn, want)
+	}
+}
+
+// versioned has two wire shapes: version 1 is a bare string and later
+// versions are a [0] SEQUENCE of the version number and the string.
+type versioned struct {
+	Version int
+	Name    string
+}
+
+func (v *versioned) UnmarshalBER(tag, class int, fullBytes []byte) error {
+	if class == asn1.ClassUniversal {
+		v.Version = 1
+		_, err := Unmarshal(fullBytes, &v.Name)
+		return err
+	}
+	if class != asn1.ClassContextSpecific || tag != 0 {
+		return asn1.StructuralError{Msg: "unknown versioned shape"}
+	}
+	_, err := UnmarshalWithParams(fullBytes, (*struct {
+		Version int
+		Name    string
+	})(v), "tag:0")
+	return err
+}
+
+func TestUnmarshaler(t *testing.T) {
+	tests := []struct {
+		in  string // hex encoded
+		out versioned
+	}{
+		{"1303616263", versioned{1, "abc"}},
+		{"a0080201021303616263", versioned{2, "abc"}},
+	}
+	for i, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		var got versioned
+		rest, err := Unmarshal(in, &got)
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if len(rest) != 0 || got != test.out {
+			t.Errorf("#%d: got %+v, %x want %+v", i, got, rest, test.out)
+		}
+	}
+
+	var s struct {
+		A []versioned
+		B versioned `asn1:"optional,explicit,tag:1"`
+		C int
+	}
+	in, _ := hex.DecodeString("3014" + "300f" + "1301" + "61" + "a00a020103130568656c6c6f" + "020107")
+	if _, err := Unmarshal(in, &s); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.A, []versioned{{1, "a"}, {3, "hello"}}) || s.B != (versioned{}) || s.C != 7 {
+		t.Errorf("got %+v", s)
+	}
+
+	in, _ = hex.DecodeString("3009" + "3000" + "a1028200" + "020107")
+	_, err := Unmarshal(in, &s)
+	if !strings.Contains(fmt.Sprint(err), "unknown versioned shape") {
+		t.Errorf("got %v, want error from UnmarshalBER", err)
+	}
+}
+
+type extensibleV1 struct {
+	Version int
+	Name    string
+}
+
+type extensibleV2 struct {
+	Version int
+	Name    string
+	Tags    []string
+	Flags   asn1.BitString `asn1:"tag:0"`
+	Extra   struct {
+		A int
+	} `asn1:"explicit,tag:1"`
+}
+
+func TestUnmarshalSkipsUnknownElements(t *testing.T) {
+	v2 := extensibleV2{Version: 2, Name: "abc", Tags: []string{"x", "y"}, Flags: asn1.BitString{Bytes: []byte{0x80}, BitLength: 1}}
+	v2.Extra.A = 5
+	data, err := Marshal(struct {
+		Msg  extensibleV2
+		Next int
+	}{v2, 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The elements of a SEQUENCE which an older version doesn't know
+	// about are skipped, and decoding carries on after the SEQUENCE.
+	var got struct {
+		Msg  extensibleV1
+		Next int
+	}
+	rest, err := Unmarshal(data, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("got %d trailing bytes", len(rest))
+	}
+	if got.Msg != (extensibleV1{2, "abc"}) || got.Next != 9 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+type markedV1 struct {
+	Version int
+	Name    string
+	_       ExtensionMarker
+}
+
+type markedV2 struct {
+	Version int
+	Name    string
+	_       ExtensionMarker
+	Tags    []string
+	Level   int `asn1:"optional,default:3,tag:0"`
+}
+
+func TestExtensionMarker(t *testing.T) {
+	v2 := markedV2{Version: 2, Name: "abc", Tags: []string{"x"}, Level: 1}
+	data, err := Marshal(v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The marker has no element of its own.
+	if want := "301002010213036162633003130178800101"; hex.EncodeToString(data) != want {
+		t.Errorf("got %x, want %s", data, want)
+	}
+
+	// A v2 message decoded as v1 skips the extension additions.
+	var v1 markedV1
+	if _, err := Unmarshal(data, &v1); err != nil {
+		t.Fatal(err)
+	}
+	if v1.Version != 2 || v1.Name != "abc" {
+		t.Errorf("got %+v", v1)
+	}
+
+	// A v1 message decoded as v2 leaves them out, though Tags isn't
+	// optional.
+	data, err = Marshal(markedV1{Version: 1, Name: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got markedV2
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 1 || got.Name != "abc" || got.Tags != nil || got.Level != 3 {
+		t.Errorf("got %+v", got)
+	}
+
+	// Without the marker the missing element is an error.
+	var unmarked struct {
+		Version int
+		Name    string
+		Tags    []string
+	}
+	if _, err := Unmarshal(data, &unmarked); err == nil {
+		t.Error("missing non-optional element accepted")
+	}
+}
+
+type privateTagged struct {
+	A int `asn1:"private,tag:7"`
+	B int `asn1:"private,explicit,tag:8"`
+}
+
+type optionalPrivateTagged struct {
+	A int `asn1:"optional,private,tag:7"`
+	B int `asn1:"tag:7"`
+}
+
+func TestPrivateTags(t *testing.T) {
+	tests := []struct {
+		in string // hex encoded
+		ok bool
+	}{
+		{"3008" + "c70101" + "e803020102", true},
+		{"3008" + "870101" + "e803020102", false}, // context [7]
+		{"3008" + "470101" + "e803020102", false}, // application [7]
+		{"3008" + "c70101" + "a803020102", false}, // explicit context [8]
+	}
+	for i, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		var got privateTagged
+		_, err := Unmarshal(in, &got)
+		if (err == nil) != test.ok {
+			t.Errorf("#%d: got error %v, want success %v", i, err, test.ok)
+			continue
+		}
+		if err == nil && got != (privateTagged{1, 2}) {
+			t.Errorf("#%d: got %+v", i, got)
+		}
+		if _, ok := err.(StructuralError); err != nil && !ok {
+			t.Errorf("#%d: got %v, want StructuralError", i, err)
+		}
+	}
+
+	// A context [7] element is left for the following field rather than
+	// satisfying an optional PRIVATE [7] one.
+	in, _ := hex.DecodeString("3003870105")
+	var got optionalPrivateTagged
+	if _, err := Unmarshal(in, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (optionalPrivateTagged{0, 5}) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+type defaultStruct struct {
+	Version int    `asn1:"optional,explicit,default:2,tag:0"`
+	Count   uint16 `asn1:"optional,default:7"`
+	Name    string `asn1:"utf8"`
+}
+
+func TestUnmarshalDefaultValues(t *testing.T) {
+	tests := []struct {
+		in   string
+		want defaultStruct
+	}{
+		// Both absent.
+		{"30030c0161", defaultStruct{2, 7, "a"}},
+		// Both present with other values.
+		{"300ba003020105020103" + "0c0161", defaultStruct{5, 3, "a"}},
+		// Present with the default values.
+		{"300ba003020102020107" + "0c0161", defaultStruct{2, 7, "a"}},
+		// Only Count present.
+		{"30060201000c0161", defaultStruct{2, 0, "a"}},
+	}
+	for i, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		// Start from a non-zero value to check absent fields are set.
+		got := defaultStruct{Version: 9, Count: 9}
+		if _, err := Unmarshal(in, &got); err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("#%d: got %+v want %+v", i, got, test.want)
+		}
+	}
+
+	// Fields equal to their defaults are omitted when marshaling.
+	data, err := Marshal(defaultStruct{2, 7, "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(data); got != "30030c0161" {
+		t.Errorf("got %s want 30030c0161", got)
+	}
+}
+
+func TestLenientCompound(t *testing.T) {
+	lenient := UnmarshalOptions{LenientCompound: true}
+
+	// An OCTET STRING decodes to the same value in either form, with or
+	// without the option.
+	for _, o := range []UnmarshalOptions{{}, lenient} {
+		for _, in := range []string{"0403686921", "2407" + "04026869" + "040121"} {
+			b, _ := hex.DecodeString(in)
+			var got []byte
+			if _, err := o.Unmarshal(b, &got); err != nil {
+				t.Errorf("%+v %s: %s", o, in, err)
+			} else if string(got) != "hi!" {
+				t.Errorf("%+v %s: got %q want \"hi!\"", o, in, got)
+			}
+		}
+	}
+
+	// A constructed UTF8String needs the option.
+	utf8, _ := hex.DecodeString("2c07" + "04026869" + "040121")
+	var s string
+	if _, err := Unmarshal(utf8, &s); err == nil {
+		t.Error("constructed UTF8String accepted without LenientCompound")
+	}
+	if _, err := lenient.Unmarshal(utf8, &s); err != nil {
+		t.Error(err)
+	} else if s != "hi!" {
+		t.Errorf("got %q want \"hi!\"", s)
+	}
+
+	// As do constructed elements of a SEQUENCE OF strings.
+	seq, _ := hex.DecodeString("300e" + "1603616263" + "3607" + "04026465" + "040166")
+	var ss []string
+	if _, err := Unmarshal(seq, &ss); err == nil {
+		t.Error("constructed IA5String element accepted without LenientCompound")
+	}
+	if _, err := lenient.Unmarshal(seq, &ss); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(ss, []string{"abc", "def"}) {
+		t.Errorf("got %q want [abc def]", ss)
+	}
+
+	// DER still requires the primitive form.
+	if _, err := (UnmarshalOptions{DER: true, LenientCompound: true}).Unmarshal(utf8, &s); err == nil {
+		t.Error("constructed UTF8String accepted with DER")
+	}
+}
+
+type nestedSeq struct {
+	Next []nestedSeq `asn1:"optional"`
+}
+
+// nestedSeqs returns n SEQUENCEs each containing the next, with definite or
+// indefinite lengths.
+func nestedSeqs(n int, indefinite bool) []byte {
+	if indefinite {
+		b := bytes.Repeat([]byte{0x30, 0x80}, n)
+		return append(b, make([]byte, 2*n)...)
+	}
+	var b []byte
+	for i := 0; i < n; i++ {
+		b = append(appendHeader(nil, 0x30, len(b)), b...)
+	}
+	return b
+}
+
+// appendHeader appends the identifier octet tag and the definite length n
+// to dst.
+func appendHeader(dst []byte, tag byte, n int) []byte {
+	dst = append(dst, tag)
+	if n < 128 {
+		return append(dst, byte(n))
+	}
+	var l []byte
+	for ; n > 0; n >>= 8 {
+		l = append([]byte{byte(n)}, l...)
+	}
+	dst = append(dst, 0x80|byte(len(l)))
+	return append(dst, l...)
+}
+
+func TestMaxDepth(t *testing.T) {
+	for _, indefinite := range []bool{false, true} {
+		var v nestedSeq
+		// The outermost SEQUENCE is v itself, the rest elements of Next.
+		if _, err := Unmarshal(nestedSeqs(defaultMaxDepth, indefinite), &v); err != nil {
+			t.Errorf("indefinite=%v: %d levels: %v", indefinite, defaultMaxDepth, err)
+		}
+		_, err := Unmarshal(nestedSeqs(defaultMaxDepth+1, indefinite), &v)
+		if se, ok := err.(StructuralError); !ok || se.Msg != "nesting too deep" {
+			t.Errorf("indefinite=%v: %d levels: got %v, want nesting too deep", indefinite, defaultMaxDepth+1, err)
+		}
+	}
+
+	opts := UnmarshalOptions{MaxDepth: 3}
+	var v nestedSeq
+	if _, err := opts.Unmarshal(nestedSeqs(3, false), &v); err != nil {
+		t.Errorf("3 levels: %v", err)
+	}
+	if _, err := opts.Unmarshal(nestedSeqs(4, false), &v); err == nil {
+		t.Error("4 levels accepted with MaxDepth 3")
+	}
+
+	// Finding the end of an element nested within an indefinite length is
+	// bounded too, even when its contents aren't decoded.
+	var rv asn1.RawValue
+	if _, err := opts.Unmarshal(nestedSeqs(3, true), &rv); err != nil {
+		t.Errorf("RawValue of 3 levels: %v", err)
+	}
+	if _, err := opts.Unmarshal(nestedSeqs(4, true), &rv); err == nil {
+		t.Error("RawValue of 4 levels accepted with MaxDepth 3")
+	}
+	if err := NewDecoder(bytes.NewReader(nestedSeqs(defaultMaxDepth+1, true))).Decode(&rv); err == nil {
+		t.Error("Decoder accepted too deeply nested indefinite lengths")
+	}
+}
+
+// sequenceOfInts returns the encoding of a SEQUENCE OF n INTEGERs, each of
+// two octets.
+func sequenceOfInts(n int) []byte {
+	var contents []byte
+	for i := 256; i < n+256; i++ {
+		contents = append(contents, 0x02, 0x02, byte(i>>8), byte(i))
+	}
+	return append(appendHeader(nil, 0x30, len(contents)), contents...)
+}
+
+func BenchmarkUnmarshalSequenceOf(b *testing.B) {
+	in := sequenceOfInts(10000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var v []int
+		if _, err := Unmarshal(in, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestUnmarshalSequenceOfAllocs(t *testing.T) {
+	allocs := func(n int) float64 {
+		in := sequenceOfInts(n)
+		return testing.AllocsPerRun(10, func() {
+			var v []int
+			if _, err := Unmarshal(in, &v); err != nil || len(v) != n {
+				t.Fatalf("got %d elements, %v", len(v), err)
+			}
+		})
+	}
+	// Only the destination slice is allocated, however many elements
+	// there are.
+	if few, many := allocs(10), allocs(10000); many > few {
+		t.Errorf("%v allocations for 10 elements but %v for 10000", few, many)
+	}
+}
+
+func TestLongFormLength(t *testing.T) {
+	// A five octet length fits a 64-bit int, so the contents are found to
+	// be missing, but not a 32-bit one.
+	var b []byte
+	_, err := Unmarshal([]byte{0x04, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00, 0x61}, &b)
+	if strconv.IntSize == 64 {
+		if se, ok := err.(SyntaxError); !ok || se.Offset != 0 {
+			t.Errorf("got %v, want SyntaxError at offset 0", err)
+		}
+	} else if se, ok := err.(StructuralError); !ok || se.Msg != "length too large" {
+		t.Errorf("got %v, want length too large", err)
+	}
+
+	in := []byte{0x04, 0x89, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	_, err = Unmarshal(in, &b)
+	if se, ok := err.(StructuralError); !ok || se.Msg != "length too large" {
+		t.Errorf("got %v, want length too large", err)
+	}
+
+	// A length within an indefinite length element which would overflow
+	// the offset of its end.
+	in = []byte{0x30, 0x80, 0x04, 0x88, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00}
+	var s struct{ B []byte }
+	_, err = Unmarshal(in, &s)
+	if strconv.IntSize == 64 {
+		if se, ok := err.(SyntaxError); !ok || se.Msg != "data truncated" || se.Offset != 0 {
+			t.Errorf("got %v, want data truncated at offset 0", err)
+		}
+	} else if se, ok := err.(StructuralError); !ok || se.Msg != "length too large" {
+		t.Errorf("got %v, want length too large", err)
+	}
+}
+
+type highTagged struct {
+	A int    `asn1:"tag:200"`
+	B string `asn1:"explicit,tag:31"`
+	C int    `asn1:"application,tag:16384"`
+}
+
+func TestHighTagNumber(t *testing.T) {
+	v := highTagged{1, "x", 2}
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "3011" + "9f81480101" + "bf1f03130178" + "5f8180000102"
+	if got := hex.EncodeToString(out); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	var got highTagged
+	if _, err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != v {
+		t.Errorf("got %+v want %+v", got, v)
+	}
+
+	// A long form tag number with a leading octet contributing no bits
+	// is accepted as BER but not as DER.
+	in, _ := hex.DecodeString("3006" + "9f8081480101")
+	var a struct {
+		A int `asn1:"tag:200"`
+	}
+	if _, err := Unmarshal(in, &a); err != nil {
+		t.Error(err)
+	} else if a.A != 1 {
+		t.Errorf("got %d want 1", a.A)
+	}
+	_, err = UnmarshalOptions{DER: true}.Unmarshal(in, &a)
+	if se, ok := err.(StructuralError); !ok || se.Msg != "non-minimal tag in DER" {
+		t.Errorf("got %v, want non-minimal tag in DER", err)
+	}
+}
+
+type tbsRecord struct {
+	Raw    asn1.RawContent
+	Serial int
+	Name   string `asn1:"utf8"`
+}
+
+type signedRecord struct {
+	TBS       tbsRecord
+	Signature []byte
+}
+
+func TestRawContentSignature(t *testing.T) {
+	// The signed part has an indefinite length, which re-encoding it
+	// would lose.
+	tbs := "3080" + "020107" + "0c0161" + "0000"
+	in, _ := hex.DecodeString("300e" + tbs + "0402abcd")
+	var s signedRecord
+	if _, err := Unmarshal(in, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.TBS.Serial != 7 || s.TBS.Name != "a" {
+		t.Errorf("got %+v", s.TBS)
+	}
+	if got, want := sha256.Sum256(s.TBS.Raw), sha256.Sum256(in[2:12]); got != want {
+		t.Errorf("RawContent %x doesn't hash as the signed bytes", s.TBS.Raw)
+	}
+
+	// The RawContent is written out unchanged, whatever the other fields
+	// hold.
+	s.TBS.Serial = 8
+	out, err := Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, in) {
+		t.Errorf("Marshal got %x, want %x", out, in)
+	}
+
+	// A tag replaces its header, end-of-contents octets included.
+	out, err = MarshalWithParams(s.TBS, "tag:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(out), "a106"+"020107"+"0c0161"; got != want {
+		t.Errorf("tagged: got %s want %s", got, want)
+	}
+}
+
+func TestUnmarshalRest(t *testing.T) {
+	type pair struct {
+		A int
+		B string
+	}
+	in, _ := hex.DecodeString("3006020101130161" + "3006020102130162" + "020103")
+	var got []pair
+	rest := in
+	for i := 0; i < 2; i++ {
+		var p pair
+		var err error
+		if rest, err = Unmarshal(rest, &p); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p)
+	}
+	if want := []pair{{1, "a"}, {2, "b"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+	var n int
+	if rest, err := Unmarshal(rest, &n); err != nil || n != 3 || len(rest) != 0 {
+		t.Errorf("got %d, %x, %v", n, rest, err)
+	}
+
+	exact := UnmarshalOptions{ExpectExactLength: true}
+	var p pair
+	_, err := exact.Unmarshal(in, &p)
+	if want := (SyntaxError{Msg: "trailing data", Offset: 8}); err != want {
+		t.Errorf("got %v want %v", err, want)
+	}
+	if rest, err := exact.Unmarshal(in[16:], &n); err != nil || n != 3 || rest == nil || len(rest) != 0 {
+		t.Errorf("exact: got %d, %x, %v", n, rest, err)
+	}
+}
+
+type extensible struct {
+	Version int
+	Name    string
+	Rest    []asn1.RawValue `asn1:"any"`
+}
+
+func TestUnmatchedElements(t *testing.T) {
+	// Two known fields, then [0] 5 and a constructed [1] { NULL }.
+	data, _ := hex.DecodeString("300d020101130161" + "800105" + "a1020500")
+	var got extensible
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := extensible{Version: 1, Name: "a", Rest: []asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte{0x05}, FullBytes: data[8:11]},
+		{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: []byte{0x05, 0x00}, FullBytes: data[11:]},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	out, err := Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("Marshal got %x, want %x", out, data)
+	}
+
+	// With nothing left over the field is nil.
+	data, _ = hex.DecodeString("3006020101130161")
+	if _, err := Unmarshal(data, &got); err != nil || got.Rest != nil {
+		t.Errorf("got %+v, %v", got, err)
+	}
+
+	for _, v := range []any{
+		&struct {
+			Rest []asn1.RawValue `asn1:"any"`
+			A    int
+		}{},
+		&struct {
+			Rest []int `asn1:"any"`
+		}{},
+	} {
+		if _, err := Unmarshal(data, v); err == nil {
+			t.Errorf("%T: Unmarshal succeeded", v)
+		}
+	}
+}
+
+type tailedMessage struct {
+	ID   int
+	Name string
+	Tail RawTail `asn1:"tail"`
+}
+
+func TestRawTail(t *testing.T) {
+	// SEQUENCE { 1, "a", [0] { 5 }, NULL } with the last two unknown.
+	data, _ := hex.DecodeString("300d" + "020101" + "130161" + "a003020105" + "0500")
+	var got tailedMessage
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a0030201050500"; got.ID != 1 || got.Name != "a" || hex.EncodeToString(got.Tail) != want {
+		t.Errorf("got %+v, want tail %s", got, want)
+	}
+	out, err := Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("got %x, want %x", out, data)
+	}
+
+	// With nothing left over the field is nil.
+	data, _ = hex.DecodeString("3006020101130161")
+	if _, err := Unmarshal(data, &got); err != nil || got.Tail != nil {
+		t.Errorf("got %+v, %v", got, err)
+	}
+
+	for _, test := range []struct {
+		v      any
+		params string
+	}{
+		{&struct {
+			Tail RawTail `asn1:"tail"`
+			A    int
+		}{}, ""},
+		{&struct {
+			Tail []byte `asn1:"tail"`
+		}{}, ""},
+		{&tailedMessage{}, "set"},
+	} {
+		data[0] = 0x30
+		if test.params == "set" {
+			data[0] = 0x31
+		}
+		if _, err := UnmarshalWithParams(data, test.v, test.params); err == nil {
+			t.Errorf("%T: Unmarshal succeeded", test.v)
+		}
+		if _, err := MarshalWithParams(reflect.ValueOf(test.v).Elem().Interface(), test.params); err == nil {
+			t.Errorf("%T: Marshal succeeded", test.v)
+		}
+	}
+}
+
+type (
+	Status    int
+	namedEnum asn1.Enumerated
+	smallCode int8
+)
+
+type definedIntegers struct {
+	S Status
+	E namedEnum `asn1:"enumerated"`
+	C smallCode
+	U uint16 `asn1:"tag:0,enumerated"`
+}
+
+func TestDefinedIntegerTypes(t *testing.T) {
+	in := definedIntegers{S: 3, E: 4, C: -5, U: 7}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(data), "300c"+"020103"+"0a0104"+"0201fb"+"800107"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	var got definedIntegers
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != in {
+		t.Errorf("got %+v, want %+v", got, in)
+	}
+
+	// Without the tag a type defined from Enumerated is an INTEGER.
+	var e struct{ E namedEnum }
+	data, _ = hex.DecodeString("30030a0104")
+	if _, err := Unmarshal(data, &e); err == nil {
+		t.Error("ENUMERATED decoded to an untagged integer type")
+	}
+
+	var c struct{ C smallCode }
+	data, _ = hex.DecodeString("300402020100")
+	if _, err := Unmarshal(data, &c); err == nil {
+		t.Error("256 decoded to an int8")
+	}
+
+	if _, err := Marshal(struct {
+		S string `asn1:"enumerated"`
+	}{}); err == nil {
+		t.Error("enumerated string marshaled")
+	}
+}
+
+func TestNegativeEnumerated(t *testing.T) {
+	tests := []struct {
+		v   asn1.Enumerated
+		enc string
+	}{
+		{-1, "0a01ff"},
+		{-128, "0a0180"},
+		{-129, "0a02ff7f"},
+		{128, "0a020080"},
+	}
+	for _, test := range tests {
+		data, err := Marshal(test.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(data); got != test.enc {
+			t.Errorf("%d: got %s, want %s", test.v, got, test.enc)
+		}
+		var got asn1.Enumerated
+		if _, err := (UnmarshalOptions{DER: true}).Unmarshal(data, &got); err != nil || got != test.v {
+			t.Errorf("%s: got %d, %v, want %d", test.enc, got, err, test.v)
+		}
+
+		// An integer field with the enumerated tag is the same.
+		var field struct {
+			E int16 `asn1:"enumerated"`
+		}
+		if _, err := Unmarshal(append([]byte{0x30, byte(len(data))}, data...), &field); err != nil || field.E != int16(test.v) {
+			t.Errorf("%s: got %d, %v in a field", test.enc, field.E, err)
+		}
+	}
+
+	// A value beyond 32 bits is too large, whichever its sign.
+	for _, in := range []string{"0a050100000000", "0a05feffffffff"} {
+		data, _ := hex.DecodeString(in)
+		var got asn1.Enumerated
+		if _, err := Unmarshal(data, &got); err == nil {
+			t.Errorf("%s: got %d", in, got)
+		}
+	}
+}
+
+func TestSetOfOrder(t *testing.T) {
+	in, _ := hex.DecodeString("3109" + "020103" + "020101" + "020102")
+	var got []int
+	if _, err := UnmarshalWithParams(in, &got, "set"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	out, err := MarshalWithParams(got, "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(out), "3109"+"020101"+"020102"+"020103"; got != want {
+		t.Errorf("Marshal got %s, want %s", got, want)
+	}
+
+	_, err = UnmarshalOptions{DER: true}.UnmarshalWithParams(in, &got, "set")
+	if _, ok := err.(StructuralError); !ok {
+		t.Errorf("DER: got %v, want StructuralError", err)
+	}
+}
+
+type setRecord struct {
+	Name string `asn1:"tag:2"`
+	ID   int    `asn1:"tag:0"`
+	Flag bool   `asn1:"tag:1,optional"`
+}
+
+func TestSetStruct(t *testing.T) {
+	in := setRecord{Name: "ab", ID: 5, Flag: true}
+	data, err := MarshalWithParams(in, "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(data), "310a"+"800105"+"8101ff"+"82026162"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		in   string
+		want *setRecord // nil if it is an error
+		der  bool       // whether DER accepts it
+	}{
+		{"310a" + "800105" + "8101ff" + "82026162", &in, true},
+		{"310a" + "82026162" + "800105" + "8101ff", &in, false},
+		{"3107" + "82026162" + "800105", &setRecord{Name: "ab", ID: 5}, false},
+		{"310a" + "800105" + "82026162" + "800106", nil, false},
+		{"310a" + "800105" + "82026162" + "830101", nil, false},
+		{"3104" + "82026162", nil, false},
+	}
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.in)
+		for _, o := range []UnmarshalOptions{{}, {DER: true}} {
+			var got setRecord
+			_, err := o.UnmarshalWithParams(data, &got, "set")
+			switch {
+			case test.want == nil || o.DER && !test.der:
+				if _, ok := err.(StructuralError); !ok {
+					t.Errorf("%s, DER %t: got %v, want StructuralError", test.in, o.DER, err)
+				}
+			case err != nil:
+				t.Errorf("%s, DER %t: %v", test.in, o.DER, err)
+			case got != *test.want:
+				t.Errorf("%s, DER %t: got %+v, want %+v", test.in, o.DER, got, *test.want)
+			}
+		}
+	}
+}
+
+type optionalPointers struct {
+	A *int    `asn1:"optional"`
+	B *string `asn1:"optional,tag:0"`
+	C *int    `asn1:"optional,explicit,tag:1"`
+}
+
+func TestOptionalPointer(t *testing.T) {
+	zero, one, s := 0, 1, "x"
+	tests := []struct {
+		in  optionalPointers
+		out string
+	}{
+		{optionalPointers{}, "3000"},
+		{optionalPointers{A: &zero}, "3003020100"},
+		{optionalPointers{B: &s}, "3003800178"},
+		{optionalPointers{A: &one, C: &zero}, "3008020101a103020100"},
+	}
+	for _, test := range tests {
+		data, err := Marshal(test.in)
+		if err != nil {
+			t.Errorf("%+v: %v", test.in, err)
+			continue
+		}
+		if got := hex.EncodeToString(data); got != test.out {
+			t.Errorf("%+v: got %s, want %s", test.in, got, test.out)
+		}
+		// A pointer already set is replaced, or cleared if its element
+		// is absent.
+		got := optionalPointers{A: new(int), B: new(string), C: new(int)}
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("%s: %v", test.out, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.in) {
+			t.Errorf("%s: got %+v, want %+v", test.out, got, test.in)
+		}
+	}
+
+	if _, err := Marshal(struct{ A *int }{}); err == nil {
+		t.Error("Marshal of a nil non-optional pointer succeeded")
+	}
+	var p struct{ A *int }
+	data, _ := hex.DecodeString("3000")
+	if _, err := Unmarshal(data, &p); err == nil {
+		t.Error("Unmarshal of a missing non-optional pointer succeeded")
+	}
+}
+
+func TestMaxElementSize(t *testing.T) {
+	o := UnmarshalOptions{MaxElementSize: 3}
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"0403010203", true},
+		{"040401020304", false},
+		// The length is checked before the contents are looked for.
+		{"04847fffffff", false},
+		{"2480040201020401030000", true},
+		{"24800402010204020304" + "0000", false},
+		{"230c030200ff030200ff030200ff", true},
+		{"2310030200ff030200ff030200ff030200ff", false},
+	}
+	for _, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		var v any = new([]byte)
+		if in[0]&0x1f == asn1.TagBitString {
+			v = new(asn1.BitString)
+		}
+		_, err := o.Unmarshal(in, v)
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.in, err)
+		} else if _, ok := err.(StructuralError); !test.ok && !ok {
+			t.Errorf("%s: got %v, want StructuralError", test.in, err)
+		}
+	}
+
+	// Only primitive elements are limited.
+	var s struct{ A, B []byte }
+	in, _ := hex.DecodeString("300a04030102030403040506")
+	if _, err := o.Unmarshal(in, &s); err != nil {
+		t.Error(err)
+	}
+
+	in, _ = hex.DecodeString("04847fffffff")
+	var b []byte
+	err := o.NewDecoder(bytes.NewReader(in)).Decode(&b)
+	if _, ok := err.(StructuralError); !ok {
+		t.Errorf("Decoder: got %v, want StructuralError", err)
+	}
+}
+
+func TestErrorTypes(t *testing.T) {
+	tests := []struct {
+		in         string
+		v          any
+		structural bool // whether the error is a StructuralError rather than a SyntaxError
+		want       string
+	}{
+		{"020101" + "00", new(int), false, "asn1: syntax error: trailing data at offset 3"},
+		{"04ff", new([]byte), true, "asn1: structure error: reserved length octet at offset 0"},
+		{"0c02c328", new(string), false, "asn1: syntax error: invalid UTF-8 string at offset 0"},
+		{"170d3236313031343136303733a15a", new(time.Time), false, ""},
+		{"1806323032363130", new(time.Time), false, ""},
+		{"020101", new(string), true, ""},
+	}
+	o := UnmarshalOptions{ExpectExactLength: true}
+	for _, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		_, err := o.Unmarshal(in, test.v)
+		var se StructuralError
+		var sy SyntaxError
+		if test.structural && !errors.As(err, &se) || !test.structural && !errors.As(err, &sy) {
+			t.Errorf("%s: got %T %v, structural %t", test.in, err, err, test.structural)
+			continue
+		}
+		if test.want != "" && err.Error() != test.want {
+			t.Errorf("%s: got %q, want %q", test.in, err, test.want)
+		}
+		// The errors also match those of encoding/asn1.
+		var ase asn1.StructuralError
+		var asy asn1.SyntaxError
+		if test.structural && !errors.As(err, &ase) || !test.structural && !errors.As(err, &asy) {
+			t.Errorf("%s: %T doesn't match its encoding/asn1 type", test.in, err)
+		}
+	}
+
+	if _, _, err := DecodeToMap(nil); !errors.As(err, new(SyntaxError)) {
+		t.Errorf("DecodeToMap(nil): got %T %v", err, err)
+	}
+}
+
+func TestStrictBoolean(t *testing.T) {
+	tests := []struct {
+		in      string
+		lenient error // the error without StrictBoolean, nil if TRUE
+		strict  error
+	}{
+		{"0101ff", nil, nil},
+		{"010101", nil, StructuralError{Msg: "invalid boolean"}},
+		{"010180", nil, StructuralError{Msg: "invalid boolean"}},
+		{"0102ffff", StructuralError{Msg: "invalid boolean"}, StructuralError{Msg: "invalid boolean"}},
+	}
+	for _, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		for _, o := range []UnmarshalOptions{{}, {StrictBoolean: true}, {DER: true}} {
+			want := test.lenient
+			if o.StrictBoolean || o.DER {
+				want = test.strict
+			}
+			var b bool
+			_, err := o.Unmarshal(in, &b)
+			if err != want {
+				t.Errorf("%s %+v: got %v want %v", test.in, o, err, want)
+			} else if err == nil && !b {
+				t.Errorf("%s %+v: got false", test.in, o)
+			}
+		}
+	}
+
+	var b bool
+	if _, err := (UnmarshalOptions{StrictBoolean: true}).Unmarshal([]byte{0x01, 0x01, 0x00}, &b); err != nil || b {
+		t.Errorf("got %v, %v for FALSE", b, err)
+	}
+	if out, err := Marshal(true); err != nil || hex.EncodeToString(out) != "0101ff" {
+		t.Errorf("Marshal(true) = %x, %v", out, err)
+	}
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	type message struct {
+		ID   int
+		Body []byte
+	}
+	// Each prefix of a complete message needs more input; this one has an
+	// indefinite length.
+	full, _ := hex.DecodeString("3080" + "020107" + "1f8101" + "03" + "616263" + "0000")
+	var m message
+	for n := 0; n < len(full); n++ {
+		_, err := UnmarshalWithParams(full[:n], &m, "")
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%x: got %v, want a truncated error", full[:n], err)
+		}
+	}
+
+	// Input which is malformed doesn't.
+	for _, test := range []string{
+		"30ff",              // reserved length octet
+		"3003" + "020507",   // the INTEGER overruns the SEQUENCE
+		"3004" + "02020007", // non-minimal INTEGER
+		"3002" + "0201",     // ends within the complete SEQUENCE
+	} {
+		in, _ := hex.DecodeString(test)
+		_, err := Unmarshal(in, &m)
+		if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: got %v, want a malformed error", test, err)
+		}
+	}
+	_, err := Unmarshal([]byte{0x30, 0xff}, &m)
+	if want := (StructuralError{Msg: "reserved length octet"}); err != want {
+		t.Errorf("got %v, want %v", err, want)
+	}
+}
+
+func TestBitStringUnusedBits(t *testing.T) {
+	// Five bits, 10110, with the three unused bits set.
+	in, _ := hex.DecodeString("030203b7")
+	var bs asn1.BitString
+	if _, err := Unmarshal(in, &bs); err != nil {
+		t.Fatal(err)
+	}
+	if want := (asn1.BitString{Bytes: []byte{0xb0}, BitLength: 5}); !reflect.DeepEqual(bs, want) {
+		t.Errorf("got %+v want %+v", bs, want)
+	}
+	if in[3] != 0xb7 {
+		t.Error("Unmarshal changed its input")
+	}
+
+	_, err := UnmarshalOptions{DER: true}.Unmarshal(in, &bs)
+	if want := (StructuralError{Msg: "non-zero unused bits in BIT STRING"}); err != want {
+		t.Errorf("DER: got %v want %v", err, want)
+	}
+	in[3] = 0xb0
+	if _, err := (UnmarshalOptions{DER: true}).Unmarshal(in, &bs); err != nil {
+		t.Errorf("DER: %s", err)
+	}
+
+	// Marshal never writes unused bits.
+	out, err := Marshal(asn1.BitString{Bytes: []byte{0xb7}, BitLength: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(out); got != "030203b0" {
+		t.Errorf("got %s want 030203b0", got)
+	}
+}
+
+func TestNonMinimalInteger(t *testing.T) {
+	tests := []struct {
+		in      string
+		out     int64
+		minimal bool
+	}{
+		{"020100", 0, true},
+		{"0202" + "0080", 128, true},
+		{"0203" + "00007f", 127, false},
+		{"0203" + "000080", 128, false},
+		{"0203" + "ffff80", -128, false},
+		{"0203" + "ffff7f", -129, false},
+		{"0204" + "ffffffff", -1, false},
+	}
+	for _, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		var i int64
+		if _, err := Unmarshal(in, &i); err != nil || i != test.out {
+			t.Errorf("%s: got %d, %v want %d", test.in, i, err, test.out)
+		}
+		var b *big.Int
+		if _, err := Unmarshal(in, &b); err != nil || b.Int64() != test.out {
+			t.Errorf("%s: got %v, %v want %d", test.in, b, err, test.out)
+		}
+		var e asn1.Enumerated
+		in[0] = asn1.TagEnum
+		if _, err := Unmarshal(in, &e); err != nil || int64(e) != test.out {
+			t.Errorf("%s: got ENUMERATED %d, %v want %d", test.in, e, err, test.out)
+		}
+
+		in[0] = asn1.TagInteger
+		_, err := UnmarshalOptions{DER: true}.Unmarshal(in, &i)
+		if test.minimal {
+			if err != nil {
+				t.Errorf("%s: DER: %s", test.in, err)
+			}
+		} else if want := (StructuralError{Msg: "integer not minimally-encoded"}); err != want {
+			t.Errorf("%s: DER: got %v want %v", test.in, err, want)
+		}
+	}
+}
+
+func TestNonMinimalLengthDER(t *testing.T) {
+	type wrapper struct {
+		B []byte
+	}
+	long := strings.Repeat("ab", 200)
+	tests := []struct {
+		in      string
+		out     any
+		minimal bool
+	}{
+		{"0405" + "0102030405", new([]byte), true},
+		{"048105" + "0102030405", new([]byte), false},
+		{"04820005" + "0102030405", new([]byte), false},
+		{"0481c8" + long, new([]byte), true},
+		{"048200c8" + long, new([]byte), false},
+		// Within a SEQUENCE, and as the SEQUENCE's own length.
+		{"3006" + "04820002" + "0102", new(wrapper), false},
+		{"308104" + "04020102", new(wrapper), false},
+	}
+	for _, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		if _, err := Unmarshal(in, test.out); err != nil {
+			t.Errorf("%.16s: BER: %s", test.in, err)
+		}
+		_, err := UnmarshalOptions{DER: true}.Unmarshal(in, test.out)
+		if test.minimal {
+			if err != nil {
+				t.Errorf("%.16s: DER: %s", test.in, err)
+			}
+		} else if se, ok := err.(StructuralError); !ok || se.Msg != "non-minimal length in DER" {
+			t.Errorf("%.16s: DER: got %v, want non-minimal length in DER", test.in, err)
+		}
+	}
 }
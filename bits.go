@@ -0,0 +1,123 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"reflect"
+)
+
+// ParseBitString returns the BIT STRING whose bits, first to last, are given
+// by the '0' and '1' characters of bits, as in ParseBitString("10110").
+func ParseBitString(bits string) (asn1.BitString, error) {
+	ret := asn1.BitString{Bytes: make([]byte, (len(bits)+7)/8), BitLength: len(bits)}
+	for i := 0; i < len(bits); i++ {
+		switch bits[i] {
+		case '1':
+			ret.Bytes[i/8] |= 0x80 >> uint(i%8)
+		case '0':
+		default:
+			return asn1.BitString{}, fmt.Errorf("asn1: invalid character %q in bit string", bits[i])
+		}
+	}
+	return ret, nil
+}
+
+// BitStringFromBytes returns the BIT STRING of the first bitLen bits of data,
+// the most significant bit of each octet coming first. The octets are copied,
+// with any bits past bitLen cleared as DER requires.
+func BitStringFromBytes(data []byte, bitLen int) (asn1.BitString, error) {
+	if bitLen < 0 || bitLen > 8*len(data) {
+		return asn1.BitString{}, fmt.Errorf("asn1: bit length %d out of range for %d bytes", bitLen, len(data))
+	}
+	ret := asn1.BitString{Bytes: append([]byte(nil), data[:(bitLen+7)/8]...), BitLength: bitLen}
+	if unused := uint(len(ret.Bytes)*8 - bitLen); unused > 0 {
+		ret.Bytes[len(ret.Bytes)-1] &^= 1<<unused - 1
+	}
+	return ret, nil
+}
+
+// isNamedBits reports whether t is a struct whose bool fields are the named
+// bits of a BIT STRING, as shown by a bit parameter on its first field.
+func isNamedBits(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && getStructInfo(t).namedBits
+}
+
+// namedBits returns the bit each field of the named bit struct type t is, or
+// -1 for a skipped field.
+func namedBits(t reflect.Type) ([]int, error) {
+	bits := make([]int, t.NumField())
+	for i := range bits {
+		field := t.Field(i)
+		if isSkippedField(field) {
+			bits[i] = -1
+			continue
+		}
+		params := structFieldParameters(field)
+		if !field.IsExported() || field.Type.Kind() != reflect.Bool || params.bit == nil {
+			return nil, params.structuralError("named bit must be an exported bool with a bit")
+		}
+		if *params.bit < 0 {
+			return nil, params.structuralError("named bit out of range")
+		}
+		bits[i] = *params.bit
+	}
+	return bits, nil
+}
+
+// makeNamedBits encodes a struct of named bits. Trailing zero bits are
+// removed, as X.690 section 11.2.2 requires for DER.
+func makeNamedBits(v reflect.Value) (e encoder, err error) {
+	bits, err := namedBits(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	var ret asn1.BitString
+	for i, bit := range bits {
+		if bit >= 0 && v.Field(i).Bool() && bit >= ret.BitLength {
+			ret.BitLength = bit + 1
+		}
+	}
+	ret.Bytes = make([]byte, (ret.BitLength+7)/8)
+	for i, bit := range bits {
+		if bit >= 0 && v.Field(i).Bool() {
+			ret.Bytes[bit/8] |= 0x80 >> uint(bit%8)
+		}
+	}
+	return bitStringEncoder(ret), nil
+}
+
+// setNamedBits sets the fields of the struct of named bits v from bs. Bits
+// beyond the end of bs are zero.
+func setNamedBits(v reflect.Value, bs asn1.BitString) error {
+	bits, err := namedBits(v.Type())
+	if err != nil {
+		return err
+	}
+	for i, bit := range bits {
+		if bit < 0 {
+			continue
+		}
+		v.Field(i).SetBool(bs.At(bit) != 0)
+	}
+	return nil
+}
+
+// makeBoolBits encodes the []bool v as a BIT STRING, element i being bit i.
+func makeBoolBits(v reflect.Value) encoder {
+	ret := asn1.BitString{Bytes: make([]byte, (v.Len()+7)/8), BitLength: v.Len()}
+	for i := 0; i < v.Len(); i++ {
+		if v.Index(i).Bool() {
+			ret.Bytes[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return bitStringEncoder(ret)
+}
+
+// setBoolBits sets the []bool v to the bits of bs.
+func setBoolBits(v reflect.Value, bs asn1.BitString) {
+	bits := make([]bool, bs.BitLength)
+	for i := range bits {
+		bits[i] = bs.At(i) != 0
+	}
+	v.Set(reflect.ValueOf(bits))
+}
@@ -0,0 +1,227 @@
+package ber
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+type keyUsage struct {
+	DigitalSignature bool `asn1:"bit:0"`
+	NonRepudiation   bool `asn1:"bit:1"`
+	KeyEncipherment  bool `asn1:"bit:2"`
+	DataEncipherment bool `asn1:"bit:3"`
+	KeyAgreement     bool `asn1:"bit:4"`
+	KeyCertSign      bool `asn1:"bit:5"`
+	CRLSign          bool `asn1:"bit:6"`
+	EncipherOnly     bool `asn1:"bit:7"`
+	DecipherOnly     bool `asn1:"bit:8"`
+}
+
+var namedBitsTests = []struct {
+	in  keyUsage
+	out string
+}{
+	{keyUsage{}, "030100"},
+	{keyUsage{DigitalSignature: true, KeyEncipherment: true}, "030205a0"},
+	{keyUsage{KeyCertSign: true, CRLSign: true}, "03020106"},
+	{keyUsage{DecipherOnly: true}, "0303070080"},
+}
+
+func TestNamedBits(t *testing.T) {
+	for i, test := range namedBitsTests {
+		out, err := Marshal(test.in)
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if got := hex.EncodeToString(out); got != test.out {
+			t.Errorf("#%d: got %s want %s", i, got, test.out)
+		}
+		var got keyUsage
+		if _, err := Unmarshal(out, &got); err != nil {
+			t.Errorf("#%d: %s", i, err)
+		} else if got != test.in {
+			t.Errorf("#%d: got %+v want %+v", i, got, test.in)
+		}
+	}
+
+	// Trailing zero bits needn't have been removed, and bits past the last
+	// named bit are ignored.
+	in, _ := hex.DecodeString("030400a000ff")
+	var ku keyUsage
+	if _, err := Unmarshal(in, &ku); err != nil {
+		t.Fatal(err)
+	}
+	if want := (keyUsage{DigitalSignature: true, KeyEncipherment: true}); ku != want {
+		t.Errorf("got %+v want %+v", ku, want)
+	}
+}
+
+type skippedBits struct {
+	A       bool `asn1:"bit:0"`
+	changed bool `asn1:"-"`
+	B       bool `asn1:"bit:2"`
+}
+
+func TestNamedBitsSkipped(t *testing.T) {
+	out, err := Marshal(skippedBits{A: true, changed: true, B: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(out), "030205a0"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	got := skippedBits{changed: true}
+	if _, err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (skippedBits{A: true, changed: true, B: true}); got != want {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+}
+
+func TestNamedBitsInSequence(t *testing.T) {
+	type extension struct {
+		Critical bool `asn1:"optional"`
+		Usage    keyUsage
+		Tagged   keyUsage `asn1:"tag:0"`
+	}
+	v := extension{true, keyUsage{CRLSign: true}, keyUsage{NonRepudiation: true}}
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("300b" + "0101ff" + "03020102" + "80020640")
+	if !bytes.Equal(out, want) {
+		t.Errorf("got %x want %x", out, want)
+	}
+	var got extension
+	if _, err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != v {
+		t.Errorf("got %+v want %+v", got, v)
+	}
+}
+
+func TestNamedBitsInvalid(t *testing.T) {
+	type negative struct {
+		A bool `asn1:"bit:-1"`
+	}
+	type notBool struct {
+		A bool `asn1:"bit:0"`
+		B int  `asn1:"bit:1"`
+	}
+	type unnamed struct {
+		A bool `asn1:"bit:0"`
+		B bool
+	}
+	in := []byte{0x03, 0x02, 0x07, 0x80}
+	for _, v := range []any{&negative{}, &notBool{}, &unnamed{}} {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("%T: marshaled without error", v)
+		}
+		if _, err := Unmarshal(in, v); err == nil {
+			t.Errorf("%T: unmarshaled without error", v)
+		}
+	}
+}
+
+type boolBits struct {
+	Bits []bool `asn1:"bits"`
+}
+
+func TestBoolBits(t *testing.T) {
+	v := boolBits{[]bool{true, false, true, false, false, false, false, false, false, true}}
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("3005" + "0303" + "06a040")
+	if !bytes.Equal(out, want) {
+		t.Errorf("got %x want %x", out, want)
+	}
+	var got boolBits
+	if _, err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("got %v want %v", got.Bits, v.Bits)
+	}
+
+	// Without the tag a []bool is a SEQUENCE OF BOOLEAN.
+	out, err = Marshal([]bool{true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := hex.DecodeString("30030101ff"); !bytes.Equal(out, want) {
+		t.Errorf("got %x want %x", out, want)
+	}
+
+	type notBool struct {
+		Bits []int `asn1:"bits"`
+	}
+	if _, err := Marshal(notBool{[]int{1}}); err == nil {
+		t.Error("bits accepted on a []int when marshaling")
+	}
+	if _, err := Unmarshal(want, &notBool{}); err == nil {
+		t.Error("bits accepted on a []int when unmarshaling")
+	}
+}
+
+func TestParseBitString(t *testing.T) {
+	bs, err := ParseBitString("10110")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (asn1.BitString{Bytes: []byte{0xb0}, BitLength: 5}); !reflect.DeepEqual(bs, want) {
+		t.Errorf("got %+v want %+v", bs, want)
+	}
+	out, err := Marshal(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(out); got != "030203b0" {
+		t.Errorf("got %s want 030203b0", got)
+	}
+	var got asn1.BitString
+	if _, err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, bs) {
+		t.Errorf("got %+v want %+v", got, bs)
+	}
+
+	if bs, err := ParseBitString(""); err != nil || bs.BitLength != 0 || len(bs.Bytes) != 0 {
+		t.Errorf("got %+v, %v for no bits", bs, err)
+	}
+	if _, err := ParseBitString("10120"); err == nil {
+		t.Error("parsed an invalid character")
+	}
+}
+
+func TestBitStringFromBytes(t *testing.T) {
+	data := []byte{0xff, 0xff}
+	bs, err := BitStringFromBytes(data, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (asn1.BitString{Bytes: []byte{0xff, 0xf0}, BitLength: 12}); !reflect.DeepEqual(bs, want) {
+		t.Errorf("got %+v want %+v", bs, want)
+	}
+	if data[1] != 0xff {
+		t.Error("BitStringFromBytes changed its input")
+	}
+
+	// Octets past the bit length are left out.
+	if bs, err := BitStringFromBytes(data, 3); err != nil || !bytes.Equal(bs.Bytes, []byte{0xe0}) {
+		t.Errorf("got %+v, %v", bs, err)
+	}
+	for _, bitLen := range []int{-1, 17} {
+		if _, err := BitStringFromBytes(data, bitLen); err == nil {
+			t.Errorf("%d bits of 2 bytes: no error", bitLen)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"errors"
+	"reflect"
+	"slices"
+)
+
+// A Builder assembles an encoding by hand, an element at a time, rather than
+// by marshaling a Go value. The elements added to it are kept until Bytes is
+// called, which works out the lengths of the constructed elements before
+// writing them, so the contents of a SEQUENCE can be added before its length
+// is known. The zero value is ready to use, and encodes as Marshal does.
+//
+// An error from adding an element, such as an invalid object identifier, is
+// kept and returned by Bytes. The elements added after it are ignored.
+type Builder struct {
+	opts     MarshalOptions
+	elements []encoder
+	err      error
+}
+
+// NewBuilder returns a new, empty builder which encodes with the options o.
+func (o MarshalOptions) NewBuilder() *Builder {
+	return &Builder{opts: o}
+}
+
+// AddInt adds an INTEGER.
+func (b *Builder) AddInt(v int64) {
+	b.add(v)
+}
+
+// AddOctetString adds an OCTET STRING holding a copy of v.
+func (b *Builder) AddOctetString(v []byte) {
+	b.add(slices.Clone(v))
+}
+
+// AddOID adds an OBJECT IDENTIFIER.
+func (b *Builder) AddOID(oid asn1.ObjectIdentifier) {
+	b.add(slices.Clone(oid))
+}
+
+// AddBool adds a BOOLEAN.
+func (b *Builder) AddBool(v bool) {
+	b.add(v)
+}
+
+// AddSequence adds a SEQUENCE, whose elements are those fn adds to the
+// builder it is given.
+func (b *Builder) AddSequence(fn func(*Builder)) {
+	b.addConstructed(asn1.ClassUniversal, asn1.TagSequence, fn)
+}
+
+// AddExplicit adds an element with the given class and tag number, which is
+// constructed from the elements fn adds to the builder it is given, as an
+// explicit tag wraps a value.
+func (b *Builder) AddExplicit(class, tag int, fn func(*Builder)) {
+	if b.err == nil && (class < asn1.ClassUniversal || class > asn1.ClassPrivate || tag < 0) {
+		b.err = errors.New("asn1: invalid class or tag given to AddExplicit")
+	}
+	b.addConstructed(class, tag, fn)
+}
+
+// Bytes returns the encoding of the elements added, one after another, or the
+// first error from adding one.
+func (b *Builder) Bytes() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.opts.CER && b.opts.DER {
+		return nil, errors.New("asn1: CER and DER are mutually exclusive")
+	}
+	e := multiEncoder(b.elements)
+	out := make([]byte, e.Len())
+	e.Encode(out)
+	return out, nil
+}
+
+// add adds the element v is marshaled as.
+func (b *Builder) add(v any) {
+	if b.err != nil {
+		return
+	}
+	e, err := b.opts.makeField(reflect.ValueOf(v), fieldParameters{})
+	if err != nil {
+		b.err = err
+		return
+	}
+	b.elements = append(b.elements, e)
+}
+
+// addConstructed adds a constructed element with the given class and tag
+// number holding the elements fn adds.
+func (b *Builder) addConstructed(class, tag int, fn func(*Builder)) {
+	if b.err != nil {
+		return
+	}
+	inner := &Builder{opts: b.opts}
+	fn(inner)
+	if inner.err != nil {
+		b.err = inner.err
+		return
+	}
+	b.elements = append(b.elements, b.opts.tagged(class, tag, true, multiEncoder(inner.elements)))
+}
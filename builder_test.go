@@ -0,0 +1,91 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	var b Builder
+	b.AddSequence(func(b *Builder) {
+		b.AddInt(-129)
+		b.AddOctetString([]byte("hi"))
+		b.AddExplicit(asn1.ClassContextSpecific, 1, func(b *Builder) {
+			b.AddBool(true)
+			b.AddOID(asn1.ObjectIdentifier{2, 5, 4, 3})
+		})
+	})
+	got, err := b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "3012" + "0202ff7f" + "04026869" + "a108" + "0101ff" + "0603550403"
+	if hex.EncodeToString(got) != want {
+		t.Errorf("got %x, want %s", got, want)
+	}
+
+	var out struct {
+		N     int
+		Data  []byte
+		Inner struct {
+			Flag bool
+			OID  asn1.ObjectIdentifier
+		} `asn1:"tag:1"`
+	}
+	if _, err := Unmarshal(got, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.N != -129 || string(out.Data) != "hi" || !out.Inner.Flag || !out.Inner.OID.Equal(asn1.ObjectIdentifier{2, 5, 4, 3}) {
+		t.Errorf("got %+v", out)
+	}
+
+	// A SEQUENCE { INTEGER, OCTET STRING } decodes into a struct.
+	b = Builder{}
+	b.AddSequence(func(b *Builder) {
+		b.AddInt(7)
+		b.AddOctetString(make([]byte, 200))
+	})
+	got, err = b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg struct {
+		ID      int
+		Payload []byte
+	}
+	if _, err := (UnmarshalOptions{ExpectExactLength: true}).Unmarshal(got, &msg); err != nil || msg.ID != 7 || len(msg.Payload) != 200 {
+		t.Errorf("got %d, %d bytes, %v", msg.ID, len(msg.Payload), err)
+	}
+}
+
+func TestBuilderCER(t *testing.T) {
+	b := MarshalOptions{CER: true}.NewBuilder()
+	b.AddSequence(func(b *Builder) {
+		b.AddInt(1)
+	})
+	got, err := b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "3080" + "020101" + "0000"; hex.EncodeToString(got) != want {
+		t.Errorf("got %x, want %s", got, want)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	var b Builder
+	b.AddSequence(func(b *Builder) {
+		b.AddOID(asn1.ObjectIdentifier{3})
+	})
+	b.AddInt(1)
+	if _, err := b.Bytes(); err == nil {
+		t.Error("built an invalid OBJECT IDENTIFIER")
+	}
+
+	b = Builder{}
+	b.AddExplicit(4, 0, func(b *Builder) {})
+	if _, err := b.Bytes(); err == nil {
+		t.Error("built an invalid class")
+	}
+}
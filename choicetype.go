@@ -0,0 +1,40 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"reflect"
+	"sync"
+)
+
+// choiceTypes maps the class and tag number of each registered element to the
+// Go type it is decoded into.
+var choiceTypes = struct {
+	sync.RWMutex
+	m map[[2]int]reflect.Type
+}{m: make(map[[2]int]reflect.Type)}
+
+// RegisterChoiceType records that an element of the given class and tag
+// number, when unmarshaled into an interface{}, is decoded into a new value
+// of the type of proto, which is then stored in the interface. The element's
+// tag is taken as an implicit tag of the type, so proto's own tag doesn't
+// matter. If proto is a pointer the value stored is a pointer too. A later
+// registration of the same class and tag replaces the type. RegisterChoiceType
+// panics if class is not 0 to 3, tag is negative or proto is nil. It may be
+// called from several goroutines at once.
+func RegisterChoiceType(class, tag int, proto any) {
+	if class < asn1.ClassUniversal || class > asn1.ClassPrivate || tag < 0 || proto == nil {
+		panic("ber: invalid class, tag or type given to RegisterChoiceType")
+	}
+	choiceTypes.Lock()
+	defer choiceTypes.Unlock()
+	choiceTypes.m[[2]int{class, tag}] = reflect.TypeOf(proto)
+}
+
+// lookupChoiceType returns the type registered for elements of the given class
+// and tag number, and whether there is one.
+func lookupChoiceType(class, tag int) (t reflect.Type, ok bool) {
+	choiceTypes.RLock()
+	defer choiceTypes.RUnlock()
+	t, ok = choiceTypes.m[[2]int{class, tag}]
+	return
+}
@@ -0,0 +1,79 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+type choiceTypeSequence struct {
+	A int
+}
+
+func TestRegisterChoiceType(t *testing.T) {
+	RegisterChoiceType(asn1.ClassPrivate, 20, choiceTypeSequence{})
+	RegisterChoiceType(asn1.ClassPrivate, 21, new(string))
+
+	s := "hi"
+	tests := []struct {
+		in   string
+		opts UnmarshalOptions
+		want any
+		err  error
+	}{
+		{"f403020105", UnmarshalOptions{}, choiceTypeSequence{5}, nil},
+		{"d5026869", UnmarshalOptions{}, &s, nil},
+		{"020105", UnmarshalOptions{}, int64(5), nil},
+		{"850107", UnmarshalOptions{}, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 5, Bytes: []byte{7}, FullBytes: []byte{0x85, 0x01, 0x07}}, nil},
+		{"850107", UnmarshalOptions{RequireChoiceType: true}, nil, StructuralError{Msg: "no type registered for tag 5 of class 2"}},
+	}
+	for _, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		var got any
+		_, err := test.opts.Unmarshal(in, &got)
+		if test.err != nil {
+			if err != test.err {
+				t.Errorf("%s: got error %v, want %v", test.in, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.in, got, test.want)
+		}
+	}
+
+	// An element that doesn't match the registered type is an error, not
+	// a RawValue.
+	for _, test := range []struct {
+		in     string
+		offset int
+	}{
+		{"d4026869", 0},
+		{"f403040105", 2},
+	} {
+		in, _ := hex.DecodeString(test.in)
+		var got any
+		_, err := Unmarshal(in, &got)
+		if se, ok := err.(StructuralError); !ok || se.Offset != test.offset {
+			t.Errorf("%s: got %v, want a StructuralError at offset %d", test.in, err, test.offset)
+		}
+	}
+
+	// The alternatives of a CHOICE held in a struct field.
+	var msg struct {
+		ID  int
+		Alt any
+	}
+	in, _ := hex.DecodeString("3008020101" + "f403020105")
+	if _, err := Unmarshal(in, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if want := (choiceTypeSequence{5}); msg.Alt != want {
+		t.Errorf("got %#v, want %#v", msg.Alt, want)
+	}
+}
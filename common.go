@@ -6,11 +6,39 @@ package ber
 
 import (
 	"encoding/asn1"
+	"fmt"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
+// ASN.1 universal tags which are not provided by encoding/asn1.
+const (
+	tagReal            = 9
+	tagVisibleString   = 26
+	tagUniversalString = 28
+	tagRelativeOID     = 13
+	tagDate            = 31
+	tagTimeOfDay       = 32
+	tagDateTime        = 33
+	tagDuration        = 34
+)
+
+// timeTypeLayouts gives the time layouts of the contents of the DATE,
+// TIME-OF-DAY and DATE-TIME types of X.680, which are their ISO 8601 forms.
+var timeTypeLayouts = map[int]string{
+	tagDate:      "2006-01-02",
+	tagTimeOfDay: "15:04:05",
+	tagDateTime:  "2006-01-02T15:04:05",
+}
+
+// timeTypeAuto is the time type of the autotime tag, which chooses between
+// UTCTime and GeneralizedTime by the year as RFC 5280 section 4.1.2.5 does.
+const timeTypeAuto = -1
+
 type tagAndLength struct {
 	class, tag, length int
 	isCompound         bool
@@ -33,7 +61,8 @@ type tagAndLength struct {
 //
 // You can layer EXPLICIT and IMPLICIT tags to an arbitrary depth, however we
 // don't support that here. We support a single layer of EXPLICIT or IMPLICIT
-// tagging with tag strings on the fields of a structure.
+// tagging with tag strings on the fields of a structure, and layers of
+// EXPLICIT tags all of the same class with the explicit:x:y form.
 
 // fieldParameters is the parsed representation of tag string from a structure field.
 type fieldParameters struct {
@@ -41,12 +70,29 @@ type fieldParameters struct {
 	explicit     bool   // true iff an EXPLICIT tag is in use.
 	application  bool   // true iff an APPLICATION tag is in use.
 	private      bool   // true iff a PRIVATE tag is in use.
+	universal    bool   // true iff a UNIVERSAL tag is in use.
 	defaultValue *int64 // a default value for INTEGER typed fields (maybe nil).
 	tag          *int   // the EXPLICIT or IMPLICIT tag (maybe nil).
+	outerTags    []int  // further EXPLICIT tags wrapping tag, outermost first.
 	stringType   int    // the string tag to use when marshaling.
 	timeType     int    // the time tag to use when marshaling.
+	timeLayout   string // the Go layout of the contents of a GeneralizedTime (maybe empty).
 	set          bool   // true iff this should be encoded as a SET
+	relative     bool   // true iff an ObjectIdentifier is a RELATIVE-OID.
+	enumerated   bool   // true iff an integer is an ENUMERATED.
+	bits         bool   // true iff a []bool is a BIT STRING.
 	omitEmpty    bool   // true iff this should be omitted if empty when marshaling.
+	choice       bool   // true iff this is a CHOICE between the fields of a struct.
+	unmatched    bool   // true iff this []asn1.RawValue holds the elements after those of the other fields.
+	tail         bool   // true iff this RawTail holds the contents after the elements of the other fields.
+	minSize      *int   // the least number of characters or elements (maybe nil).
+	maxSize      *int   // the greatest number of characters or elements (maybe nil).
+	min          *int64 // the least value of INTEGER typed fields (maybe nil).
+	max          *int64 // the greatest value of INTEGER typed fields (maybe nil).
+	precision    *int   // the decimal places to round a *big.Rat REAL to (maybe nil).
+	frac         *int   // the most fractional digits of the seconds of a GeneralizedTime (maybe nil).
+	bit          *int   // the named bit of a BIT STRING a bool field is (maybe nil).
+	name         string // the name of the struct field, used in error messages.
 
 	// Invariants:
 	//   if explicit is set, tag is non-nil.
@@ -69,20 +115,65 @@ func parseFieldParameters(str string) (ret fieldParameters) {
 			ret.timeType = asn1.TagGeneralizedTime
 		case part == "utc":
 			ret.timeType = asn1.TagUTCTime
+		case part == "autotime":
+			ret.timeType = timeTypeAuto
+		case strings.HasPrefix(part, "timelayout:"):
+			ret.timeType = asn1.TagGeneralizedTime
+			ret.timeLayout = part[len("timelayout:"):]
+		case part == "date":
+			ret.timeType = tagDate
+		case part == "timeofday":
+			ret.timeType = tagTimeOfDay
+		case part == "datetime":
+			ret.timeType = tagDateTime
+		case part == "relative":
+			ret.relative = true
+		case part == "enumerated":
+			ret.enumerated = true
+		case part == "bits":
+			ret.bits = true
 		case part == "ia5":
 			ret.stringType = asn1.TagIA5String
 		case part == "printable":
 			ret.stringType = asn1.TagPrintableString
+		case part == "autostring":
+			// The choice a string is given without a type.
+			ret.stringType = 0
 		case part == "numeric":
 			ret.stringType = asn1.TagNumericString
 		case part == "utf8":
 			ret.stringType = asn1.TagUTF8String
+		case part == "bmp":
+			ret.stringType = asn1.TagBMPString
+		case part == "universal":
+			ret.stringType = tagUniversalString
+		case part == "visible":
+			ret.stringType = tagVisibleString
+		case part == "general":
+			ret.stringType = asn1.TagGeneralString
+		case part == "t61":
+			ret.stringType = asn1.TagT61String
 		case strings.HasPrefix(part, "default:"):
 			i, err := strconv.ParseInt(part[8:], 10, 64)
 			if err == nil {
 				ret.defaultValue = new(int64)
 				*ret.defaultValue = i
 			}
+		case strings.HasPrefix(part, "explicit:"):
+			var tags []int
+			for _, s := range strings.Split(part[9:], ":") {
+				i, err := strconv.Atoi(s)
+				if err != nil {
+					tags = nil
+					break
+				}
+				tags = append(tags, i)
+			}
+			if len(tags) > 0 {
+				ret.explicit = true
+				ret.tag = &tags[len(tags)-1]
+				ret.outerTags = tags[:len(tags)-1]
+			}
 		case strings.HasPrefix(part, "tag:"):
 			i, err := strconv.Atoi(part[4:])
 			if err == nil {
@@ -101,37 +192,418 @@ func parseFieldParameters(str string) (ret fieldParameters) {
 			if ret.tag == nil {
 				ret.tag = new(int)
 			}
+		case part == "context":
+			ret.setClass(asn1.ClassContextSpecific)
+		case strings.HasPrefix(part, "class:"):
+			i, err := strconv.Atoi(part[6:])
+			if err == nil && i >= asn1.ClassUniversal && i <= asn1.ClassPrivate {
+				ret.setClass(i)
+			}
 		case part == "omitempty":
 			ret.omitEmpty = true
+		case part == "choice":
+			ret.choice = true
+		case part == "any":
+			ret.unmatched = true
+		case part == "tail":
+			ret.tail = true
+		case strings.HasPrefix(part, "min:"):
+			i, err := strconv.ParseInt(part[4:], 10, 64)
+			if err == nil {
+				ret.min = new(int64)
+				*ret.min = i
+			}
+		case strings.HasPrefix(part, "max:"):
+			i, err := strconv.ParseInt(part[4:], 10, 64)
+			if err == nil {
+				ret.max = new(int64)
+				*ret.max = i
+			}
+		case strings.HasPrefix(part, "precision:"):
+			i, err := strconv.Atoi(part[10:])
+			if err == nil && i >= 0 {
+				ret.precision = new(int)
+				*ret.precision = i
+			}
+		case strings.HasPrefix(part, "frac:"):
+			i, err := strconv.Atoi(part[5:])
+			if err == nil && i >= 0 && i <= 9 {
+				ret.frac = new(int)
+				*ret.frac = i
+			}
+		case strings.HasPrefix(part, "bit:"):
+			i, err := strconv.Atoi(part[4:])
+			if err == nil {
+				ret.bit = new(int)
+				*ret.bit = i
+			}
+		case strings.HasPrefix(part, "minsize:"):
+			i, err := strconv.Atoi(part[8:])
+			if err == nil {
+				ret.minSize = new(int)
+				*ret.minSize = i
+			}
+		case strings.HasPrefix(part, "maxsize:"):
+			i, err := strconv.Atoi(part[8:])
+			if err == nil {
+				ret.maxSize = new(int)
+				*ret.maxSize = i
+			}
+		}
+	}
+	return
+}
+
+// structFields returns the indexes, as taken by FieldByIndex, of the fields of
+// the struct type t which are the elements of its SEQUENCE, in order. The
+// fields of an untagged embedded struct are spliced in place of it, so that a
+// common set of elements can be shared by embedding. Skipped fields are left
+// out.
+func structFields(t reflect.Type) (fields [][]int, err error) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type == extensionMarkerType {
+			// Kept for getStructInfo, whatever its name.
+			fields = append(fields, []int{i})
+			continue
 		}
+		if isSkippedField(field) {
+			continue
+		}
+		if isEmbeddedSequence(field) {
+			inner, err := structFields(field.Type)
+			if err != nil {
+				return nil, err
+			}
+			for _, index := range inner {
+				fields = append(fields, append([]int{i}, index...))
+			}
+			continue
+		}
+		if !field.IsExported() {
+			return nil, asn1.StructuralError{Msg: "struct contains unexported fields"}
+		}
+		fields = append(fields, []int{i})
 	}
 	return
 }
 
+// compareTags compares two tags in the canonical order of X.680 section 8.6,
+// by class, UNIVERSAL first and PRIVATE last, and then by number. The result
+// is negative if a comes first, positive if b does and zero if they are the
+// same.
+func compareTags(a, b tagAndLength) int {
+	switch {
+	case a.class != b.class:
+		return a.class - b.class
+	case a.tag < b.tag:
+		return -1
+	case a.tag > b.tag:
+		return 1
+	}
+	return 0
+}
+
+// isSkippedField reports whether field takes no part in marshaling or
+// unmarshaling: its tag is "-", or it is a blank field named "_", which need
+// not be exported.
+func isSkippedField(field reflect.StructField) bool {
+	return field.Name == "_" || field.Tag.Get("asn1") == "-"
+}
+
+// checkChoice returns an error if the struct type t can't be a CHOICE: each of
+// its alternatives must be exported, and a pointer, slice or interface so that
+// whether it is set doesn't depend on the value it holds.
+func checkChoice(t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if isSkippedField(field) {
+			continue
+		}
+		if !field.IsExported() {
+			return asn1.StructuralError{Msg: "struct contains unexported fields"}
+		}
+		switch field.Type.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Interface:
+		default:
+			return asn1.StructuralError{Msg: "CHOICE alternative " + field.Name + " is not a pointer, slice or interface"}
+		}
+	}
+	return nil
+}
+
+// A sequenceField is a field of a struct type which is an element of its
+// SEQUENCE, with its index as taken by FieldByIndex and its parsed tag.
+type sequenceField struct {
+	index  []int
+	typ    reflect.Type
+	params fieldParameters
+
+	// extension is set for the fields after an ExtensionMarker.
+	extension bool
+}
+
+// structInfo is what marshaling and unmarshaling need to know about a struct
+// type, worked out once by getStructInfo rather than for every value.
+type structInfo struct {
+	namedBits   bool            // whether the fields are named bits
+	fields      []sequenceField // the elements, unless namedBits is set
+	rawContents bool            // whether the first element is a RawContent
+	unmatched   bool            // whether the last field takes the elements left over
+	tail        bool            // whether the last field takes the contents left over
+	err         error           // why the type can't be a SEQUENCE, if it can't
+}
+
+// structInfoCache maps a struct reflect.Type to its *structInfo.
+var structInfoCache sync.Map
+
+// getStructInfo returns the structInfo of the struct type t. The parameters of
+// its fields are shared by every caller, so mustn't be changed through their
+// pointers.
+func getStructInfo(t reflect.Type) *structInfo {
+	if info, ok := structInfoCache.Load(t); ok {
+		return info.(*structInfo)
+	}
+
+	info := &structInfo{namedBits: t.NumField() > 0 && parseFieldParameters(t.Field(0).Tag.Get("asn1")).bit != nil}
+	if !info.namedBits {
+		var indexes [][]int
+		indexes, info.err = structFields(t)
+		extension := false
+		for _, index := range indexes {
+			field := t.FieldByIndex(index)
+			if field.Type == extensionMarkerType {
+				extension = true
+				continue
+			}
+			info.fields = append(info.fields, sequenceField{index, field.Type, structFieldParameters(field), extension})
+		}
+		info.rawContents = len(info.fields) > 0 && len(info.fields[0].index) == 1 &&
+			info.fields[0].typ == rawContentsType
+		for i, f := range info.fields {
+			switch {
+			case !f.params.unmatched || info.err != nil:
+			case f.typ != rawValueSliceType:
+				info.err = f.params.structuralError("any given to non-[]asn1.RawValue member")
+			case i != len(info.fields)-1:
+				info.err = f.params.structuralError("any given to member other than the last")
+			default:
+				info.unmatched = true
+			}
+			switch {
+			case !f.params.tail || info.err != nil:
+			case f.typ != rawTailType:
+				info.err = f.params.structuralError("tail given to non-RawTail member")
+			case i != len(info.fields)-1:
+				info.err = f.params.structuralError("tail given to member other than the last")
+			default:
+				info.tail = true
+			}
+		}
+	}
+	// Another goroutine may have got there first, in which case its
+	// result, which is the same, is used.
+	actual, _ := structInfoCache.LoadOrStore(t, info)
+	return actual.(*structInfo)
+}
+
+// isEmbeddedSequence reports whether field is an embedded struct, without a
+// tag, which is encoded as a SEQUENCE rather than by a Marshaler or as one of
+// the other types represented by a struct, such as time.Time.
+func isEmbeddedSequence(field reflect.StructField) bool {
+	if !field.Anonymous || field.Type.Kind() != reflect.Struct || field.Tag.Get("asn1") != "" {
+		return false
+	}
+	if field.Type.Implements(marshalerType) || reflect.PointerTo(field.Type).Implements(unmarshalerType) {
+		return false
+	}
+	_, tag, _, ok := getUniversalType(field.Type)
+	return ok && tag == asn1.TagSequence
+}
+
+// structFieldParameters parses the tag string of the given struct field and
+// records the field's name so that errors can refer to it.
+func structFieldParameters(field reflect.StructField) fieldParameters {
+	ret := parseFieldParameters(field.Tag.Get("asn1"))
+	ret.name = field.Name
+	return ret
+}
+
+// setClass sets the class of the tag given in the parameters.
+func (p *fieldParameters) setClass(class int) {
+	p.universal = class == asn1.ClassUniversal
+	p.application = class == asn1.ClassApplication
+	p.private = class == asn1.ClassPrivate
+	if p.tag == nil {
+		p.tag = new(int)
+	}
+}
+
+// tagClass returns the class of the tag given in the parameters, which is
+// CONTEXT SPECIFIC unless another class was requested.
+func (p fieldParameters) tagClass() int {
+	switch {
+	case p.application:
+		return asn1.ClassApplication
+	case p.private:
+		return asn1.ClassPrivate
+	case p.universal:
+		return asn1.ClassUniversal
+	}
+	return asn1.ClassContextSpecific
+}
+
+// structuralError returns a StructuralError with the given message, naming the
+// field being processed if it is known.
+func (p fieldParameters) structuralError(msg string) error {
+	if p.name != "" {
+		msg += " in field " + p.name
+	}
+	return asn1.StructuralError{Msg: msg}
+}
+
+// checkConstraints checks v against the SIZE and value range constraints given
+// in the parameters.
+func (p fieldParameters) checkConstraints(v reflect.Value) error {
+	if err := p.checkSize(v); err != nil {
+		return err
+	}
+	return p.checkRange(v)
+}
+
+// checkSize checks the size of v against the SIZE constraint given in the
+// parameters. The size of a string is its number of characters and that of a
+// slice its number of elements; other values are not constrained.
+func (p fieldParameters) checkSize(v reflect.Value) error {
+	if p.minSize == nil && p.maxSize == nil {
+		return nil
+	}
+
+	var size int
+	switch v.Kind() {
+	case reflect.String:
+		size = utf8.RuneCountInString(v.String())
+	case reflect.Slice:
+		size = v.Len()
+	default:
+		return nil
+	}
+
+	if p.minSize != nil && size < *p.minSize {
+		return p.structuralError(fmt.Sprintf("size %d is less than minsize:%d", size, *p.minSize))
+	}
+	if p.maxSize != nil && size > *p.maxSize {
+		return p.structuralError(fmt.Sprintf("size %d is greater than maxsize:%d", size, *p.maxSize))
+	}
+	return nil
+}
+
+// checkRange checks the value of v, if it is an INTEGER, against the range
+// constraint given in the parameters.
+func (p fieldParameters) checkRange(v reflect.Value) error {
+	if p.min == nil && p.max == nil {
+		return nil
+	}
+
+	var n *big.Int
+	switch {
+	case v.Type() == bigIntType:
+		if v.IsNil() {
+			return nil
+		}
+		n = v.Interface().(*big.Int)
+	case v.Type() == durationType:
+		return nil
+	case v.CanInt():
+		n = big.NewInt(v.Int())
+	case v.CanUint():
+		n = new(big.Int).SetUint64(v.Uint())
+	default:
+		return nil
+	}
+
+	if p.min != nil && n.Cmp(big.NewInt(*p.min)) < 0 {
+		return p.structuralError(fmt.Sprintf("value %v is less than min:%d", n, *p.min))
+	}
+	if p.max != nil && n.Cmp(big.NewInt(*p.max)) > 0 {
+		return p.structuralError(fmt.Sprintf("value %v is greater than max:%d", n, *p.max))
+	}
+	return nil
+}
+
+// mapEntryType returns the type of the SEQUENCE { key, value } which each
+// entry of a map of type t is encoded as.
+func mapEntryType(t reflect.Type) reflect.Type {
+	return reflect.StructOf([]reflect.StructField{
+		{Name: "Key", Type: t.Key()},
+		{Name: "Value", Type: t.Elem()},
+	})
+}
+
+// isIntegerKind reports whether k is one of the signed or unsigned integer
+// kinds.
+func isIntegerKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
 // Given a reflected Go type, getUniversalType returns the default tag number
 // and expected compound flag.
 func getUniversalType(t reflect.Type) (matchAny bool, tagNumber int, isCompound, ok bool) {
 	switch t {
 	case rawValueType:
 		return true, -1, false, true
-	case objectIdentifierType:
+	case objectIdentifierType, bigOIDType:
 		return false, asn1.TagOID, false, true
+	case relativeOIDType:
+		return false, tagRelativeOID, false, true
 	case bitStringType:
 		return false, asn1.TagBitString, false, true
 	case timeType:
 		return false, asn1.TagUTCTime, false, true
+	case kerberosTimeType:
+		return false, asn1.TagGeneralizedTime, false, true
 	case enumeratedType:
 		return false, asn1.TagEnum, false, true
 	case bigIntType:
 		return false, asn1.TagInteger, false, true
+	case ratType:
+		return false, tagReal, false, true
+	case ipType, uuidType, ipAddressType, opaqueType, streamOctetStringType:
+		return false, asn1.TagOctetString, false, true
+	case counter32Type, gauge32Type, timeTicksType, counter64Type:
+		return false, asn1.TagInteger, false, true
+	case isoDurationType:
+		return false, tagDuration, false, true
+	case durationType:
+		return false, asn1.TagInteger, false, true
 	}
 	switch t.Kind() {
 	case reflect.Bool:
 		return false, asn1.TagBoolean, false, true
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return false, asn1.TagInteger, false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return false, asn1.TagInteger, false, true
+	case reflect.Float32, reflect.Float64:
+		return false, tagReal, false, true
 	case reflect.Struct:
+		if isNamedBits(t) {
+			return false, asn1.TagBitString, false, true
+		}
 		return false, asn1.TagSequence, true, true
+	case reflect.Map:
+		return false, asn1.TagSet, true, true
+	case reflect.Pointer:
+		return getUniversalType(t.Elem())
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return false, asn1.TagOctetString, false, true
+		}
 	case reflect.Slice:
 		if t.Elem().Kind() == reflect.Uint8 {
 			return false, asn1.TagOctetString, false, true
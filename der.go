@@ -0,0 +1,174 @@
+package ber
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"slices"
+)
+
+// ToDER converts the BER encoding of one element to DER. Lengths are made
+// definite and minimal, constructed strings are joined into primitive ones,
+// BOOLEAN values are made 0x00 or 0xff, INTEGER and ENUMERATED values are
+// made minimal and the elements of a SET are sorted.
+//
+// Only elements with universal tags can be interpreted: the contents of an
+// implicitly tagged string, say, can't be told from a SEQUENCE and are kept
+// as they are. An error is returned, rather than guessing, if the input can't
+// be mapped to a single DER encoding, such as when the elements of a SET have
+// distinct tags but sorting them as a SET OF would disagree with sorting
+// them by tag as a SET requires.
+func ToDER(ber []byte) ([]byte, error) {
+	v, rest, err := DecodeValue(ber)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, SyntaxError{Msg: "trailing data", Offset: len(ber) - len(rest)}
+	}
+	if v, err = toDER(v); err != nil {
+		return nil, err
+	}
+	return EncodeValue(v)
+}
+
+// toDER returns v with its contents, and those of the elements within it,
+// rewritten as DER requires.
+func toDER(v Value) (ret Value, err error) {
+	v.Indefinite = false
+	if v.Compound {
+		children := make([]Value, len(v.Children))
+		for i, child := range v.Children {
+			if children[i], err = toDER(child); err != nil {
+				return Value{}, err
+			}
+		}
+		v.Children = children
+	}
+	if v.Class != asn1.ClassUniversal {
+		return v, nil
+	}
+
+	switch v.Tag {
+	case asn1.TagBoolean:
+		if v.Compound || len(v.Bytes) != 1 {
+			return Value{}, asn1.StructuralError{Msg: "invalid BOOLEAN"}
+		}
+		if v.Bytes[0] != 0 {
+			v.Bytes = []byte{0xff}
+		}
+	case asn1.TagInteger, asn1.TagEnum:
+		if v.Compound || len(v.Bytes) == 0 {
+			return Value{}, asn1.StructuralError{Msg: "invalid INTEGER"}
+		}
+		v.Bytes = minimalInteger(v.Bytes)
+	case asn1.TagNull:
+		if v.Compound || len(v.Bytes) != 0 {
+			return Value{}, asn1.StructuralError{Msg: "invalid NULL"}
+		}
+	case asn1.TagOID, tagRelativeOID, tagReal:
+		if v.Compound {
+			return Value{}, asn1.StructuralError{Msg: "constructed " + universalTagName[v.Tag]}
+		}
+	case asn1.TagBitString:
+		// The segments are joined, and the unused bits cleared.
+		var bs asn1.BitString
+		if v.Compound {
+			var contents []byte
+			if contents, err = v.contents(); err != nil {
+				return Value{}, err
+			}
+			bs, err = parseConstructedBitString(contents, defaultMaxDepth, 0)
+		} else {
+			bs, err = parseLenientBitString(v.Bytes)
+		}
+		if err != nil {
+			return Value{}, err
+		}
+		b := make([]byte, bitStringEncoder(bs).Len())
+		bitStringEncoder(bs).Encode(b)
+		v = Value{Class: v.Class, Tag: v.Tag, Bytes: b}
+	case asn1.TagOctetString, asn1.TagPrintableString, asn1.TagIA5String, asn1.TagGeneralString, asn1.TagT61String, asn1.TagUTF8String, asn1.TagNumericString, asn1.TagBMPString, tagUniversalString, tagVisibleString:
+		if !v.Compound {
+			break
+		}
+		contents, err := v.contents()
+		if err != nil {
+			return Value{}, err
+		}
+		b, err := appendConstructedString(nil, contents, asn1.TagOctetString, defaultMaxDepth, 0)
+		if err != nil {
+			return Value{}, err
+		}
+		v = Value{Class: v.Class, Tag: v.Tag, Bytes: b}
+	case asn1.TagSet:
+		if !v.Compound {
+			return Value{}, asn1.StructuralError{Msg: "primitive SET"}
+		}
+		if err := sortSet(v.Children); err != nil {
+			return Value{}, err
+		}
+	}
+	return v, nil
+}
+
+// contents returns the encodings of the elements within the constructed
+// Value v.
+func (v Value) contents() ([]byte, error) {
+	var b []byte
+	for _, child := range v.Children {
+		enc, err := EncodeValue(child)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, enc...)
+	}
+	return b, nil
+}
+
+// sortSet sorts the elements of a SET, already in DER, by their encodings as
+// X.690 section 11.6 requires for a SET OF. If no two elements have the same
+// tag they may be those of a SET instead, which are sorted by tag, so the
+// two orders have to agree.
+func sortSet(elements []Value) error {
+	type encoded struct {
+		v   Value
+		enc []byte
+	}
+	sorted := make([]encoded, len(elements))
+	for i, v := range elements {
+		enc, err := EncodeValue(v)
+		if err != nil {
+			return err
+		}
+		sorted[i] = encoded{v, enc}
+	}
+	slices.SortStableFunc(sorted, func(a, b encoded) int {
+		return bytes.Compare(a.enc, b.enc)
+	})
+
+	byTag := true
+	for i := 1; i < len(sorted) && byTag; i++ {
+		a, b := sorted[i-1].v, sorted[i].v
+		byTag = a.Class < b.Class || a.Class == b.Class && a.Tag < b.Tag
+	}
+	if !byTag && distinctTags(elements) {
+		return asn1.StructuralError{Msg: "ambiguous order of SET elements"}
+	}
+	for i := range sorted {
+		elements[i] = sorted[i].v
+	}
+	return nil
+}
+
+// distinctTags reports whether no two of the elements have the same tag.
+func distinctTags(elements []Value) bool {
+	seen := make(map[[2]int]bool, len(elements))
+	for _, v := range elements {
+		tag := [2]int{v.Class, v.Tag}
+		if seen[tag] {
+			return false
+		}
+		seen[tag] = true
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package ber
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+var toDERTests = []struct {
+	in, out string
+}{
+	// Indefinite lengths are made definite.
+	{"3080" + "020105" + "a0800101ff0000" + "0000", "3008" + "020105" + "a0030101ff"},
+	// As are non-minimal ones.
+	{"3081030201ff", "30030201ff"},
+	// Constructed strings are joined, to any depth.
+	{"2480" + "04026162" + "2405" + "040163" + "0400" + "0000", "0403616263"},
+	{"2c80" + "04026869" + "0000", "0c026869"},
+	{"2308" + "0302006a" + "03020780", "0303076a80"},
+	// Unused bits are cleared.
+	{"030207ff", "03020780"},
+	// BOOLEAN, INTEGER and ENUMERATED values are normalized.
+	{"010101", "0101ff"},
+	{"010100", "010100"},
+	{"02020005", "020105"},
+	{"0203ffff80", "020180"},
+	{"0a020080", "0a020080"},
+	// The elements of a SET OF are sorted.
+	{"310b" + "020103" + "020101" + "0203010000", "310b" + "020101" + "020103" + "0203010000"},
+	// and the distinctly tagged elements of a SET, when that sorts them by
+	// tag too.
+	{"3106" + "810101" + "800100", "3106" + "800100" + "810101"},
+	// Implicitly tagged values are kept as they are.
+	{"3005" + "8003000005", "3005" + "8003000005"},
+}
+
+func TestToDER(t *testing.T) {
+	for i, test := range toDERTests {
+		in, _ := hex.DecodeString(test.in)
+		out, err := ToDER(in)
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if got := hex.EncodeToString(out); got != test.out {
+			t.Errorf("#%d: got %s want %s", i, got, test.out)
+		}
+		// The result must be DER already.
+		if again, err := ToDER(out); err != nil || hex.EncodeToString(again) != test.out {
+			t.Errorf("#%d: converting again gave %x, %v", i, again, err)
+		}
+	}
+}
+
+func TestToDERInvalid(t *testing.T) {
+	tests := []string{
+		"3080020105",                     // missing end-of-contents
+		"0500" + "0500",                  // trailing data
+		"0100",                           // empty BOOLEAN
+		"0200",                           // empty INTEGER
+		"2203020105",                     // constructed INTEGER
+		"050100",                         // NULL with contents
+		"2404" + "0302006a",              // OCTET STRING with a BIT STRING segment
+		"2308" + "03020780" + "03020080", // unused bits in a non-final segment
+		// [1] constructed sorts after [2] primitive by encoding, but
+		// before it by tag.
+		"3108" + "a1030101ff" + "820100",
+	}
+	for i, test := range tests {
+		in, _ := hex.DecodeString(test)
+		if out, err := ToDER(in); err == nil {
+			t.Errorf("#%d: got %x, want error", i, out)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"strings"
+)
+
+// dumpPreviewLength is the number of contents octets of a primitive element
+// shown by Dump.
+const dumpPreviewLength = 16
+
+var className = [...]string{
+	asn1.ClassUniversal:       "UNIVERSAL",
+	asn1.ClassApplication:     "APPLICATION",
+	asn1.ClassContextSpecific: "CONTEXT",
+	asn1.ClassPrivate:         "PRIVATE",
+}
+
+var universalTagName = map[int]string{
+	0:                       "END-OF-CONTENTS",
+	asn1.TagBoolean:         "BOOLEAN",
+	asn1.TagInteger:         "INTEGER",
+	asn1.TagBitString:       "BIT STRING",
+	asn1.TagOctetString:     "OCTET STRING",
+	asn1.TagNull:            "NULL",
+	asn1.TagOID:             "OBJECT IDENTIFIER",
+	7:                       "ObjectDescriptor",
+	8:                       "EXTERNAL",
+	tagReal:                 "REAL",
+	asn1.TagEnum:            "ENUMERATED",
+	11:                      "EMBEDDED PDV",
+	asn1.TagUTF8String:      "UTF8String",
+	tagRelativeOID:          "RELATIVE-OID",
+	asn1.TagSequence:        "SEQUENCE",
+	asn1.TagSet:             "SET",
+	asn1.TagNumericString:   "NumericString",
+	asn1.TagPrintableString: "PrintableString",
+	asn1.TagT61String:       "T61String",
+	21:                      "VideotexString",
+	asn1.TagIA5String:       "IA5String",
+	asn1.TagUTCTime:         "UTCTime",
+	asn1.TagGeneralizedTime: "GeneralizedTime",
+	25:                      "GraphicString",
+	tagVisibleString:        "VisibleString",
+	asn1.TagGeneralString:   "GeneralString",
+	tagUniversalString:      "UniversalString",
+	asn1.TagBMPString:       "BMPString",
+	tagDate:                 "DATE",
+	tagTimeOfDay:            "TIME-OF-DAY",
+	tagDateTime:             "DATE-TIME",
+	tagDuration:             "DURATION",
+}
+
+// Dump returns a description of the BER encoded elements in b, one line per
+// element indented by its depth. Each line gives the class, tag number and
+// form of the element and its length; the name of a UNIVERSAL tag follows its
+// number, and the first contents octets of a primitive element are shown in
+// hex and ASCII. An OBJECT IDENTIFIER registered with RegisterOID is shown by
+// its name and dotted form instead.
+//
+// If b is not well formed, or its elements are nested more deeply than
+// Unmarshal allows by default, Dump returns the description of the elements
+// before the error together with a SyntaxError or StructuralError giving the
+// offset of the element which could not be read.
+func Dump(b []byte) (string, error) {
+	var sb strings.Builder
+	err := dumpElements(&sb, b, 0, 0, defaultMaxDepth)
+	return sb.String(), err
+}
+
+// dumpElements writes the description of the elements in b, which starts at
+// base in the input and is depth levels deep, to sb. The elements may be
+// nested to maxDepth levels.
+func dumpElements(sb *strings.Builder, b []byte, base, depth, maxDepth int) error {
+	for offset := 0; offset < len(b); {
+		if maxDepth <= 0 {
+			return decoder{base: base}.locate(errNestingTooDeep, offset)
+		}
+		t, next, err := parseTagAndLengthDepth(b, offset, maxDepth)
+		if err != nil {
+			return decoder{base: base}.locate(err, offset)
+		}
+		if invalidLength(next, t.length, len(b)) {
+			return SyntaxError{Msg: "data truncated", Offset: base + offset, truncated: true}
+		}
+
+		sb.WriteString(strings.Repeat("  ", depth))
+		fmt.Fprintf(sb, "%s %d", className[t.class], t.tag)
+		if name, ok := universalTagName[t.tag]; ok && t.class == asn1.ClassUniversal {
+			fmt.Fprintf(sb, " %s", name)
+		}
+		contents := b[next : next+t.length]
+		if t.isCompound {
+			sb.WriteString(" constructed")
+		} else {
+			sb.WriteString(" primitive")
+		}
+		if t.isIndefinite {
+			sb.WriteString(" len=indefinite")
+		} else {
+			fmt.Fprintf(sb, " len=%d", t.length)
+		}
+		if name, oid, ok := oidName(t, contents); ok {
+			fmt.Fprintf(sb, " %s (%s)", name, oid)
+		} else if !t.isCompound && len(contents) > 0 {
+			sb.WriteByte(' ')
+			writePreview(sb, contents)
+		}
+		sb.WriteByte('\n')
+
+		if t.isCompound {
+			if err := dumpElements(sb, contents, base+next, depth+1, maxDepth-1); err != nil {
+				return err
+			}
+		}
+		offset = next + t.length
+		if t.isIndefinite {
+			offset += 2
+		}
+	}
+	return nil
+}
+
+// oidName returns the registered name of the element with the given header
+// and contents, if it is an OBJECT IDENTIFIER which has one.
+func oidName(t tagAndLength, contents []byte) (name string, oid asn1.ObjectIdentifier, ok bool) {
+	if t.class != asn1.ClassUniversal || t.tag != asn1.TagOID || t.isCompound {
+		return "", nil, false
+	}
+	oid, err := parseObjectIdentifier(contents)
+	if err != nil {
+		return "", nil, false
+	}
+	name, ok = LookupOID(oid)
+	return name, oid, ok
+}
+
+// writePreview writes the first octets of contents to sb in hex and then as
+// ASCII, with unprintable octets shown as dots.
+func writePreview(sb *strings.Builder, contents []byte) {
+	preview := contents[:min(len(contents), dumpPreviewLength)]
+	fmt.Fprintf(sb, "% x", preview)
+	if len(preview) < len(contents) {
+		sb.WriteString(" ...")
+	}
+	sb.WriteString(" |")
+	for _, c := range preview {
+		if c < 0x20 || c > 0x7e {
+			c = '.'
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteByte('|')
+}
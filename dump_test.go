@@ -0,0 +1,98 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+)
+
+// dumpInput is a SEQUENCE of part of a certificate: a version, the signature
+// algorithm and the issuer, with an indefinite length.
+const dumpInput = "3080" +
+	"a003020102" +
+	"300d06092a864886f70d01010b0500" +
+	"310e300c06035504030c0568656c6c6f" +
+	"0000"
+
+const dumpGolden = `UNIVERSAL 16 SEQUENCE constructed len=indefinite
+  CONTEXT 0 constructed len=3
+    UNIVERSAL 2 INTEGER primitive len=1 02 |.|
+  UNIVERSAL 16 SEQUENCE constructed len=13
+    UNIVERSAL 6 OBJECT IDENTIFIER primitive len=9 sha256WithRSAEncryption (1.2.840.113549.1.1.11)
+    UNIVERSAL 5 NULL primitive len=0
+  UNIVERSAL 17 SET constructed len=14
+    UNIVERSAL 16 SEQUENCE constructed len=12
+      UNIVERSAL 6 OBJECT IDENTIFIER primitive len=3 commonName (2.5.4.3)
+      UNIVERSAL 12 UTF8String primitive len=5 68 65 6c 6c 6f |hello|
+`
+
+func TestDump(t *testing.T) {
+	in, _ := hex.DecodeString(dumpInput)
+	got, err := Dump(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != dumpGolden {
+		t.Errorf("got:\n%s\nwant:\n%s", got, dumpGolden)
+	}
+
+	// Long contents are cut short, and tags of any number are shown.
+	in, _ = hex.DecodeString("3019" + "0414000102030405060708090a0b0c0d0e0f10111213" + "9f2000")
+	want := "UNIVERSAL 16 SEQUENCE constructed len=25\n" +
+		"  UNIVERSAL 4 OCTET STRING primitive len=20 00 01 02 03 04 05 06 07 08 09 0a 0b 0c 0d 0e 0f ... |................|\n" +
+		"  CONTEXT 32 primitive len=0\n"
+	got, err = Dump(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDumpRegisteredOID(t *testing.T) {
+	RegisterOID(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 3}, "dumpExample")
+	// The registered OID and then one which isn't.
+	in, _ := hex.DecodeString("3013" + "06092b0601040183b20303" + "060629b2030103ff")
+	want := "UNIVERSAL 16 SEQUENCE constructed len=19\n" +
+		"  UNIVERSAL 6 OBJECT IDENTIFIER primitive len=9 dumpExample (1.3.6.1.4.1.55555.3)\n" +
+		"  UNIVERSAL 6 OBJECT IDENTIFIER primitive len=6 29 b2 03 01 03 ff |).....|\n"
+	got, err := Dump(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDumpTruncated(t *testing.T) {
+	in, _ := hex.DecodeString("30060201010403616263")
+	got, err := Dump(in)
+	want := "UNIVERSAL 16 SEQUENCE constructed len=6\n" +
+		"  UNIVERSAL 2 INTEGER primitive len=1 01 |.|\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	var se SyntaxError
+	if !errors.As(err, &se) || se.Offset != 5 {
+		t.Errorf("got %v, want SyntaxError at offset 5", err)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestDumpNestingTooDeep(t *testing.T) {
+	for _, indefinite := range []bool{false, true} {
+		if _, err := Dump(nestedSeqs(defaultMaxDepth, indefinite)); err != nil {
+			t.Errorf("indefinite %v: %v", indefinite, err)
+		}
+		_, err := Dump(nestedSeqs(defaultMaxDepth+1, indefinite))
+		if se, ok := err.(StructuralError); !ok || se.Msg != errNestingTooDeep.Msg {
+			t.Errorf("indefinite %v: got %v, want %v", indefinite, err, errNestingTooDeep)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Duration is an ASN.1 DURATION, an ISO 8601 duration such as
+// P1Y2M10DT2H30M. The years, months and days are kept apart from the time of
+// day components as their length varies; no component may be negative.
+type Duration struct {
+	Years, Months, Days int
+	Time                time.Duration // hours, minutes and seconds
+}
+
+// String returns d in the ISO 8601 form in which it is marshaled. Only its
+// non-zero components are given, and a fraction of a second is written
+// without trailing zeros. A zero Duration is PT0S.
+func (d Duration) String() string {
+	b := []byte{'P'}
+	for _, c := range []struct {
+		n    int
+		unit byte
+	}{{d.Years, 'Y'}, {d.Months, 'M'}, {d.Days, 'D'}} {
+		if c.n != 0 {
+			b = strconv.AppendInt(b, int64(c.n), 10)
+			b = append(b, c.unit)
+		}
+	}
+	if d.Time == 0 && len(b) > 1 {
+		return string(b)
+	}
+
+	b = append(b, 'T')
+	t := d.Time
+	if h := t / time.Hour; h != 0 {
+		b = strconv.AppendInt(b, int64(h), 10)
+		b = append(b, 'H')
+		t -= h * time.Hour
+	}
+	if m := t / time.Minute; m != 0 {
+		b = strconv.AppendInt(b, int64(m), 10)
+		b = append(b, 'M')
+		t -= m * time.Minute
+	}
+	if t != 0 || d.Time == 0 {
+		b = strconv.AppendInt(b, int64(t/time.Second), 10)
+		if ns := t % time.Second; ns != 0 {
+			frac := strconv.AppendInt(nil, int64(ns+time.Second), 10)
+			frac[0] = '.'
+			b = append(b, strings.TrimRight(string(frac), "0")...)
+		}
+		b = append(b, 'S')
+	}
+	return string(b)
+}
+
+func (d Duration) negative() bool {
+	return d.Years < 0 || d.Months < 0 || d.Days < 0 || d.Time < 0
+}
+
+// parseDuration parses an ISO 8601 duration. A number of weeks, PnW, is
+// taken as seven times as many days. Only the final component may have a
+// fraction, and then only if it is a number of hours, minutes or seconds.
+func parseDuration(bytes []byte) (ret Duration, err error) {
+	s := string(bytes)
+	rest, ok := strings.CutPrefix(s, "P")
+	if !ok || rest == "" || rest == "T" || strings.HasSuffix(rest, "T") {
+		return Duration{}, asn1.StructuralError{Msg: fmt.Sprintf("invalid DURATION %q", s)}
+	}
+
+	// units lists the components in the order they must appear.
+	units := "YMWD"
+	inTime := false
+	for rest != "" {
+		if rest[0] == 'T' && !inTime {
+			inTime = true
+			units = "HMS"
+			rest = rest[1:]
+			continue
+		}
+
+		i := 0
+		for i < len(rest) && '0' <= rest[i] && rest[i] <= '9' {
+			i++
+		}
+		whole := rest[:i]
+		hasFrac, frac := false, ""
+		if i < len(rest) && (rest[i] == '.' || rest[i] == ',') {
+			j := i + 1
+			for j < len(rest) && '0' <= rest[j] && rest[j] <= '9' {
+				j++
+			}
+			hasFrac, frac = true, rest[i+1:j]
+			i = j
+		}
+		component := rest[:min(i+1, len(rest))]
+		if whole == "" || hasFrac && frac == "" || i == len(rest) {
+			return Duration{}, invalidDuration(component)
+		}
+		unit := strings.IndexByte(units, rest[i])
+		if unit < 0 || hasFrac && (!inTime || i+1 != len(rest)) {
+			return Duration{}, invalidDuration(component)
+		}
+		n, err := strconv.ParseInt(whole, 10, 0)
+		if err != nil || units[unit] == 'W' && n > math.MaxInt/7 {
+			return Duration{}, invalidDuration(component)
+		}
+
+		if inTime {
+			scale := []time.Duration{time.Hour, time.Minute, time.Second}[unit+3-len(units)]
+			if n > math.MaxInt64/int64(scale) || ret.Time > math.MaxInt64-time.Duration(n)*scale {
+				return Duration{}, invalidDuration(component)
+			}
+			ret.Time += time.Duration(n) * scale
+			if hasFrac {
+				// The fraction is truncated to nanoseconds.
+				f, _ := strconv.Atoi((frac + "00000000")[:9])
+				ret.Time += time.Duration(f) * (scale / time.Second)
+			}
+		} else {
+			switch units[unit] {
+			case 'Y':
+				ret.Years = int(n)
+			case 'M':
+				ret.Months = int(n)
+			case 'W':
+				ret.Days = int(n) * 7
+			case 'D':
+				ret.Days += int(n)
+			}
+		}
+		// The following components must use the units after this one.
+		units = units[unit+1:]
+		rest = rest[i+1:]
+	}
+	return ret, nil
+}
+
+func invalidDuration(component string) error {
+	return asn1.StructuralError{Msg: fmt.Sprintf("invalid DURATION component %q", component)}
+}
@@ -0,0 +1,89 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+type durationValueStruct struct {
+	D Duration
+}
+
+func TestISODurationRoundTrip(t *testing.T) {
+	tests := []struct {
+		in  Duration
+		out string
+	}{
+		{Duration{Days: 10, Time: 2*time.Hour + 30*time.Minute}, "P10DT2H30M"},
+		{Duration{Years: 1, Months: 2}, "P1Y2M"},
+		{Duration{Years: 1, Months: 2, Days: 10, Time: 2*time.Hour + 30*time.Minute}, "P1Y2M10DT2H30M"},
+		{Duration{Time: 90*time.Second + 250*time.Millisecond}, "PT1M30.25S"},
+		{Duration{}, "PT0S"},
+	}
+	for _, test := range tests {
+		if s := test.in.String(); s != test.out {
+			t.Errorf("%+v: got %s want %s", test.in, s, test.out)
+		}
+		data, err := Marshal(durationValueStruct{test.in})
+		if err != nil {
+			t.Errorf("%s: %s", test.out, err)
+			continue
+		}
+		want := "30" + hex.EncodeToString([]byte{byte(len(test.out) + 3), 0x1f, 0x22, byte(len(test.out))}) + hex.EncodeToString([]byte(test.out))
+		if got := hex.EncodeToString(data); got != want {
+			t.Errorf("%s: got %s want %s", test.out, got, want)
+		}
+		var got durationValueStruct
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("%s: %s", test.out, err)
+		} else if got.D != test.in {
+			t.Errorf("%s: got %+v want %+v", test.out, got.D, test.in)
+		}
+	}
+
+	if _, err := Marshal(Duration{Days: -1}); err == nil {
+		t.Error("negative Duration marshaled")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in  string
+		out Duration
+		bad string // the component in the error, if any
+	}{
+		{"P2W", Duration{Days: 14}, ""},
+		{"PT36H", Duration{Time: 36 * time.Hour}, ""},
+		{"PT0,5H", Duration{Time: 30 * time.Minute}, ""},
+		{"PT1.0000000019S", Duration{Time: time.Second + 1}, ""},
+		{"P1M1D", Duration{Months: 1, Days: 1}, ""},
+		{"P", Duration{}, "P"},
+		{"PT", Duration{}, "PT"},
+		{"P1DT", Duration{}, "P1DT"},
+		{"P1D2Y", Duration{}, "2Y"},
+		{"P1X", Duration{}, "1X"},
+		{"PT1H2H", Duration{}, "2H"},
+		{"P1.5D", Duration{}, "1.5D"},
+		{"PT1.5M2S", Duration{}, "1.5M"},
+		{"PT.5S", Duration{}, ".5S"},
+		{"PT1.S", Duration{}, "1.S"},
+		{"P12", Duration{}, "12"},
+	}
+	for _, test := range tests {
+		got, err := parseDuration([]byte(test.in))
+		if test.bad != "" {
+			if _, ok := err.(asn1.StructuralError); !ok || !strings.Contains(err.Error(), `"`+test.bad+`"`) {
+				t.Errorf("%s: got %v, want error naming %q", test.in, err, test.bad)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err)
+		} else if got != test.out {
+			t.Errorf("%s: got %+v want %+v", test.in, got, test.out)
+		}
+	}
+}
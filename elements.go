@@ -0,0 +1,144 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"io"
+)
+
+// An ElementIter iterates over the BER encoded elements in a byte slice,
+// without decoding their contents.
+type ElementIter struct {
+	b      []byte
+	offset int
+	err    error
+}
+
+// Elements returns an iterator over the elements in b. The elements within a
+// constructed element can be iterated over with Elements(content).
+func Elements(b []byte) *ElementIter {
+	return &ElementIter{b: b}
+}
+
+// Next returns the next element: its class and tag number, whether it is
+// constructed, its contents octets and its complete encoding. The slices
+// refer to the input. The contents of an element with an indefinite length
+// exclude the end-of-contents octets, which its complete encoding includes.
+//
+// Next returns io.EOF at the end of the input or at end-of-contents octets,
+// as found at the end of the contents of an element with an indefinite
+// length. If the input is not well formed the error is a SyntaxError or
+// StructuralError giving the offset of the element in the input. Once Next
+// has returned an error it returns the same error from then on.
+func (it *ElementIter) Next() (class, tag int, compound bool, content, full []byte, err error) {
+	if it.err == nil && (it.offset == len(it.b) || it.b[it.offset] == 0x00 && it.offset+1 < len(it.b) && it.b[it.offset+1] == 0x00) {
+		it.err = io.EOF
+	}
+	if it.err != nil {
+		return 0, 0, false, nil, nil, it.err
+	}
+
+	t, next, err := parseTagAndLength(it.b, it.offset)
+	if err != nil {
+		it.err = decoder{}.locate(err, it.offset)
+		return 0, 0, false, nil, nil, it.err
+	}
+	if invalidLength(next, t.length, len(it.b)) {
+		it.err = SyntaxError{Msg: "data truncated", Offset: it.offset, truncated: true}
+		return 0, 0, false, nil, nil, it.err
+	}
+
+	end := next + t.length
+	if t.isIndefinite {
+		end += 2
+	}
+	content, full = it.b[next:next+t.length], it.b[it.offset:end]
+	it.offset = end
+	return t.class, t.tag, t.isCompound, content, full, nil
+}
+
+// ExplicitWrap returns inner, which should be a complete encoding, wrapped in
+// a constructed element of the given class and tag number with a minimal
+// definite length, as an explicit tag wraps the element it tags. ExplicitWrap
+// panics if class is not 0 to 3 or tag is negative.
+func ExplicitWrap(class, tag int, inner []byte) []byte {
+	if class < asn1.ClassUniversal || class > asn1.ClassPrivate || tag < 0 {
+		panic("ber: invalid class or tag given to ExplicitWrap")
+	}
+	t := tagAndLength{class: class, tag: tag, length: len(inner), isCompound: true}
+	var scratch [16]byte
+	header := appendTagAndLength(scratch[:0], t)
+	return append(append(make([]byte, 0, len(header)+len(inner)), header...), inner...)
+}
+
+// ExplicitUnwrap strips the identifier and length octets, and any
+// end-of-contents octets, from b, which must be exactly one constructed
+// element, returning its class, tag number and contents. The contents refer
+// to b.
+func ExplicitUnwrap(b []byte) (class, tag int, inner []byte, err error) {
+	if len(b) == 0 {
+		return 0, 0, nil, SyntaxError{Msg: "data truncated", truncated: true}
+	}
+	t, offset, err := parseTagAndLength(b, 0)
+	if err != nil {
+		return 0, 0, nil, truncation(b, decoder{}.locate(err, 0))
+	}
+	if invalidLength(offset, t.length, len(b)) {
+		return 0, 0, nil, SyntaxError{Msg: "data truncated", truncated: true}
+	}
+	if !t.isCompound {
+		return 0, 0, nil, StructuralError{Msg: "primitive element has no explicit tag to unwrap"}
+	}
+	end := offset + t.length
+	if t.isIndefinite {
+		end += 2
+	}
+	if end != len(b) {
+		return 0, 0, nil, SyntaxError{Msg: "trailing data", Offset: end}
+	}
+	return t.class, t.tag, b[offset : offset+t.length], nil
+}
+
+// GetElement returns the complete encoding of the child, numbered from 0 by
+// index, of the constructed element which b must be exactly, without
+// decoding it, so that it can be hashed or decoded on its own. The result
+// refers to b. It is an error for index to be out of range.
+func GetElement(b []byte, index int) ([]byte, error) {
+	it := Elements(b)
+	_, _, compound, _, _, err := it.Next()
+	if err != nil {
+		return nil, err
+	}
+	if it.offset != len(b) {
+		return nil, SyntaxError{Msg: "trailing data", Offset: it.offset}
+	}
+	if !compound {
+		return nil, StructuralError{Msg: "primitive element has no children"}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("asn1: element index %d out of range", index)
+	}
+
+	// The children are iterated over within b, so that the offsets of
+	// any errors are from its start.
+	t, offset, _ := parseTagAndLength(b, 0)
+	children := &ElementIter{b: b[:offset+t.length], offset: offset}
+	for i := 0; ; i++ {
+		_, _, _, _, full, err := children.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("asn1: element index %d out of range for %d elements", index, i)
+		}
+		if se, ok := err.(SyntaxError); ok {
+			// As b is complete, a child overrunning it is malformed
+			// rather than truncated.
+			se.truncated = false
+			err = se
+		}
+		if err != nil {
+			return nil, err
+		}
+		if i == index {
+			return full, nil
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package ber
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestElements(t *testing.T) {
+	// SEQUENCE { INTEGER 5, [0] { BOOLEAN TRUE }, OCTET STRING "ab" } and
+	// then NULL.
+	in, _ := hex.DecodeString("300c" + "020105" + "a0030101ff" + "04026162" + "0500")
+	it := Elements(in)
+	class, tag, compound, content, full, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class != asn1.ClassUniversal || tag != asn1.TagSequence || !compound || !bytes.Equal(full, in[:14]) || !bytes.Equal(content, in[2:14]) {
+		t.Errorf("got %d %d %t %x %x", class, tag, compound, content, full)
+	}
+
+	want := []struct {
+		class, tag int
+		compound   bool
+		content    string
+	}{
+		{asn1.ClassUniversal, asn1.TagInteger, false, "05"},
+		{asn1.ClassContextSpecific, 0, true, "0101ff"},
+		{asn1.ClassUniversal, asn1.TagOctetString, false, "6162"},
+	}
+	children := Elements(content)
+	for i, w := range want {
+		class, tag, compound, content, _, err := children.Next()
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		if class != w.class || tag != w.tag || compound != w.compound || hex.EncodeToString(content) != w.content {
+			t.Errorf("#%d: got %d %d %t %x", i, class, tag, compound, content)
+		}
+	}
+	if _, _, _, _, _, err := children.Next(); err != io.EOF {
+		t.Errorf("got %v after the last child, want io.EOF", err)
+	}
+
+	if _, tag, _, _, _, err = it.Next(); err != nil || tag != asn1.TagNull {
+		t.Errorf("got tag %d, %v want NULL", tag, err)
+	}
+	if _, _, _, _, _, err := it.Next(); err != io.EOF {
+		t.Errorf("got %v at the end, want io.EOF", err)
+	}
+}
+
+func TestElementsIndefiniteLength(t *testing.T) {
+	in, _ := hex.DecodeString("3080" + "020105" + "0101ff" + "0000")
+	_, _, _, content, full, err := Elements(in).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(full, in) || !bytes.Equal(content, in[2:8]) {
+		t.Errorf("got %x %x", content, full)
+	}
+
+	// The iteration stops at end-of-contents octets.
+	it := Elements(in[2:])
+	for i := 0; i < 2; i++ {
+		if _, _, _, _, _, err := it.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, _, _, _, err := it.Next(); err != io.EOF {
+		t.Errorf("got %v at end-of-contents, want io.EOF", err)
+	}
+}
+
+func TestElementsTruncated(t *testing.T) {
+	in, _ := hex.DecodeString("020105" + "0405616263")
+	it := Elements(in)
+	if _, _, _, _, _, err := it.Next(); err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, _, _, err := it.Next()
+	if se, ok := err.(SyntaxError); !ok || se.Offset != 3 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want truncated SyntaxError at offset 3", err)
+	}
+	if _, _, _, _, _, err2 := it.Next(); err2 != err {
+		t.Errorf("got %v after an error, want %v", err2, err)
+	}
+}
+
+func TestExplicitWrap(t *testing.T) {
+	integer, _ := hex.DecodeString("020105")
+	long := bytes.Repeat([]byte{0x05, 0x00}, 100)
+	for _, test := range []struct {
+		class, tag int
+		inner      []byte
+		out        string
+	}{
+		{asn1.ClassContextSpecific, 0, integer, "a003020105"},
+		{asn1.ClassApplication, 40, integer, "7f2803020105"},
+		{asn1.ClassUniversal, asn1.TagSequence, integer, "3003020105"},
+		{asn1.ClassContextSpecific, 1, long, "a181c8" + hex.EncodeToString(long)},
+	} {
+		out := ExplicitWrap(test.class, test.tag, test.inner)
+		if got := hex.EncodeToString(out); got != test.out {
+			t.Errorf("%d %d: got %s want %s", test.class, test.tag, got, test.out)
+			continue
+		}
+		class, tag, inner, err := ExplicitUnwrap(out)
+		if err != nil || class != test.class || tag != test.tag || !bytes.Equal(inner, test.inner) {
+			t.Errorf("%s: unwrapped %d %d %x, %v", test.out, class, tag, inner, err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for an invalid class")
+		}
+	}()
+	ExplicitWrap(4, 0, integer)
+}
+
+func TestExplicitUnwrap(t *testing.T) {
+	// The end-of-contents octets of an indefinite length are stripped too.
+	in, _ := hex.DecodeString("a080" + "020105" + "0000")
+	class, tag, inner, err := ExplicitUnwrap(in)
+	if err != nil || class != asn1.ClassContextSpecific || tag != 0 || hex.EncodeToString(inner) != "020105" {
+		t.Errorf("got %d %d %x, %v", class, tag, inner, err)
+	}
+
+	for _, test := range []struct {
+		in        string
+		err       string
+		truncated bool
+	}{
+		{"020105", "primitive element has no explicit tag to unwrap", false},
+		{"a003020105" + "00", "trailing data", false},
+		{"a00302", "data truncated", true},
+		{"a080020105", "missing end-of-contents octets", true},
+		{"", "data truncated", true},
+	} {
+		in, _ := hex.DecodeString(test.in)
+		_, _, _, err := ExplicitUnwrap(in)
+		if err == nil || !strings.Contains(err.Error(), test.err) || errors.Is(err, io.ErrUnexpectedEOF) != test.truncated {
+			t.Errorf("%s: got %v, want %s", test.in, err, test.err)
+		}
+	}
+}
+
+func TestGetElement(t *testing.T) {
+	type algorithm struct {
+		Algorithm asn1.ObjectIdentifier
+		Critical  bool
+	}
+	type signed struct {
+		Version   int
+		Algorithm algorithm
+		Signature []byte
+	}
+	alg := algorithm{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, true}
+	data, err := Marshal(signed{2, alg, []byte{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Marshal(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetElement(data, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	for _, index := range []int{3, -1} {
+		if _, err := GetElement(data, index); err == nil {
+			t.Errorf("index %d: no error", index)
+		}
+	}
+
+	in, _ := hex.DecodeString("3080" + "020105" + "0101ff" + "0000")
+	if got, err := GetElement(in, 1); err != nil || hex.EncodeToString(got) != "0101ff" {
+		t.Errorf("indefinite length: got %x, %v", got, err)
+	}
+	if _, err := GetElement(in, 2); err == nil {
+		t.Error("indefinite length: index 2 gave no error")
+	}
+
+	for _, test := range []struct {
+		in   string
+		want error
+	}{
+		{"020105", StructuralError{Msg: "primitive element has no children"}},
+		{"3003020105" + "00", SyntaxError{Msg: "trailing data", Offset: 5}},
+		{"3004020205", SyntaxError{Msg: "data truncated", truncated: true}},
+		{"3003020205", SyntaxError{Msg: "data truncated", Offset: 2}},
+	} {
+		in, _ := hex.DecodeString(test.in)
+		if _, err := GetElement(in, 0); err != test.want {
+			t.Errorf("%s: got %v, want %v", test.in, err, test.want)
+		}
+	}
+}
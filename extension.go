@@ -0,0 +1,13 @@
+package ber
+
+// An ExtensionMarker field marks where the extension marker "..." of a
+// SEQUENCE type falls among its fields. It has no element of its own. The
+// fields after it are extension additions, added in later versions of the
+// type, so Unmarshal accepts a SEQUENCE which ends before them, leaving them
+// unchanged or set to their default. The field may be named "_".
+type ExtensionMarker struct{}
+
+// A RawTail holds the contents of a SEQUENCE after the elements of the other
+// fields of a struct, as the last field with the tag "tail", so that elements
+// the type doesn't know of are kept to be marshaled again unchanged.
+type RawTail []byte
@@ -0,0 +1,38 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// A KerberosTime is the KerberosTime of RFC 4120 section 5.2.3, a
+// GeneralizedTime in UTC without fractional seconds, such as
+// 19700101000000Z. Marshaling drops any fraction of a second; unmarshaling
+// accepts only that form.
+type KerberosTime struct {
+	time.Time
+}
+
+// kerberosTimeLayout is the only form a KerberosTime may take.
+const kerberosTimeLayout = "20060102150405Z"
+
+var kerberosTimeType = reflect.TypeOf(KerberosTime{})
+
+func makeKerberosTime(t time.Time) (e encoder, err error) {
+	t = t.UTC()
+	if year := t.Year(); year < 0 || year > 9999 {
+		return nil, asn1.StructuralError{Msg: "cannot represent time as KerberosTime"}
+	}
+	return bytesEncoder(t.AppendFormat(nil, kerberosTimeLayout)), nil
+}
+
+func parseKerberosTime(bytes []byte) (ret KerberosTime, err error) {
+	s := string(bytes)
+	t, err := time.Parse(kerberosTimeLayout, s)
+	if err != nil || t.Format(kerberosTimeLayout) != s {
+		return KerberosTime{}, asn1.StructuralError{Msg: fmt.Sprintf("invalid KerberosTime %q", s)}
+	}
+	return KerberosTime{t}, nil
+}
@@ -0,0 +1,96 @@
+package ber
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type principalName struct {
+	NameType   int      `asn1:"explicit,tag:0"`
+	NameString []string `asn1:"general,explicit,tag:1"`
+}
+
+func TestPrincipalName(t *testing.T) {
+	// krbtgt/EXAMPLE.COM, of name type NT-SRV-INST.
+	in, _ := hex.DecodeString("301e" + "a003020102" + "a117" + "3015" + "1b066b7262746774" + "1b0b4558414d504c452e434f4d")
+	var got principalName
+	if _, err := Unmarshal(in, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := principalName{2, []string{"krbtgt", "EXAMPLE.COM"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+	out, err := Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(out) != hex.EncodeToString(in) {
+		t.Errorf("got %x want %x", out, in)
+	}
+}
+
+func TestKerberosTime(t *testing.T) {
+	kt := KerberosTime{time.Date(2037, 9, 13, 2, 48, 5, 500, time.FixedZone("", 3600))}
+	out, err := Marshal(kt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out[2:]); out[0] != 0x18 || got != "20370913014805Z" {
+		t.Errorf("got %x", out)
+	}
+	var got KerberosTime
+	if _, err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := kt.Truncate(time.Second); !got.Equal(want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	for _, s := range []string{"20370913014805.5Z", "20370913014805", "20370913014805+0100", "203709130148Z"} {
+		in := append([]byte{0x18, byte(len(s))}, s...)
+		if _, err := Unmarshal(in, &got); err == nil {
+			t.Errorf("%s accepted as a KerberosTime", s)
+		}
+	}
+}
+
+type kdcReqBody struct {
+	SName principalName `asn1:"explicit,tag:3"`
+	Till  KerberosTime  `asn1:"explicit,tag:5"`
+}
+
+type kdcReq struct {
+	PVNO    int        `asn1:"explicit,tag:1"`
+	MsgType int        `asn1:"explicit,tag:2"`
+	ReqBody kdcReqBody `asn1:"explicit,tag:4"`
+}
+
+func TestApplicationTaggedASReq(t *testing.T) {
+	req := kdcReq{5, 10, kdcReqBody{
+		principalName{2, []string{"krbtgt", "EXAMPLE.COM"}},
+		KerberosTime{time.Date(2037, 9, 13, 2, 48, 5, 0, time.UTC)},
+	}}
+	out, err := MarshalWithParams(req, "application,tag:10,explicit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[0] != 0x6a {
+		t.Errorf("got identifier %#x, want [APPLICATION 10] constructed", out[0])
+	}
+	if out[2] != 0x30 {
+		t.Errorf("got inner identifier %#x, want SEQUENCE", out[2])
+	}
+	var got kdcReq
+	if _, err := UnmarshalWithParams(out, &got, "application,tag:10,explicit"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, req) {
+		t.Errorf("got %+v want %+v", got, req)
+	}
+	if _, err := UnmarshalWithParams(out, &got, "application,tag:11,explicit"); err == nil {
+		t.Error("AS-REQ accepted as a TGS-REQ")
+	}
+}
@@ -0,0 +1,63 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"io"
+)
+
+// ReadMessage reads one LDAP message from r and returns its encoding. An
+// LDAPMessage is a SEQUENCE, which RFC 4511 section 5.1 requires to have a
+// definite length, so exactly as many bytes are read from r as the length
+// given, leaving r positioned at the next message. The contents of the
+// message are not checked.
+//
+// ReadMessage returns io.EOF if r ends before the message starts and
+// io.ErrUnexpectedEOF if it ends within it.
+func ReadMessage(r io.Reader) ([]byte, error) {
+	dec := NewDecoder(r)
+	b, t, err := dec.readHeader(nil)
+	if err != nil {
+		if err == io.EOF && len(b) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err = checkMessageHeader(t); err != nil {
+		return nil, err
+	}
+	if b, err = dec.readContents(b, t.length); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// WriteMessage writes the encoding of one LDAP message to w, with a single
+// call to Write. It returns an error without writing anything if msg is not
+// exactly one SEQUENCE of definite length.
+func WriteMessage(w io.Writer, msg []byte) error {
+	t, offset, err := parseTagAndLength(msg, 0)
+	if err != nil {
+		return decoder{}.locate(err, 0)
+	}
+	if err = checkMessageHeader(t); err != nil {
+		return err
+	}
+	if offset+t.length != len(msg) {
+		return SyntaxError{Msg: "LDAP message length doesn't match its encoding", Offset: 0}
+	}
+	n, err := w.Write(msg)
+	if err == nil && n != len(msg) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+func checkMessageHeader(t tagAndLength) error {
+	if t.class != asn1.ClassUniversal || t.tag != asn1.TagSequence || !t.isCompound {
+		return StructuralError{Msg: "LDAP message is not a SEQUENCE"}
+	}
+	if t.isIndefinite {
+		return StructuralError{Msg: "indefinite length LDAP message"}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package ber
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+)
+
+type ldapMessage struct {
+	MessageID int
+	Op        ldapSearchRequest `asn1:"application,tag:3"`
+}
+
+func TestReadWriteMessage(t *testing.T) {
+	first, err := Marshal(ldapMessage{1, ldapSearchRequest{BaseObject: []byte("dc=example"), Filter: ldapFilter{Present: []byte("cn")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second message has a length in the long form.
+	second, err := Marshal(ldapMessage{2, ldapSearchRequest{BaseObject: []byte(strings.Repeat("a", 200)), Filter: ldapFilter{Present: []byte("cn")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		for _, msg := range [][]byte{first, second} {
+			if err := WriteMessage(w, msg); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		w.Close()
+	}()
+
+	for i, want := range [][]byte{first, second} {
+		got, err := ReadMessage(r)
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("#%d: got %x want %x", i, got, want)
+		}
+		var m ldapMessage
+		if _, err := Unmarshal(got, &m); err != nil {
+			t.Errorf("#%d: %s", i, err)
+		} else if m.MessageID != i+1 {
+			t.Errorf("#%d: got message ID %d", i, m.MessageID)
+		}
+	}
+	if _, err := ReadMessage(r); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestReadMessageInvalid(t *testing.T) {
+	for i, test := range []string{"30", "3081", "300502010163", "3082010002"} {
+		in, _ := hex.DecodeString(test)
+		if _, err := ReadMessage(bytes.NewReader(in)); err != io.ErrUnexpectedEOF {
+			t.Errorf("#%d: got %v, want io.ErrUnexpectedEOF", i, err)
+		}
+	}
+
+	for i, test := range []string{"3180020101", "020101", "3080020101" + "0000"} {
+		in, _ := hex.DecodeString(test)
+		if _, err := ReadMessage(bytes.NewReader(in)); err == nil {
+			t.Errorf("#%d: read %s without error", i, test)
+		}
+		var b bytes.Buffer
+		if err := WriteMessage(&b, in); err == nil || b.Len() != 0 {
+			t.Errorf("#%d: wrote %x, %v", i, b.Bytes(), err)
+		}
+	}
+
+	// WriteMessage also requires exactly one message.
+	in, _ := hex.DecodeString("3003020101" + "3003020102")
+	var b bytes.Buffer
+	if err := WriteMessage(&b, in); err == nil || b.Len() != 0 {
+		t.Errorf("wrote %x, %v", b.Bytes(), err)
+	}
+}
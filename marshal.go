@@ -1,8 +1,1543 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
 package ber
 
-import "encoding/asn1"
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"net"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	byte00Encoder encoder = byteEncoder(0x00)
+	byteFFEncoder encoder = byteEncoder(0xff)
+
+	endOfContentsEncoder encoder = bytesEncoder{0x00, 0x00}
+)
+
+// encoder represents an ASN.1 element that is waiting to be marshaled.
+type encoder interface {
+	// Len returns the number of bytes needed to marshal this element.
+	Len() int
+	// Encode encodes this element by writing Len() bytes to dst.
+	Encode(dst []byte)
+}
+
+type byteEncoder byte
+
+func (c byteEncoder) Len() int {
+	return 1
+}
+
+func (c byteEncoder) Encode(dst []byte) {
+	dst[0] = byte(c)
+}
+
+type bytesEncoder []byte
+
+func (b bytesEncoder) Len() int {
+	return len(b)
+}
+
+func (b bytesEncoder) Encode(dst []byte) {
+	if copy(dst, b) != len(b) {
+		panic("internal error")
+	}
+}
+
+type stringEncoder string
+
+func (s stringEncoder) Len() int {
+	return len(s)
+}
+
+func (s stringEncoder) Encode(dst []byte) {
+	if copy(dst, s) != len(s) {
+		panic("internal error")
+	}
+}
+
+type multiEncoder []encoder
+
+func (m multiEncoder) Len() int {
+	var size int
+	for _, e := range m {
+		size += e.Len()
+	}
+	return size
+}
+
+func (m multiEncoder) Encode(dst []byte) {
+	var off int
+	for _, e := range m {
+		e.Encode(dst[off:])
+		off += e.Len()
+	}
+}
+
+type setEncoder []encoder
+
+func (s setEncoder) Len() int {
+	var size int
+	for _, e := range s {
+		size += e.Len()
+	}
+	return size
+}
+
+// setScratch holds the encodings of the elements of a SET OF while they are
+// sorted. They are pooled, as every SET OF needs one.
+type setScratch struct {
+	buf      []byte
+	elements [][]byte
+}
+
+var setScratchPool = sync.Pool{
+	New: func() any { return new(setScratch) },
+}
+
+// maxPooledScratch bounds the size of the buffers kept by setScratchPool,
+// so that one large SET OF doesn't hold on to its memory.
+const maxPooledScratch = 64 << 10
+
+func (s setEncoder) Encode(dst []byte) {
+	// Per X690 Section 11.6: The encodings of the component values of a
+	// set-of value shall appear in ascending order, the encodings being
+	// compared as octet strings with the shorter components being padded
+	// at their trailing end with 0-octets.
+	//
+	// First we encode each element to its TLV encoding and then use
+	// octetSort to get the ordering expected by X690 DER rules before
+	// writing the sorted encodings out to dst.
+	scratch := setScratchPool.Get().(*setScratch)
+	buf := slices.Grow(scratch.buf[:0], s.Len())
+	l := scratch.elements[:0]
+	for _, e := range s {
+		n := len(buf)
+		buf = buf[:n+e.Len()]
+		e.Encode(buf[n:])
+		l = append(l, buf[n:])
+	}
+
+	// Since we are using bytes.Compare to compare TLV encodings we
+	// don't need to right pad s[i] and s[j] to the same length as
+	// suggested in X690. If len(s[i]) < len(s[j]) the length octet of
+	// s[i], which is the first determining byte, will inherently be
+	// smaller than the length octet of s[j]. This lets us skip the
+	// padding step.
+	slices.SortFunc(l, bytes.Compare)
+
+	var off int
+	for _, b := range l {
+		copy(dst[off:], b)
+		off += len(b)
+	}
+
+	if cap(buf) <= maxPooledScratch {
+		clear(l)
+		scratch.buf, scratch.elements = buf, l[:0]
+		setScratchPool.Put(scratch)
+	}
+}
+
+// sortByTag returns an encoder for the components of a SET, encoded by m, in
+// the canonical order of their tags which DER requires, see X.690 section
+// 10.3. Omitted components encode to nothing and are dropped.
+func sortByTag(m []encoder) encoder {
+	type component struct {
+		t tagAndLength
+		b bytesEncoder
+	}
+	components := make([]component, 0, len(m))
+	for _, e := range m {
+		if e.Len() == 0 {
+			continue
+		}
+		b := make(bytesEncoder, e.Len())
+		e.Encode(b)
+		// The encoding was made by Marshal, so is well formed.
+		t, _, _ := parseTagAndLength(b, 0)
+		components = append(components, component{t, b})
+	}
+	slices.SortStableFunc(components, func(a, b component) int {
+		return compareTags(a.t, b.t)
+	})
+	sorted := make(multiEncoder, len(components))
+	for i, c := range components {
+		sorted[i] = c.b
+	}
+	return sorted
+}
+
+type taggedEncoder struct {
+	// scratch contains temporary space for encoding the tag and length of
+	// an element in order to avoid extra allocations.
+	scratch [8]byte
+	tag     encoder
+	body    encoder
+}
+
+func (t *taggedEncoder) Len() int {
+	return t.tag.Len() + t.body.Len()
+}
+
+func (t *taggedEncoder) Encode(dst []byte) {
+	t.tag.Encode(dst)
+	t.body.Encode(dst[t.tag.Len():])
+}
+
+type int64Encoder int64
+
+func (i int64Encoder) Len() int {
+	n := 1
+
+	for i > 127 {
+		n++
+		i >>= 8
+	}
+
+	for i < -128 {
+		n++
+		i >>= 8
+	}
+
+	return n
+}
+
+func (i int64Encoder) Encode(dst []byte) {
+	n := i.Len()
+
+	for j := 0; j < n; j++ {
+		dst[j] = byte(i >> uint((n-1-j)*8))
+	}
+}
+
+// makeUint64 returns an encoder for the INTEGER n. A value too large for an
+// int64 has its top bit set, so it is preceded by a zero octet to keep it
+// positive.
+func makeUint64(n uint64) encoder {
+	if n <= math.MaxInt64 {
+		return int64Encoder(n)
+	}
+
+	b := make(bytesEncoder, 9)
+	binary.BigEndian.PutUint64(b[1:], n)
+	return b
+}
+
+func base128IntLength(n int64) int {
+	if n == 0 {
+		return 1
+	}
+
+	l := 0
+	for i := n; i > 0; i >>= 7 {
+		l++
+	}
+
+	return l
+}
+
+func appendBase128Int(dst []byte, n int64) []byte {
+	l := base128IntLength(n)
+
+	for i := l - 1; i >= 0; i-- {
+		o := byte(n >> uint(i*7))
+		o &= 0x7f
+		if i != 0 {
+			o |= 0x80
+		}
+
+		dst = append(dst, o)
+	}
+
+	return dst
+}
+
+func makeBigInt(n *big.Int) (encoder, error) {
+	if n == nil {
+		return nil, asn1.StructuralError{Msg: "empty integer"}
+	}
+
+	if n.Sign() < 0 {
+		// A negative number has to be converted to two's-complement
+		// form. So we'll invert and subtract 1. If the
+		// most-significant-bit isn't set then we'll need to pad the
+		// beginning with 0xff in order to keep the number negative.
+		nMinus1 := new(big.Int).Neg(n)
+		nMinus1.Sub(nMinus1, bigOne)
+		bytes := nMinus1.Bytes()
+		for i := range bytes {
+			bytes[i] ^= 0xff
+		}
+		if len(bytes) == 0 || bytes[0]&0x80 == 0 {
+			return multiEncoder([]encoder{byteFFEncoder, bytesEncoder(bytes)}), nil
+		}
+		return bytesEncoder(bytes), nil
+	} else if n.Sign() == 0 {
+		// Zero is written as a single 0 zero rather than no bytes.
+		return byte00Encoder, nil
+	} else {
+		bytes := n.Bytes()
+		if len(bytes) > 0 && bytes[0]&0x80 != 0 {
+			// We'll have to pad this with 0x00 in order to stop it
+			// looking like a negative number.
+			return multiEncoder([]encoder{byte00Encoder, bytesEncoder(bytes)}), nil
+		}
+		return bytesEncoder(bytes), nil
+	}
+}
+
+func makeReal(f float64) encoder {
+	switch {
+	case math.IsInf(f, 1):
+		return byteEncoder(0x40)
+	case math.IsInf(f, -1):
+		return byteEncoder(0x41)
+	case math.IsNaN(f):
+		return byteEncoder(0x42)
+	case f == 0:
+		if math.Signbit(f) {
+			return byteEncoder(0x43)
+		}
+		// Positive zero is written as no bytes at all.
+		return bytesEncoder(nil)
+	}
+
+	// Finite values use the binary form with a base of 2, no scale factor
+	// and an odd mantissa, as required by X.690 section 11.3.1.
+	b := byte(0x80)
+	if f < 0 {
+		b |= 0x40
+		f = -f
+	}
+	frac, exp := math.Frexp(f)
+	mantissa := uint64(math.Ldexp(frac, 64))
+	exp -= 64
+	for mantissa&1 == 0 {
+		mantissa >>= 1
+		exp++
+	}
+
+	e := int64Encoder(exp)
+	b |= byte(e.Len() - 1)
+	dst := make([]byte, 1+e.Len(), 1+e.Len()+8)
+	dst[0] = b
+	e.Encode(dst[1:])
+	for i := (bits.Len64(mantissa)+7)/8 - 1; i >= 0; i-- {
+		dst = append(dst, byte(mantissa>>uint(i*8)))
+	}
+
+	return bytesEncoder(dst)
+}
+
+// makeRealRat returns an encoder for r as an ASN.1 REAL in the canonical
+// decimal NR3 form of X.690 section 11.3.1. The value must have a terminating
+// decimal expansion unless a precision is given to round it to.
+func makeRealRat(r *big.Rat, params fieldParameters) (e encoder, err error) {
+	if r == nil {
+		return nil, params.structuralError("nil big.Rat")
+	}
+	if r.Sign() == 0 {
+		return bytesEncoder(nil), nil
+	}
+
+	// r is n/d in lowest terms, so it terminates if d is 2^twos * 5^fives
+	// and then n * 10^k / d is an integer for k = max(twos, fives).
+	d := new(big.Int).Set(r.Denom())
+	twos := d.TrailingZeroBits()
+	d.Rsh(d, twos)
+	fives := uint(0)
+	five, ten := big.NewInt(5), big.NewInt(10)
+	for q, m := new(big.Int), new(big.Int); ; fives++ {
+		if q.QuoRem(d, five, m); m.Sign() != 0 {
+			break
+		}
+		d.Set(q)
+	}
+	if d.Cmp(bigOne) != 0 {
+		if params.precision == nil {
+			return nil, params.structuralError("big.Rat has no exact decimal representation")
+		}
+		r, _ = new(big.Rat).SetString(r.FloatString(*params.precision))
+		if r.Sign() == 0 {
+			return bytesEncoder(nil), nil
+		}
+		return makeRealRat(r, fieldParameters{name: params.name})
+	}
+
+	k := max(twos, fives)
+	mantissa := new(big.Int).Exp(ten, big.NewInt(int64(k)), nil)
+	mantissa.Mul(mantissa, r.Num())
+	mantissa.Quo(mantissa, r.Denom())
+	exp := -int64(k)
+	for q, m := new(big.Int), new(big.Int); ; exp++ {
+		if q.QuoRem(mantissa, ten, m); m.Sign() != 0 {
+			break
+		}
+		mantissa.Set(q)
+	}
+
+	b := []byte{0x03}
+	b = mantissa.Append(b, 10)
+	b = append(b, ".E"...)
+	if exp == 0 {
+		b = append(b, '+')
+	}
+	b = strconv.AppendInt(b, exp, 10)
+	return bytesEncoder(b), nil
+}
+
+func appendLength(dst []byte, i int) []byte {
+	n := lengthLength(i)
+
+	for ; n > 0; n-- {
+		dst = append(dst, byte(i>>uint((n-1)*8)))
+	}
+
+	return dst
+}
+
+func lengthLength(i int) (numBytes int) {
+	numBytes = 1
+	for i > 255 {
+		numBytes++
+		i >>= 8
+	}
+	return
+}
+
+func appendTagAndLength(dst []byte, t tagAndLength) []byte {
+	b := uint8(t.class) << 6
+	if t.isCompound {
+		b |= 0x20
+	}
+	if t.tag >= 31 {
+		b |= 0x1f
+		dst = append(dst, b)
+		dst = appendBase128Int(dst, int64(t.tag))
+	} else {
+		b |= uint8(t.tag)
+		dst = append(dst, b)
+	}
+
+	if t.isIndefinite {
+		dst = append(dst, 0x80)
+	} else if t.length >= 128 {
+		l := lengthLength(t.length)
+		dst = append(dst, 0x80|byte(l))
+		dst = appendLength(dst, t.length)
+	} else {
+		dst = append(dst, byte(t.length))
+	}
+
+	return dst
+}
+
+type bitStringEncoder asn1.BitString
+
+func (b bitStringEncoder) Len() int {
+	return len(b.Bytes) + 1
+}
+
+func (b bitStringEncoder) Encode(dst []byte) {
+	dst[0] = byte((8 - b.BitLength%8) % 8)
+	if copy(dst[1:], b.Bytes) != len(b.Bytes) {
+		panic("internal error")
+	}
+	// The unused bits are written as zero, whatever b holds, as DER
+	// requires.
+	if len(b.Bytes) > 0 {
+		dst[len(b.Bytes)] &^= 1<<dst[0] - 1
+	}
+}
+
+type oidEncoder []int
+
+func (oid oidEncoder) Len() int {
+	l := base128IntLength(int64(oid[0]*40 + oid[1]))
+	for i := 2; i < len(oid); i++ {
+		l += base128IntLength(int64(oid[i]))
+	}
+	return l
+}
+
+func (oid oidEncoder) Encode(dst []byte) {
+	dst = appendBase128Int(dst[:0], int64(oid[0]*40+oid[1]))
+	for i := 2; i < len(oid); i++ {
+		dst = appendBase128Int(dst, int64(oid[i]))
+	}
+}
+
+func makeObjectIdentifier(oid []int) (e encoder, err error) {
+	if len(oid) < 2 || oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return nil, asn1.StructuralError{Msg: "invalid object identifier"}
+	}
+
+	return oidEncoder(oid), nil
+}
+
+func makePrintableString(s string) (e encoder, err error) {
+	for i := 0; i < len(s); i++ {
+		// The asterisk is often used in PrintableString, even though
+		// it is invalid. If a PrintableString was specifically
+		// requested then the asterisk is permitted by this code.
+		// Ampersand is allowed in parsing due a handful of CA
+		// certificates, however when making new certificates
+		// it is rejected.
+		if !isPrintable(s[i], allowAsterisk, rejectAmpersand) {
+			return nil, asn1.StructuralError{Msg: "PrintableString contains invalid character"}
+		}
+	}
+
+	return stringEncoder(s), nil
+}
+
+func makeIA5String(s string) (e encoder, err error) {
+	for i := 0; i < len(s); i++ {
+		if s[i] > 127 {
+			return nil, asn1.StructuralError{Msg: "IA5String contains invalid character"}
+		}
+	}
+
+	return stringEncoder(s), nil
+}
+
+func makeNumericString(s string) (e encoder, err error) {
+	for i := 0; i < len(s); i++ {
+		if !isNumeric(s[i]) {
+			return nil, asn1.StructuralError{Msg: "NumericString contains invalid character"}
+		}
+	}
+
+	return stringEncoder(s), nil
+}
+
+func makeUTF8String(s string) encoder {
+	return stringEncoder(s)
+}
+
+func makeBMPString(s string) (e encoder, err error) {
+	if !utf8.ValidString(s) {
+		return nil, errors.New("asn1: string not valid UTF-8")
+	}
+
+	dst := make([]byte, 0, 2*len(s))
+	for _, r := range s {
+		// BMPString is UCS-2, so only code points in the Basic
+		// Multilingual Plane can be represented; surrogate pairs are
+		// not permitted.
+		if r > 0xffff || utf16.IsSurrogate(r) {
+			return nil, asn1.StructuralError{Msg: fmt.Sprintf("BMPString cannot represent %U", r)}
+		}
+		dst = append(dst, byte(r>>8), byte(r))
+	}
+
+	return bytesEncoder(dst), nil
+}
+
+func makeVisibleString(s string, params fieldParameters) (e encoder, err error) {
+	for _, r := range s {
+		if !isVisible(r) {
+			return nil, params.structuralError(fmt.Sprintf("VisibleString contains invalid character %q", r))
+		}
+	}
+
+	return stringEncoder(s), nil
+}
+
+func makeUniversalString(s string) (e encoder, err error) {
+	if !utf8.ValidString(s) {
+		return nil, errors.New("asn1: string not valid UTF-8")
+	}
+
+	dst := make([]byte, 0, 4*utf8.RuneCountInString(s))
+	for _, r := range s {
+		dst = append(dst, byte(r>>24), byte(r>>16), byte(r>>8), byte(r))
+	}
+
+	return bytesEncoder(dst), nil
+}
+
+func makeIP(ip net.IP) (e encoder, err error) {
+	// IPv4 addresses are always written in their 4-byte form, even when
+	// held as an IPv4-mapped IPv6 address.
+	if ip4 := ip.To4(); ip4 != nil {
+		return bytesEncoder(ip4), nil
+	}
+	if len(ip) != net.IPv6len {
+		return nil, asn1.StructuralError{Msg: fmt.Sprintf("invalid IP address length %d", len(ip))}
+	}
+
+	return bytesEncoder(ip), nil
+}
+
+func appendTwoDigits(dst []byte, v int) []byte {
+	return append(dst, byte('0'+(v/10)%10), byte('0'+v%10))
+}
+
+func appendFourDigits(dst []byte, v int) []byte {
+	return append(dst,
+		byte('0'+(v/1000)%10),
+		byte('0'+(v/100)%10),
+		byte('0'+(v/10)%10),
+		byte('0'+v%10))
+}
+
+func outsideUTCRange(t time.Time) bool {
+	year := t.UTC().Year()
+	return year < 1950 || year >= 2050
+}
+
+// makeUTCTime encodes t as a UTCTime, always in the form YYMMDDHHMMSSZ which
+// DER requires.
+func makeUTCTime(t time.Time) (e encoder, err error) {
+	dst := make([]byte, 0, 18)
+
+	dst, err = appendUTCTime(dst, t.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	return bytesEncoder(dst), nil
+}
+
+func makeGeneralizedTime(t time.Time) (e encoder, err error) {
+	dst := make([]byte, 0, 20)
+
+	dst, err = appendGeneralizedTime(dst, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytesEncoder(dst), nil
+}
+
+// makeTimeLayout encodes t as the contents of a GeneralizedTime written with
+// the Go layout given by the timelayout tag. The layout must have a four
+// digit year and be able to parse what it writes.
+func makeTimeLayout(t time.Time, params fieldParameters) (e encoder, err error) {
+	s := t.Format(params.timeLayout)
+	if _, err := time.Parse(params.timeLayout, s); err != nil || !strings.Contains(params.timeLayout, "2006") {
+		return nil, params.structuralError("invalid time layout " + strconv.Quote(params.timeLayout))
+	}
+	return stringEncoder(s), nil
+}
+
+// makeTimeType encodes t as a DATE, TIME-OF-DAY or DATE-TIME, given by tag.
+// The zone of t is not encoded.
+func makeTimeType(t time.Time, tag int) (e encoder, err error) {
+	if year := t.Year(); tag != tagTimeOfDay && (year < 0 || year > 9999) {
+		return nil, asn1.StructuralError{Msg: "cannot represent time as " + universalTagName[tag]}
+	}
+	return bytesEncoder(t.AppendFormat(nil, timeTypeLayouts[tag])), nil
+}
+
+func appendUTCTime(dst []byte, t time.Time) (ret []byte, err error) {
+	year := t.Year()
+
+	switch {
+	case 1950 <= year && year < 2000:
+		dst = appendTwoDigits(dst, year-1900)
+	case 2000 <= year && year < 2050:
+		dst = appendTwoDigits(dst, year-2000)
+	default:
+		return nil, asn1.StructuralError{Msg: "cannot represent time as UTCTime"}
+	}
+
+	return appendTimeCommon(dst, t), nil
+}
+
+func appendGeneralizedTime(dst []byte, t time.Time) (ret []byte, err error) {
+	year := t.Year()
+	if year < 0 || year > 9999 {
+		return nil, asn1.StructuralError{Msg: "cannot represent time as GeneralizedTime"}
+	}
+
+	dst = appendFourDigits(dst, year)
+	dst = appendDateAndClock(dst, t)
+
+	// Fractional seconds are written without trailing zeros, as X.690
+	// section 11.7.3 requires.
+	if nsec := t.Nanosecond(); nsec != 0 {
+		frac := strconv.AppendInt(nil, int64(nsec)+1e9, 10)
+		frac[0] = '.'
+		dst = append(dst, bytes.TrimRight(frac, "0")...)
+	}
+
+	return appendTimeZone(dst, t), nil
+}
+
+func appendTimeCommon(dst []byte, t time.Time) []byte {
+	return appendTimeZone(appendDateAndClock(dst, t), t)
+}
+
+func appendDateAndClock(dst []byte, t time.Time) []byte {
+	_, month, day := t.Date()
+
+	dst = appendTwoDigits(dst, int(month))
+	dst = appendTwoDigits(dst, day)
+
+	hour, min, sec := t.Clock()
+
+	dst = appendTwoDigits(dst, hour)
+	dst = appendTwoDigits(dst, min)
+	return appendTwoDigits(dst, sec)
+}
+
+func appendTimeZone(dst []byte, t time.Time) []byte {
+	_, offset := t.Zone()
+
+	switch {
+	case offset/60 == 0:
+		return append(dst, 'Z')
+	case offset > 0:
+		dst = append(dst, '+')
+	case offset < 0:
+		dst = append(dst, '-')
+	}
+
+	offsetMinutes := offset / 60
+	if offsetMinutes < 0 {
+		offsetMinutes = -offsetMinutes
+	}
+
+	dst = appendTwoDigits(dst, offsetMinutes/60)
+	dst = appendTwoDigits(dst, offsetMinutes%60)
+
+	return dst
+}
+
+func stripTagAndLength(in []byte) []byte {
+	t, offset, err := parseTagAndLength(in, 0)
+	if err != nil || invalidLength(offset, t.length, len(in)) {
+		return in
+	}
+	// The end-of-contents octets of an indefinite length are dropped with
+	// the header.
+	return in[offset : offset+t.length]
+}
+
+func (o MarshalOptions) makeBody(value reflect.Value, params fieldParameters) (e encoder, err error) {
+	switch value.Type() {
+	case flagType:
+		return bytesEncoder(nil), nil
+	case timeType:
+		t := value.Interface().(time.Time)
+		if params.frac != nil {
+			// Truncating rather than rounding, so the time isn't
+			// moved into the next second.
+			t = t.Truncate(time.Duration(math.Pow10(9 - *params.frac)))
+		}
+		if params.timeLayout != "" {
+			return makeTimeLayout(t, params)
+		}
+		if _, ok := timeTypeLayouts[params.timeType]; ok {
+			return makeTimeType(t, params.timeType)
+		}
+		if params.timeType == timeTypeAuto && outsideUTCRange(t) {
+			// RFC 5280 requires a GeneralizedTime in UTC, without
+			// fractional seconds.
+			return makeGeneralizedTime(t.UTC().Truncate(time.Second))
+		}
+		if params.timeType == asn1.TagGeneralizedTime || outsideUTCRange(t) {
+			if o.CER || o.DER {
+				// X.690 section 11.7 requires UTC.
+				t = t.UTC()
+			}
+			return makeGeneralizedTime(t)
+		}
+		return makeUTCTime(t)
+	case kerberosTimeType:
+		return makeKerberosTime(value.Interface().(KerberosTime).Time)
+	case bitStringType:
+		v := value.Interface().(asn1.BitString)
+		return bitStringEncoder(v), nil
+	case objectIdentifierType:
+		v := value.Interface().(asn1.ObjectIdentifier)
+		if params.relative {
+			return makeRelativeOID(v)
+		}
+		return makeObjectIdentifier(v)
+	case relativeOIDType:
+		v := value.Interface().(RelativeOID)
+		return makeRelativeOID(v)
+	case bigOIDType:
+		v := value.Interface().(BigObjectIdentifier)
+		return makeBigObjectIdentifier(v)
+	case bigIntType:
+		v := value.Interface().(*big.Int)
+		return makeBigInt(v)
+	case ratType:
+		v := value.Interface().(*big.Rat)
+		return makeRealRat(v, params)
+	case uuidType:
+		v := value.Interface().(UUID)
+		return bytesEncoder(v[:]), nil
+	case ipAddressType:
+		v := value.Interface().(IPAddress)
+		return bytesEncoder(v[:]), nil
+	case isoDurationType:
+		v := value.Interface().(Duration)
+		if v.negative() {
+			return nil, params.structuralError("DURATION has a negative component")
+		}
+		return stringEncoder(v.String()), nil
+	case ipType:
+		v := value.Interface().(net.IP)
+		return makeIP(v)
+	case streamOctetStringType:
+		return nil, params.structuralError("StreamOctetString can't be marshaled")
+	case durationType:
+		// Durations are written as whole seconds, any sub-second
+		// precision is truncated.
+		return int64Encoder(value.Int() / int64(time.Second)), nil
+	}
+
+	switch v := value; v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return byteFFEncoder, nil
+		}
+		return byte00Encoder, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int64Encoder(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return makeUint64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return makeReal(v.Float()), nil
+	case reflect.Struct:
+		t := v.Type()
+		info := getStructInfo(t)
+		if info.namedBits {
+			return makeNamedBits(v)
+		}
+		if info.err != nil {
+			return nil, info.err
+		}
+		fields := info.fields
+
+		startingField := 0
+
+		n := len(fields)
+		if n == 0 {
+			return bytesEncoder(nil), nil
+		}
+
+		// If the first element of the structure is a non-empty
+		// RawContents, then we don't bother serializing the rest.
+		if info.rawContents {
+			s := v.FieldByIndex(fields[0].index)
+			if s.Len() > 0 {
+				bytes := s.Bytes()
+				/* The RawContents will contain the tag and
+				 * length fields but we'll also be writing
+				 * those ourselves, so we strip them out of
+				 * bytes */
+				return bytesEncoder(stripTagAndLength(bytes)), nil
+			}
+
+			startingField = 1
+		}
+
+		// The elements held by an any field, or the contents held by a
+		// tail field, follow the others.
+		if info.unmatched || info.tail {
+			if info.tail && params.set {
+				return nil, fields[n-1].params.structuralError("tail given to member of a SET")
+			}
+			rest := v.FieldByIndex(fields[n-1].index)
+			extra := rest.Len()
+			if info.tail {
+				extra = 1
+			}
+			m := make([]encoder, 0, n-1-startingField+extra)
+			for _, f := range fields[startingField : n-1] {
+				e, err := o.makeField(v.FieldByIndex(f.index), f.params)
+				if err != nil {
+					return nil, err
+				}
+				m = append(m, e)
+			}
+			if info.tail {
+				return multiEncoder(append(m, bytesEncoder(rest.Bytes()))), nil
+			}
+			for i := 0; i < rest.Len(); i++ {
+				e, err := o.makeField(rest.Index(i), fieldParameters{})
+				if err != nil {
+					return nil, err
+				}
+				m = append(m, e)
+			}
+			if params.set {
+				return sortByTag(m), nil
+			}
+			return multiEncoder(m), nil
+		}
+
+		switch n1 := n - startingField; n1 {
+		case 0:
+			return bytesEncoder(nil), nil
+		case 1:
+			f := fields[startingField]
+			return o.makeField(v.FieldByIndex(f.index), f.params)
+		default:
+			m := make([]encoder, n1)
+			for i := 0; i < n1; i++ {
+				f := fields[i+startingField]
+				m[i], err = o.makeField(v.FieldByIndex(f.index), f.params)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if params.set {
+				return sortByTag(m), nil
+			}
+			return multiEncoder(m), nil
+		}
+	case reflect.Array:
+		// Only a [N]byte reaches here, as getUniversalType rejects other
+		// arrays. It need not be addressable, so it is copied.
+		b := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(b), v)
+		return bytesEncoder(b), nil
+	case reflect.Slice:
+		sliceType := v.Type()
+		if sliceType.Elem().Kind() == reflect.Uint8 {
+			return bytesEncoder(v.Bytes()), nil
+		}
+		if params.bits {
+			return makeBoolBits(v), nil
+		}
+
+		// The string and time types given for a SEQUENCE OF apply to
+		// its elements, as does being a CHOICE.
+		fp := fieldParameters{stringType: params.stringType, timeType: params.timeType, choice: params.choice}
+
+		switch l := v.Len(); l {
+		case 0:
+			return bytesEncoder(nil), nil
+		case 1:
+			return o.makeField(v.Index(0), fp)
+		default:
+			m := make([]encoder, l)
+
+			for i := 0; i < l; i++ {
+				m[i], err = o.makeField(v.Index(i), fp)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if params.set {
+				return setEncoder(m), nil
+			}
+			return multiEncoder(m), nil
+		}
+	case reflect.Map:
+		entryType := mapEntryType(v.Type())
+		entries := reflect.MakeSlice(reflect.SliceOf(entryType), 0, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			entry := reflect.New(entryType).Elem()
+			entry.Field(0).Set(iter.Key())
+			entry.Field(1).Set(iter.Value())
+			entries = reflect.Append(entries, entry)
+		}
+		// The entries are sorted as the elements of any other SET OF.
+		params.set = true
+		return o.makeBody(entries, params)
+	case reflect.String:
+		switch params.stringType {
+		case asn1.TagIA5String:
+			return makeIA5String(v.String())
+		case asn1.TagPrintableString:
+			return makePrintableString(v.String())
+		case asn1.TagNumericString:
+			return makeNumericString(v.String())
+		case asn1.TagBMPString:
+			return makeBMPString(v.String())
+		case tagUniversalString:
+			return makeUniversalString(v.String())
+		case tagVisibleString:
+			return makeVisibleString(v.String(), params)
+		case asn1.TagT61String, asn1.TagGeneralString:
+			// Neither T61String nor GeneralString has a character set
+			// we can validate against, the bytes of the string are
+			// written unchanged.
+			return stringEncoder(v.String()), nil
+		default:
+			return makeUTF8String(v.String()), nil
+		}
+	}
+
+	return nil, asn1.StructuralError{Msg: "unknown Go type"}
+}
+
+// makeChoice returns an encoder for the single alternative set in the CHOICE
+// v. A tagged CHOICE is always explicitly tagged.
+func (o MarshalOptions) makeChoice(v reflect.Value, params fieldParameters) (e encoder, err error) {
+	t := v.Type()
+	if err = checkChoice(t); err != nil {
+		return
+	}
+	chosen := -1
+	for i := 0; i < t.NumField(); i++ {
+		if isSkippedField(t.Field(i)) || v.Field(i).IsNil() {
+			continue
+		}
+		if chosen >= 0 {
+			return nil, params.structuralError("more than one CHOICE alternative set")
+		}
+		chosen = i
+	}
+	if chosen < 0 {
+		return nil, params.structuralError("no CHOICE alternative set")
+	}
+
+	e, err = o.makeField(v.Field(chosen), structFieldParameters(t.Field(chosen)))
+	if err != nil || params.tag == nil {
+		return
+	}
+
+	return o.tagged(params.tagClass(), *params.tag, true, e), nil
+}
+
+// Marshaler is the interface implemented by types that can marshal themselves
+// into a BER element.
+//
+// MarshalBER returns the complete encoding of a single element, tag and length
+// included, which is written out unchanged. An explicit tag given for the
+// field wraps the element; an implicit tag can't be given since the type
+// chooses its own.
+type Marshaler interface {
+	MarshalBER() ([]byte, error)
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// marshaler returns v as a Marshaler if its type, or a pointer to it,
+// implements the interface.
+func marshaler(v reflect.Value) (Marshaler, bool) {
+	if v.Type().Implements(marshalerType) {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return nil, false
+		}
+		return v.Interface().(Marshaler), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(marshalerType) {
+		return v.Addr().Interface().(Marshaler), true
+	}
+	return nil, false
+}
+
+// makeMarshaler returns an encoder for the element returned by m, checking it
+// is a single, complete element.
+func (o MarshalOptions) makeMarshaler(m Marshaler, params fieldParameters) (e encoder, err error) {
+	if params.tag != nil && !params.explicit {
+		return nil, params.structuralError("implicit tag given to Marshaler")
+	}
+
+	b, err := m.MarshalBER()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, params.structuralError("Marshaler returned no element")
+	}
+	t, offset, err := parseTagAndLength(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	if t.isIndefinite {
+		offset += 2
+	}
+	if invalidLength(offset, t.length, len(b)) || offset+t.length != len(b) {
+		return nil, params.structuralError("Marshaler returned an invalid element")
+	}
+
+	e = bytesEncoder(b)
+	if params.tag != nil {
+		e = o.tagged(params.tagClass(), *params.tag, true, e)
+	}
+	return e, nil
+}
+
+func (o MarshalOptions) makeField(v reflect.Value, params fieldParameters) (e encoder, err error) {
+	if !v.IsValid() {
+		return nil, fmt.Errorf("asn1: cannot marshal nil value")
+	}
+	// If the field is an interface{} then recurse into it.
+	if v.Kind() == reflect.Interface && v.Type().NumMethod() == 0 {
+		return o.makeField(v.Elem(), params)
+	}
+
+	// Each outer explicit tag wraps the encoding given by the rest, unless
+	// the field is omitted.
+	if len(params.outerTags) > 0 {
+		inner := params
+		inner.outerTags = params.outerTags[1:]
+		e, err = o.makeField(v, inner)
+		if err != nil || e.Len() == 0 {
+			return e, err
+		}
+		return o.tagged(params.tagClass(), params.outerTags[0], true, e), nil
+	}
+
+	// Only a nil slice is omitted, so that an empty SEQUENCE OF can still
+	// be written from a non-nil empty one.
+	if v.Kind() == reflect.Slice && v.IsNil() && params.omitEmpty {
+		return bytesEncoder(nil), nil
+	}
+
+	if params.optional && params.defaultValue != nil && canHaveDefaultValue(v.Kind(), *params.defaultValue) {
+		defaultValue := reflect.New(v.Type()).Elem()
+		setInt(defaultValue, *params.defaultValue)
+
+		if reflect.DeepEqual(v.Interface(), defaultValue.Interface()) {
+			return bytesEncoder(nil), nil
+		}
+	}
+
+	// If no default value is given then the zero value for the type is
+	// assumed to be the default value. This isn't obviously the correct
+	// behavior, but it's what Go has traditionally done.
+	if params.optional && params.defaultValue == nil {
+		if reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface()) {
+			return bytesEncoder(nil), nil
+		}
+	}
+
+	if err := params.checkConstraints(v); err != nil {
+		return nil, err
+	}
+
+	if m, ok := marshaler(v); ok {
+		return o.makeMarshaler(m, params)
+	}
+
+	// A nil pointer is omitted above if it is optional; otherwise the
+	// pointer is marshaled as what it points to, even a zero value.
+	if v.Kind() == reflect.Pointer && v.Type() != bigIntType && v.Type() != ratType {
+		if v.IsNil() {
+			return nil, params.structuralError("nil pointer given for non-optional member")
+		}
+		inner := params
+		inner.optional = false
+		return o.makeField(v.Elem(), inner)
+	}
+
+	if params.choice && v.Kind() == reflect.Struct {
+		return o.makeChoice(v, params)
+	}
+
+	if v.Type() == rawValueType {
+		rv := v.Interface().(asn1.RawValue)
+		if len(rv.FullBytes) != 0 {
+			return bytesEncoder(rv.FullBytes), nil
+		}
+
+		t := new(taggedEncoder)
+
+		t.tag = bytesEncoder(appendTagAndLength(t.scratch[:0], tagAndLength{class: rv.Class, tag: rv.Tag, length: len(rv.Bytes), isCompound: rv.IsCompound}))
+		t.body = bytesEncoder(rv.Bytes)
+
+		return t, nil
+	}
+
+	// An untagged struct holding the RawContent it was decoded from is
+	// written out as it was, so that a signature over it still holds.
+	if v.Kind() == reflect.Struct && params.tag == nil {
+		if info := getStructInfo(v.Type()); info.rawContents {
+			if raw := v.FieldByIndex(info.fields[0].index).Bytes(); len(raw) != 0 {
+				return bytesEncoder(raw), nil
+			}
+		}
+	}
+
+	matchAny, tag, isCompound, ok := getUniversalType(v.Type())
+	if !ok || matchAny {
+		return nil, asn1.StructuralError{Msg: fmt.Sprintf("unknown Go type: %v", v.Type())}
+	}
+
+	if params.bits {
+		if v.Type() != boolSliceType {
+			return nil, asn1.StructuralError{Msg: "bits given to non-[]bool member"}
+		}
+		tag, isCompound = asn1.TagBitString, false
+	}
+
+	sequenceOf := v.Kind() == reflect.Slice && isCompound
+
+	if params.timeType != 0 && tag != asn1.TagUTCTime && !sequenceOf {
+		return nil, asn1.StructuralError{Msg: "explicit time type given to non-time member"}
+	}
+
+	if params.stringType != 0 && tag != asn1.TagPrintableString && !sequenceOf {
+		return nil, asn1.StructuralError{Msg: "explicit string type given to non-string member"}
+	}
+
+	if params.relative {
+		if v.Type() != objectIdentifierType {
+			return nil, asn1.StructuralError{Msg: "relative given to non-ObjectIdentifier member"}
+		}
+		tag = tagRelativeOID
+	}
+
+	if params.enumerated {
+		if !isIntegerKind(v.Kind()) {
+			return nil, asn1.StructuralError{Msg: "enumerated given to non-integer member"}
+		}
+		tag = asn1.TagEnum
+	}
+
+	switch tag {
+	case asn1.TagPrintableString:
+		if params.stringType == 0 {
+			// This is a string without an explicit string type. We'll use
+			// a PrintableString if the character set in the string is
+			// sufficiently limited, otherwise we'll use a UTF8String.
+			for _, r := range v.String() {
+				if r >= utf8.RuneSelf || !isPrintable(byte(r), rejectAsterisk, rejectAmpersand) {
+					if !utf8.ValidString(v.String()) {
+						return nil, errors.New("asn1: string not valid UTF-8")
+					}
+					tag = asn1.TagUTF8String
+					break
+				}
+			}
+		} else {
+			tag = params.stringType
+		}
+	case asn1.TagUTCTime:
+		t := v.Interface().(time.Time)
+		if _, ok := timeTypeLayouts[params.timeType]; ok {
+			tag = params.timeType
+		} else if params.timeType == asn1.TagGeneralizedTime || outsideUTCRange(t) {
+			tag = asn1.TagGeneralizedTime
+		}
+	}
+
+	if params.set {
+		if tag != asn1.TagSequence && tag != asn1.TagSet {
+			return nil, asn1.StructuralError{Msg: "non sequence tagged as set"}
+		}
+		tag = asn1.TagSet
+	}
+
+	// makeField can be called for a slice that should be treated as a SET
+	// but doesn't have params.set set, for instance when using a slice
+	// with the SET type name suffix. In this case getUniversalType returns
+	// asn1.TagSet, but makeBody doesn't know about that so will treat the slice
+	// as a sequence. To work around this we set params.set.
+	if tag == asn1.TagSet && !params.set {
+		params.set = true
+	}
+
+	body, err := o.makeBody(v, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.CER && (tag == asn1.TagOctetString || tag == asn1.TagBitString) && body.Len() > cerSegmentLength {
+		body = o.segmentString(body, tag)
+		isCompound = true
+	}
+
+	class := asn1.ClassUniversal
+	if t, ok := applicationTag(v.Type()); ok {
+		class, tag = asn1.ClassApplication, t
+	}
+	if params.tag != nil {
+		if params.explicit {
+			return o.tagged(params.tagClass(), *params.tag, true, o.tagged(class, tag, isCompound, body)), nil
+		}
+
+		// implicit tag.
+		class, tag = params.tagClass(), *params.tag
+	}
+
+	return o.tagged(class, tag, isCompound, body), nil
+}
+
+// tagged returns an encoder for body preceded by the given identifier and its
+// length. When encoding CER, a constructed element has an indefinite length
+// and is followed by the end-of-contents octets.
+func (o MarshalOptions) tagged(class, tag int, isCompound bool, body encoder) encoder {
+	tl := tagAndLength{class: class, tag: tag, length: body.Len(), isCompound: isCompound}
+	if o.CER && isCompound {
+		tl.isIndefinite = true
+		body = multiEncoder{body, endOfContentsEncoder}
+	}
+
+	t := new(taggedEncoder)
+	t.tag = bytesEncoder(appendTagAndLength(t.scratch[:0], tl))
+	t.body = body
+
+	return t
+}
+
+// cerSegmentLength is the largest number of contents octets CER permits in a
+// primitive OCTET STRING or BIT STRING, see X.690 section 9.2.
+const cerSegmentLength = 1000
+
+// segmentString splits the contents of an OCTET STRING or BIT STRING into the
+// primitive segments of a constructed encoding, each cerSegmentLength octets
+// long except for the last.
+func (o MarshalOptions) segmentString(body encoder, tag int) encoder {
+	b := make([]byte, body.Len())
+	body.Encode(b)
+
+	var m multiEncoder
+	if tag == asn1.TagBitString {
+		// Each segment begins with its own count of unused bits, only the
+		// last of which may be non-zero.
+		unused := b[0]
+		for b = b[1:]; len(b) > 0; {
+			n := min(len(b), cerSegmentLength-1)
+			segment := []byte{0}
+			if n == len(b) {
+				segment[0] = unused
+			}
+			m = append(m, o.tagged(asn1.ClassUniversal, tag, false, bytesEncoder(append(segment, b[:n]...))))
+			b = b[n:]
+		}
+		return m
+	}
+
+	for len(b) > 0 {
+		n := min(len(b), cerSegmentLength)
+		m = append(m, o.tagged(asn1.ClassUniversal, tag, false, bytesEncoder(b[:n])))
+		b = b[n:]
+	}
+	return m
+}
+
+// Marshal returns the ASN.1 encoding of val.
+//
+// A time.Duration is marshaled as an INTEGER number of seconds, truncating any
+// sub-second precision.
+//
+// A time.Time marshaled as a GeneralizedTime keeps any fractional seconds,
+// while UTCTime has no way to represent them. The frac tag keeps at most the
+// given number of digits of them, for peers which accept no more, and the
+// fraction is left out if they are all zero.
+//
+// An asn1.RawValue with non-empty FullBytes is written out verbatim, ignoring
+// its Class, Tag, IsCompound and Bytes fields and any tag given for the field,
+// so a value decoded into a RawValue marshals to exactly the bytes it was
+// decoded from.
+//
+// A struct whose first field is a non-empty asn1.RawContent is written out
+// as that RawContent, its other fields being ignored. If a tag is given for
+// the field only the contents of the RawContent are kept.
+//
+// The contents held by a final RawTail field with the tag "tail" are written
+// verbatim after the elements of the other fields, as are the elements held by
+// a final []asn1.RawValue field with the tag "any".
+//
+// A nil slice is marshaled as an empty SEQUENCE OF, or OCTET STRING for a
+// []byte, unless the field has the omitempty or optional tag, when it is
+// omitted. A non-nil slice of length zero is marshaled as an empty SEQUENCE OF
+// even with those tags, so that the two can be told apart.
+//
+// A [N]byte is marshaled as an OCTET STRING of N bytes. Arrays of other
+// element types are not supported.
+//
+// A pointer is marshaled as the value it points to. A nil pointer is omitted
+// if the field is optional, and is an error otherwise.
+//
+// A *big.Rat is marshaled as a REAL in decimal form. It must have an exact
+// decimal representation, which 1/3 does not, unless the precision tag is
+// given.
+//
+// The elements of a slice with the set tag, or of a slice type whose name ends
+// in SET, are sorted by their encodings as X.690 requires for a SET OF, so
+// they don't keep the order of the slice. The fields of a struct with the set
+// tag are written in the canonical order of their tags, as DER requires for a
+// SET.
+//
+// A map is marshaled as a SET OF SEQUENCE { key, value }, its entries sorted
+// into the order DER requires for a SET OF.
+//
+// The fields of an embedded struct without a tag are marshaled as elements of
+// the SEQUENCE of the struct embedding it. A field with the tag "-", or named
+// "_", is skipped.
+//
+// A Duration is marshaled as an ASN.1 DURATION.
+//
+// A KerberosTime is marshaled as a GeneralizedTime in UTC, without any
+// fraction of a second.
+//
+// The SNMP types, such as Counter32, are marshaled with their APPLICATION
+// tags.
+//
+// A struct of bool fields with the bit tag is marshaled as a BIT STRING of
+// named bits. Trailing zero bits are left out, as DER requires. A []bool with
+// the bits tag is marshaled as a BIT STRING of its elements, the first being
+// bit 0.
+//
+// A value implementing Marshaler is encoded by its MarshalBER method.
+//
+// Only one field of a CHOICE may be set, that is be other than nil, and that
+// alternative is the one marshaled. A pointer to a zero value, such as an
+// INTEGER 0, is set, as is an empty slice which isn't nil.
+//
+// In addition to the struct tags recognized by Unmarshal, the following can be
+// used:
+//
+//	ia5:         causes strings to be marshaled as ASN.1, IA5String values
+//	omitempty:   causes nil slices to be skipped
+//	printable:   causes strings to be marshaled as ASN.1, PrintableString values
+//	utf8:        causes strings to be marshaled as ASN.1, UTF8String values
+//	autostring:  causes strings to be marshaled as ASN.1, PrintableString values if they can be and UTF8String values otherwise, as they are without a string type
+//	numeric:     causes strings to be marshaled as ASN.1, NumericString values
+//	bmp:         causes strings to be marshaled as ASN.1, BMPString values
+//	universal:   causes strings to be marshaled as ASN.1, UniversalString values
+//	visible:     causes strings to be marshaled as ASN.1, VisibleString values
+//	general:     causes strings to be marshaled as ASN.1, GeneralString values
+//	t61:         causes strings to be marshaled as ASN.1, T61String values
+//	utc:         causes time.Time to be marshaled as ASN.1, UTCTime values
+//	generalized: causes time.Time to be marshaled as ASN.1, GeneralizedTime values
+//	autotime:    causes time.Time to be marshaled as ASN.1, UTCTime values for the years 1950 to 2049 and GeneralizedTime values without fractional seconds otherwise
+//	frac:x       limits the fraction of the seconds of a GeneralizedTime to x digits, 0 to 9, truncating the time
+//	timelayout:x causes time.Time to be marshaled as ASN.1, GeneralizedTime values formatted with the Go time layout x, which must have a four digit year
+//	date:        causes time.Time to be marshaled as ASN.1, DATE values
+//	timeofday:   causes time.Time to be marshaled as ASN.1, TIME-OF-DAY values
+//	datetime:    causes time.Time to be marshaled as ASN.1, DATE-TIME values
+//	relative:    causes an ObjectIdentifier to be marshaled as ASN.1, RELATIVE-OID values
+//	enumerated:  causes an integer to be marshaled as ASN.1, ENUMERATED values
+//	explicit:x:y wraps the value in explicit tags, [x] outermost and [y] innermost
+//	precision:x  rounds a *big.Rat without an exact decimal form to x decimal places
+func Marshal(val any) ([]byte, error) {
+	return MarshalOptions{}.Marshal(val)
+}
+
+// MarshalOptions configures how values are marshaled. The zero value produces
+// the same encoding as Marshal.
+type MarshalOptions struct {
+	// CER selects the Canonical Encoding Rules of X.690 section 9.
+	// Constructed values are written with an indefinite length, and OCTET
+	// STRING and BIT STRING values with contents longer than 1000 octets
+	// are split into 1000 octet segments of a constructed encoding. The
+	// encoding of primitive values and the ordering of SET OF elements are
+	// the same as for DER.
+	CER bool
+
+	// DER selects the Distinguished Encoding Rules of X.690 section 10:
+	// lengths are definite and minimal, INTEGER values minimal, a true
+	// BOOLEAN is 0xff, SET OF elements are sorted and times are in UTC.
+	// Apart from the zone of a GeneralizedTime, which is otherwise kept,
+	// this is the encoding produced when neither CER nor DER is set. It
+	// can't be combined with CER.
+	DER bool
+}
+
+// Marshal returns the ASN.1 encoding of val using the options o. See the
+// Marshal function for how Go values are mapped to ASN.1.
+func (o MarshalOptions) Marshal(val any) ([]byte, error) {
+	return o.MarshalWithParams(val, "")
+}
+
+// EncodedLen returns the length of the encoding of val, as returned by
+// Marshal, without producing it.
+func EncodedLen(val any) (int, error) {
+	return MarshalOptions{}.EncodedLen(val)
+}
+
+// EncodedLen returns the length of the encoding of val using the options o,
+// without producing it.
+func (o MarshalOptions) EncodedLen(val any) (int, error) {
+	if o.CER && o.DER {
+		return 0, errors.New("asn1: CER and DER are mutually exclusive")
+	}
+	e, err := o.makeField(reflect.ValueOf(val), fieldParameters{})
+	if err != nil {
+		return 0, err
+	}
+	return e.Len(), nil
+}
+
+// MarshalWithParams allows field parameters to be specified for the
+// top-level element. The form of the params is the same as the field tags.
+func MarshalWithParams(val any, params string) ([]byte, error) {
+	return MarshalOptions{}.MarshalWithParams(val, params)
+}
+
+// MarshalWithParams is like Marshal but allows field parameters to be
+// specified for the top-level element.
+func (o MarshalOptions) MarshalWithParams(val any, params string) ([]byte, error) {
+	if o.CER && o.DER {
+		return nil, errors.New("asn1: CER and DER are mutually exclusive")
+	}
+	return o.marshalTo(nil, val, parseFieldParameters(params))
+}
+
+// MarshalTo appends the encoding of val, as returned by Marshal, to dst and
+// returns the extended slice.
+func MarshalTo(dst []byte, val any) ([]byte, error) {
+	return MarshalOptions{}.MarshalTo(dst, val)
+}
+
+// MarshalTo appends the encoding of val using the options o to dst and
+// returns the extended slice. On error dst is returned unchanged.
+func (o MarshalOptions) MarshalTo(dst []byte, val any) ([]byte, error) {
+	if o.CER && o.DER {
+		return dst, errors.New("asn1: CER and DER are mutually exclusive")
+	}
+	return o.marshalTo(dst, val, fieldParameters{})
+}
+
+// MarshalWriteTo writes the encoding of val, as returned by Marshal, to w. It
+// returns the number of bytes written and any error encountered.
+func MarshalWriteTo(w io.Writer, val any) (int64, error) {
+	return MarshalOptions{}.MarshalWriteTo(w, val)
+}
+
+// MarshalWriteTo writes the encoding of val using the options o to w, with a
+// single call to Write. It returns the number of bytes written, which is less
+// than the length of the encoding only if there is an error: the error from
+// Write, io.ErrShortWrite if Write reported none, or an error marshaling val
+// in which case nothing is written.
+func (o MarshalOptions) MarshalWriteTo(w io.Writer, val any) (int64, error) {
+	if o.CER && o.DER {
+		return 0, errors.New("asn1: CER and DER are mutually exclusive")
+	}
+	b, err := o.marshalTo(nil, val, fieldParameters{})
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	if err == nil && n != len(b) {
+		err = io.ErrShortWrite
+	}
+	return int64(n), err
+}
 
-// Marshal wraps the asn1.Marshal function
-func Marshal(val interface{}) ([]byte, error) {
-	return asn1.Marshal(val)
+func (o MarshalOptions) marshalTo(dst []byte, val any, params fieldParameters) ([]byte, error) {
+	e, err := o.makeField(reflect.ValueOf(val), params)
+	if err != nil {
+		return dst, err
+	}
+	n := len(dst)
+	dst = slices.Grow(dst, e.Len())[:n+e.Len()]
+	e.Encode(dst[n:])
+	return dst, nil
 }
@@ -4,7 +4,17 @@ import (
 	"bytes"
 	"encoding/asn1"
 	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type marshalTest struct {
@@ -38,3 +48,1684 @@ func TestMarshal(t *testing.T) {
 		}
 	}
 }
+
+type bmpStringStruct struct {
+	S string `asn1:"bmp"`
+}
+
+func TestBMPStringRoundTrip(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string // hex encoded
+		ok  bool
+	}{
+		{"Beavis", "300e1e0c004200650061007600690073", true},
+		{"ℕ é", "30081e062115002000e9", true},
+		{"\U0001F600", "", false},
+	}
+	for i, test := range tests {
+		data, err := Marshal(bmpStringStruct{test.in})
+		if (err == nil) != test.ok {
+			t.Errorf("#%d: Incorrect error result (did fail? %v, expected: %v)", i, err == nil, test.ok)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		out, _ := hex.DecodeString(test.out)
+		if !bytes.Equal(out, data) {
+			t.Errorf("#%d got: %x want %x", i, data, out)
+		}
+		var got bmpStringStruct
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("#%d: Unmarshal failed: %s", i, err)
+		}
+		if got.S != test.in {
+			t.Errorf("#%d: got %q want %q", i, got.S, test.in)
+		}
+	}
+}
+
+func TestBMPStringInvalid(t *testing.T) {
+	tests := [][]byte{
+		// Odd length content.
+		{0x1e, 0x03, 0x00, 0x41, 0x00},
+		// Surrogate pair for U+1F600.
+		{0x1e, 0x04, 0xd8, 0x3d, 0xde, 0x00},
+	}
+	for i, test := range tests {
+		var s string
+		_, err := Unmarshal(test, &s)
+		if _, ok := err.(StructuralError); !ok {
+			t.Errorf("#%d: got %v, want StructuralError", i, err)
+		}
+	}
+}
+
+type universalStringStruct struct {
+	S string `asn1:"universal"`
+}
+
+func TestUniversalStringRoundTrip(t *testing.T) {
+	in := "a\U0001F600b"
+	data, err := Marshal(universalStringStruct{in})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("300e1c0c000000610001f60000000062")
+	if !bytes.Equal(want, data) {
+		t.Errorf("got: %x want %x", data, want)
+	}
+	var got universalStringStruct
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.S != in {
+		t.Errorf("got %q want %q", got.S, in)
+	}
+}
+
+func TestUniversalStringInvalid(t *testing.T) {
+	tests := []struct {
+		in  []byte
+		msg string
+	}{
+		{[]byte{0x1c, 0x03, 0x00, 0x00, 0x41}, "not a multiple of four"},
+		{[]byte{0x1c, 0x08, 0x00, 0x00, 0x00, 0x41, 0x00, 0x11, 0x00, 0x00}, "in octet 4"},
+	}
+	for i, test := range tests {
+		var s string
+		_, err := Unmarshal(test.in, &s)
+		if _, ok := err.(StructuralError); !ok {
+			t.Errorf("#%d: got %v, want StructuralError", i, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.msg) {
+			t.Errorf("#%d: error %q does not mention %q", i, err, test.msg)
+		}
+	}
+}
+
+type visibleStringStruct struct {
+	Name string `asn1:"visible"`
+}
+
+func TestVisibleString(t *testing.T) {
+	data, err := Marshal(visibleStringStruct{"a ~"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("30051a0361207e")
+	if !bytes.Equal(want, data) {
+		t.Errorf("got: %x want %x", data, want)
+	}
+	var got visibleStringStruct
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "a ~" {
+		t.Errorf("got %q want %q", got.Name, "a ~")
+	}
+
+	_, err = Marshal(visibleStringStruct{"tab\there"})
+	if _, ok := err.(asn1.StructuralError); !ok {
+		t.Fatalf("got %v, want StructuralError", err)
+	}
+	if !strings.Contains(err.Error(), `'\t'`) || !strings.Contains(err.Error(), "Name") {
+		t.Errorf("error %q does not name the rune and field", err)
+	}
+
+	if _, err := Unmarshal([]byte{0x30, 0x03, 0x1a, 0x01, 0x7f}, &got); err == nil {
+		t.Error("Unmarshal succeeded for invalid VisibleString")
+	}
+}
+
+type kerberosPrincipalName struct {
+	NameType   int      `asn1:"explicit,tag:0"`
+	NameString []string `asn1:"explicit,tag:1,general"`
+}
+
+func TestGeneralStringRoundTrip(t *testing.T) {
+	tests := []kerberosPrincipalName{
+		{1, []string{"user"}},
+		{2, []string{"krbtgt", "EXAMPLE.COM"}},
+		// GeneralString has no fixed character set so bytes are preserved.
+		{1, []string{"caf\xe9"}},
+	}
+	for i, test := range tests {
+		data, err := Marshal(test)
+		if err != nil {
+			t.Errorf("#%d: Marshal failed: %s", i, err)
+			continue
+		}
+		var got kerberosPrincipalName
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("#%d: Unmarshal failed: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test) {
+			t.Errorf("#%d: got %#v want %#v", i, got, test)
+		}
+	}
+
+	data, err := Marshal(tests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("300fa003020101a10830061b0475736572")
+	if !bytes.Equal(want, data) {
+		t.Errorf("got: %x want %x", data, want)
+	}
+}
+
+type t61AttributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value string `asn1:"t61"`
+}
+
+func TestT61StringRoundTrip(t *testing.T) {
+	// A Latin-1 encoded organizationName of "Müller".
+	in := t61AttributeTypeAndValue{asn1.ObjectIdentifier{2, 5, 4, 10}, "M\xfcller"}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("300d060355040a14064dfc6c6c6572")
+	if !bytes.Equal(want, data) {
+		t.Errorf("got: %x want %x", data, want)
+	}
+	var got t61AttributeTypeAndValue
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("got %#v want %#v", got, in)
+	}
+}
+
+func TestReal(t *testing.T) {
+	tests := []struct {
+		in  float64
+		out string // hex encoded
+	}{
+		{0, "0900"},
+		{1.5, "090380ff03"},
+		{-1.5, "0903c0ff03"},
+		{1, "0903800001"},
+		{1e300, ""},
+		{math.SmallestNonzeroFloat64, ""},
+		{math.Inf(1), "090140"},
+		{math.Inf(-1), "090141"},
+		{math.NaN(), "090142"},
+	}
+	for i, test := range tests {
+		data, err := Marshal(test.in)
+		if err != nil {
+			t.Errorf("#%d: Marshal failed: %s", i, err)
+			continue
+		}
+		if test.out != "" {
+			out, _ := hex.DecodeString(test.out)
+			if !bytes.Equal(out, data) {
+				t.Errorf("#%d got: %x want %x", i, data, out)
+			}
+		}
+		var got float64
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("#%d: Unmarshal failed: %s", i, err)
+			continue
+		}
+		if got != test.in && !(math.IsNaN(got) && math.IsNaN(test.in)) {
+			t.Errorf("#%d: got %v want %v", i, got, test.in)
+		}
+	}
+}
+
+func TestParseReal(t *testing.T) {
+	tests := []struct {
+		in  []byte
+		ok  bool
+		out float64
+	}{
+		// Base 8 and base 16 binary encodings, with and without scale factors.
+		{[]byte{0x90, 0x00, 0x03}, true, 3},
+		{[]byte{0x98, 0xff, 0x03}, true, 1.5},
+		{[]byte{0xa0, 0xff, 0x18}, true, 1.5},
+		// Decimal NR1, NR2 and NR3 encodings.
+		{[]byte("\x01 -42"), true, -42},
+		{[]byte("\x021,5"), true, 1.5},
+		{[]byte("\x0315E-1"), true, 1.5},
+		{[]byte{0x43}, true, math.Copysign(0, -1)},
+		// Malformed encodings.
+		{[]byte{0xb0, 0x00, 0x01}, false, 0},
+		{[]byte{0x80, 0x00}, false, 0},
+		{[]byte{0x83, 0x00}, false, 0},
+		{[]byte("\x0315E"), false, 0},
+		{[]byte("\x021.5x"), false, 0},
+		{[]byte("\x04"), false, 0},
+		{[]byte{0x44}, false, 0},
+		{[]byte{0x40, 0x00}, false, 0},
+	}
+	for i, test := range tests {
+		ret, err := parseReal(test.in)
+		if (err == nil) != test.ok {
+			t.Errorf("#%d: Incorrect error result (did fail? %v, expected: %v)", i, err == nil, test.ok)
+			continue
+		}
+		if !test.ok {
+			if _, ok := err.(asn1.StructuralError); !ok {
+				t.Errorf("#%d: got %v, want StructuralError", i, err)
+			}
+			continue
+		}
+		if ret != test.out || math.Signbit(ret) != math.Signbit(test.out) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, ret, test.out)
+		}
+	}
+}
+
+type ipAddressStruct struct {
+	IP net.IP
+}
+
+func TestIPRoundTrip(t *testing.T) {
+	tests := []struct {
+		in  net.IP
+		out string // hex encoded
+	}{
+		{net.ParseIP("192.0.2.1"), "30060404c0000201"},
+		{net.IPv4(10, 0, 0, 1).To4(), "300604040a000001"},
+		{net.ParseIP("2001:db8::1"), "3012041020010db8000000000000000000000001"},
+	}
+	for i, test := range tests {
+		data, err := Marshal(ipAddressStruct{test.in})
+		if err != nil {
+			t.Errorf("#%d: Marshal failed: %s", i, err)
+			continue
+		}
+		out, _ := hex.DecodeString(test.out)
+		if !bytes.Equal(out, data) {
+			t.Errorf("#%d got: %x want %x", i, data, out)
+		}
+		var got ipAddressStruct
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("#%d: Unmarshal failed: %s", i, err)
+			continue
+		}
+		if !got.IP.Equal(test.in) {
+			t.Errorf("#%d: got %v want %v", i, got.IP, test.in)
+		}
+	}
+
+	if _, err := Marshal(ipAddressStruct{net.IP{1, 2, 3}}); err == nil {
+		t.Error("Marshal succeeded for invalid IP")
+	}
+	var got ipAddressStruct
+	_, err := Unmarshal([]byte{0x30, 0x05, 0x04, 0x03, 1, 2, 3}, &got)
+	if _, ok := err.(StructuralError); !ok {
+		t.Errorf("got %v, want StructuralError", err)
+	}
+}
+
+type durationStruct struct {
+	Timeout time.Duration
+}
+
+func TestDurationRoundTrip(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		out  string // hex encoded
+		want time.Duration
+	}{
+		{0, "3003020100", 0},
+		{90 * time.Second, "300302015a", 90 * time.Second},
+		{-time.Second, "30030201ff", -time.Second},
+		// Sub-second precision is truncated.
+		{1500 * time.Millisecond, "3003020101", time.Second},
+	}
+	for i, test := range tests {
+		data, err := Marshal(durationStruct{test.in})
+		if err != nil {
+			t.Errorf("#%d: Marshal failed: %s", i, err)
+			continue
+		}
+		out, _ := hex.DecodeString(test.out)
+		if !bytes.Equal(out, data) {
+			t.Errorf("#%d got: %x want %x", i, data, out)
+		}
+		var got durationStruct
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("#%d: Unmarshal failed: %s", i, err)
+			continue
+		}
+		if got.Timeout != test.want {
+			t.Errorf("#%d: got %v want %v", i, got.Timeout, test.want)
+		}
+	}
+
+	// 2^40 seconds does not fit in a time.Duration.
+	var got durationStruct
+	_, err := Unmarshal([]byte{0x30, 0x08, 0x02, 0x06, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}, &got)
+	if _, ok := err.(StructuralError); !ok {
+		t.Errorf("got %v, want StructuralError", err)
+	}
+}
+
+type cerStruct struct {
+	A int
+	B []int `asn1:"set"`
+	C struct {
+		D bool
+	} `asn1:"explicit,tag:0"`
+}
+
+func TestMarshalCER(t *testing.T) {
+	data, err := MarshalOptions{CER: true}.Marshal(cerStruct{A: 1, B: []int{3, 1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("308002010131800201010201020201030000a0803080010100000000000000")
+	if !bytes.Equal(data, want) {
+		t.Errorf("got %x want %x", data, want)
+	}
+
+	var got cerStruct
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.B, []int{1, 2, 3}) {
+		t.Errorf("got %v want [1 2 3]", got.B)
+	}
+}
+
+func TestMarshalCERSegmentsStrings(t *testing.T) {
+	in := bytes.Repeat([]byte{0xab}, 2500)
+	data, err := MarshalOptions{CER: true}.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []byte
+	want = append(want, 0x24, 0x80)
+	for _, n := range []int{1000, 1000, 500} {
+		want = append(want, 0x04, 0x82, byte(n>>8), byte(n))
+		want = append(want, in[:n]...)
+	}
+	want = append(want, 0x00, 0x00)
+	if !bytes.Equal(data, want) {
+		t.Errorf("unexpected encoding of a 2500 octet OCTET STRING: %x", data[:16])
+	}
+	var got []byte
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Error("OCTET STRING did not round trip")
+	}
+
+	// Short strings keep the primitive encoding.
+	data, err = MarshalOptions{CER: true}.Marshal(in[:1000])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:4], []byte{0x04, 0x82, 0x03, 0xe8}) {
+		t.Errorf("got %x want primitive OCTET STRING", data[:4])
+	}
+
+	bs := asn1.BitString{Bytes: bytes.Repeat([]byte{0xff}, 1500), BitLength: 1500*8 - 3}
+	bs.Bytes[1499] = 0xf8
+	data, err = MarshalOptions{CER: true}.Marshal(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:7], []byte{0x23, 0x80, 0x03, 0x82, 0x03, 0xe8, 0x00}) {
+		t.Errorf("got %x want constructed BIT STRING", data[:7])
+	}
+	var gotBits asn1.BitString
+	if _, err := Unmarshal(data, &gotBits); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotBits, bs) {
+		t.Error("BIT STRING did not round trip")
+	}
+}
+
+type tbsCertificate struct {
+	Version   int `asn1:"optional,explicit,default:0,tag:0"`
+	Serial    int
+	Signature asn1.RawValue
+	Issuer    asn1.RawValue
+}
+
+type certificate struct {
+	TBS       tbsCertificate
+	Algorithm asn1.RawValue
+	Signature asn1.BitString
+}
+
+func TestRawValueFullBytesRoundTrip(t *testing.T) {
+	// The signature algorithm fields use BER forms which re-deriving their
+	// tags and lengths would change: an indefinite length and a NULL with a
+	// long form length.
+	in, _ := hex.DecodeString("303a" +
+		"3025" + "a003020102" + "020105" +
+		"3080" + "06092a864886f70d01010b" + "058100" + "0000" +
+		"3109" + "3007" + "0603550403" + "0c00" +
+		"3080" + "06092a864886f70d01010b" + "0000" +
+		"03020700")
+	var cert certificate
+	rest, err := Unmarshal(in, &cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 {
+		t.Fatalf("%d trailing bytes", len(rest))
+	}
+	data, err := Marshal(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, in) {
+		t.Errorf("got %x want %x", data, in)
+	}
+
+	// The decomposed fields are ignored when FullBytes is set.
+	rv := cert.TBS.Signature
+	rv.Tag, rv.Bytes = asn1.TagInteger, nil
+	data, err = Marshal(rv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, cert.TBS.Signature.FullBytes) {
+		t.Errorf("got %x want %x", data, cert.TBS.Signature.FullBytes)
+	}
+}
+
+// money marshals itself as a [1] IMPLICIT INTEGER number of cents.
+type money int64
+
+func (m money) MarshalBER() ([]byte, error) {
+	b, err := Marshal(int64(m))
+	if err != nil {
+		return nil, err
+	}
+	b[0] = 0x81
+	return b, nil
+}
+
+type badMarshaler struct{}
+
+func (badMarshaler) MarshalBER() ([]byte, error) { return []byte{0x02, 0x02, 0x01}, nil }
+
+func TestMarshaler(t *testing.T) {
+	tests := []struct {
+		in  any
+		out string // hex encoded
+	}{
+		{money(1050), "8102041a"},
+		{struct{ M money }{-1}, "30038101ff"},
+		{struct {
+			M money `asn1:"explicit,tag:0"`
+		}{5}, "3005a003810105"},
+		{struct {
+			M *money `asn1:"optional"`
+		}{}, "3000"},
+	}
+	for i, test := range tests {
+		data, err := Marshal(test.in)
+		if err != nil {
+			t.Errorf("#%d: Marshal failed: %s", i, err)
+			continue
+		}
+		if out, _ := hex.DecodeString(test.out); !bytes.Equal(out, data) {
+			t.Errorf("#%d: got %x want %x", i, data, out)
+		}
+	}
+
+	for i, in := range []any{
+		struct {
+			M money `asn1:"tag:0"`
+		}{5},
+		badMarshaler{},
+	} {
+		if _, err := Marshal(in); err == nil {
+			t.Errorf("#%d: Marshal succeeded", i)
+		}
+	}
+}
+
+type sizeConstrained struct {
+	Key   []byte   `asn1:"minsize:16,maxsize:16"`
+	Names []string `asn1:"maxsize:3"`
+	Label string   `asn1:"utf8,minsize:1,maxsize:4"`
+}
+
+func TestSizeConstraints(t *testing.T) {
+	key := bytes.Repeat([]byte{0x01}, 16)
+	valid := sizeConstrained{key, []string{"a", "b", "c"}, "héé!"}
+	data, err := Marshal(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got sizeConstrained
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, valid) {
+		t.Errorf("got %+v want %+v", got, valid)
+	}
+
+	// The same values without constraints, to produce invalid encodings.
+	type unconstrained struct {
+		Key   []byte
+		Names []string
+		Label string `asn1:"utf8"`
+	}
+	tests := []struct {
+		in   sizeConstrained
+		want string
+	}{
+		{sizeConstrained{key[:15], nil, "a"}, "size 15 is less than minsize:16 in field Key"},
+		{sizeConstrained{append(key, 0), nil, "a"}, "size 17 is greater than maxsize:16 in field Key"},
+		{sizeConstrained{key, []string{"a", "b", "c", "d"}, "a"}, "size 4 is greater than maxsize:3 in field Names"},
+		{sizeConstrained{key, nil, ""}, "size 0 is less than minsize:1 in field Label"},
+		{sizeConstrained{key, nil, "abcde"}, "size 5 is greater than maxsize:4 in field Label"},
+	}
+	for i, test := range tests {
+		_, err := Marshal(test.in)
+		if se, ok := err.(asn1.StructuralError); !ok || se.Msg != test.want {
+			t.Errorf("#%d: Marshal returned %v, want %q", i, err, test.want)
+		}
+
+		data, err := Marshal(unconstrained(test.in))
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		_, err = Unmarshal(data, &got)
+		if se, ok := err.(StructuralError); !ok || se.Msg != test.want {
+			t.Errorf("#%d: Unmarshal returned %v, want %q", i, err, test.want)
+		}
+	}
+}
+
+type rangeConstrained struct {
+	Version int             `asn1:"min:1,max:3"`
+	Kind    asn1.Enumerated `asn1:"min:0,max:2"`
+	Serial  *big.Int        `asn1:"min:1"`
+}
+
+func TestRangeConstraints(t *testing.T) {
+	type unconstrained struct {
+		Version int
+		Kind    asn1.Enumerated
+		Serial  *big.Int
+	}
+	tests := []struct {
+		in   rangeConstrained
+		want string // the error, if any
+	}{
+		{rangeConstrained{1, 0, big.NewInt(1)}, ""},
+		{rangeConstrained{3, 2, big.NewInt(1 << 62)}, ""},
+		{rangeConstrained{0, 0, big.NewInt(1)}, "value 0 is less than min:1 in field Version"},
+		{rangeConstrained{4, 0, big.NewInt(1)}, "value 4 is greater than max:3 in field Version"},
+		{rangeConstrained{1, 3, big.NewInt(1)}, "value 3 is greater than max:2 in field Kind"},
+		{rangeConstrained{1, 0, big.NewInt(-5)}, "value -5 is less than min:1 in field Serial"},
+	}
+	for i, test := range tests {
+		data, err := Marshal(test.in)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("#%d: Marshal failed: %s", i, err)
+				continue
+			}
+			var got rangeConstrained
+			if _, err := Unmarshal(data, &got); err != nil {
+				t.Errorf("#%d: Unmarshal failed: %s", i, err)
+			} else if !reflect.DeepEqual(got, test.in) {
+				t.Errorf("#%d: got %+v want %+v", i, got, test.in)
+			}
+			continue
+		}
+		if se, ok := err.(asn1.StructuralError); !ok || se.Msg != test.want {
+			t.Errorf("#%d: Marshal returned %v, want %q", i, err, test.want)
+		}
+
+		data, err = Marshal(unconstrained(test.in))
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		var got rangeConstrained
+		_, err = Unmarshal(data, &got)
+		if se, ok := err.(StructuralError); !ok || se.Msg != test.want {
+			t.Errorf("#%d: Unmarshal returned %v, want %q", i, err, test.want)
+		}
+	}
+}
+
+type unsignedStruct struct {
+	A uint32
+	B uint64
+	C uint8
+}
+
+func TestUnsignedIntegers(t *testing.T) {
+	tests := []struct {
+		in  unsignedStruct
+		out string // hex encoded
+	}{
+		{unsignedStruct{0, 0, 0}, "3009020100020100020100"},
+		{unsignedStruct{127, 128, 255}, "300b02017f02020080020200ff"},
+		{unsignedStruct{math.MaxUint32, math.MaxUint64, 1}, "30150205" + "00ffffffff" + "020900ffffffffffffffff" + "020101"},
+	}
+	for i, test := range tests {
+		data, err := Marshal(test.in)
+		if err != nil {
+			t.Errorf("#%d: Marshal failed: %s", i, err)
+			continue
+		}
+		if out, _ := hex.DecodeString(test.out); !bytes.Equal(out, data) {
+			t.Errorf("#%d: got %x want %x", i, data, out)
+		}
+		var got unsignedStruct
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("#%d: Unmarshal failed: %s", i, err)
+		} else if got != test.in {
+			t.Errorf("#%d: got %+v want %+v", i, got, test.in)
+		}
+	}
+
+	for i, in := range []string{
+		"0201ff",                   // negative
+		"02050100000000",           // one past math.MaxUint32
+		"020a01000000000000000000", // too large for any unsigned type
+	} {
+		data, _ := hex.DecodeString(in)
+		var got uint32
+		_, err := Unmarshal(data, &got)
+		if _, ok := err.(StructuralError); !ok {
+			t.Errorf("#%d: got %v, want StructuralError", i, err)
+		}
+	}
+}
+
+func TestMapRoundTrip(t *testing.T) {
+	in := map[string]int{"ccc": 3, "b": 2, "a": 1}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("311a" + "3006130161020101" + "3006130162020102" + "30081303636363020103")
+	if !bytes.Equal(data, want) {
+		t.Errorf("got %x want %x", data, want)
+	}
+	var got map[string]int
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("got %v want %v", got, in)
+	}
+
+	type attributes struct {
+		Name  string
+		Attrs map[string]int
+	}
+	s := attributes{"x", map[string]int{"k": 7}}
+	if data, err = Marshal(s); err != nil {
+		t.Fatal(err)
+	}
+	var gotStruct attributes
+	if _, err := Unmarshal(data, &gotStruct); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotStruct, s) {
+		t.Errorf("got %+v want %+v", gotStruct, s)
+	}
+}
+
+func TestMapDuplicateKeys(t *testing.T) {
+	in, _ := hex.DecodeString("3110" + "3006130161020101" + "3006130161020102")
+	var got map[string]int
+	if _, err := Unmarshal(in, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["a"] != 2 {
+		t.Errorf("got %v, want the last value", got)
+	}
+
+	got = nil
+	_, err := UnmarshalOptions{DER: true}.Unmarshal(in, &got)
+	if se, ok := err.(StructuralError); !ok || se.Msg != "duplicate map key" {
+		t.Errorf("got %v, want duplicate map key error", err)
+	}
+}
+
+type roundedRat struct {
+	R *big.Rat `asn1:"precision:4"`
+}
+
+func TestRealRat(t *testing.T) {
+	tests := []struct {
+		in  *big.Rat
+		out string // the decimal form
+	}{
+		{big.NewRat(3, 2), "15.E-1"},
+		{big.NewRat(100, 1), "1.E2"},
+		{big.NewRat(3, 1), "3.E+0"},
+		{big.NewRat(-1, 40), "-25.E-3"},
+		{big.NewRat(0, 1), ""},
+	}
+	for i, test := range tests {
+		data, err := Marshal(test.in)
+		if err != nil {
+			t.Errorf("#%d: Marshal failed: %s", i, err)
+			continue
+		}
+		want := []byte{0x09, 0x00}
+		if test.out != "" {
+			want = append([]byte{0x09, byte(len(test.out) + 1), 0x03}, test.out...)
+		}
+		if !bytes.Equal(data, want) {
+			t.Errorf("#%d: got %x want %x", i, data, want)
+			continue
+		}
+		var got *big.Rat
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("#%d: Unmarshal failed: %s", i, err)
+		} else if got.Cmp(test.in) != 0 {
+			t.Errorf("#%d: got %v want %v", i, got, test.in)
+		}
+	}
+
+	// 1/3 has no exact decimal form, so it's only marshaled when rounded.
+	if _, err := Marshal(big.NewRat(1, 3)); err == nil {
+		t.Error("Marshal succeeded for 1/3")
+	}
+	roundTests := []struct {
+		in, out *big.Rat
+	}{
+		{big.NewRat(1, 3), big.NewRat(3333, 10000)},
+		{big.NewRat(-2, 3), big.NewRat(-6667, 10000)},
+		{big.NewRat(1, 30000), big.NewRat(0, 1)},
+		{big.NewRat(1, 8), big.NewRat(1, 8)},
+	}
+	for i, test := range roundTests {
+		data, err := Marshal(roundedRat{test.in})
+		if err != nil {
+			t.Errorf("#%d: Marshal failed: %s", i, err)
+			continue
+		}
+		var got roundedRat
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("#%d: Unmarshal failed: %s", i, err)
+		} else if got.R.Cmp(test.out) != 0 {
+			t.Errorf("#%d: got %v want %v", i, got.R, test.out)
+		}
+	}
+}
+
+func TestParseRealRat(t *testing.T) {
+	tests := []struct {
+		in  string // hex encoded contents
+		out *big.Rat
+	}{
+		{"013132", big.NewRat(12, 1)},
+		{"022d312c3235", big.NewRat(-5, 4)},
+		{"033132352e452d33", big.NewRat(1, 8)},
+		{"80fd03", big.NewRat(3, 8)},
+		{"43", big.NewRat(0, 1)},
+	}
+	for i, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		got, err := parseRealRat(in)
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+		} else if got.Cmp(test.out) != 0 {
+			t.Errorf("#%d: got %v want %v", i, got, test.out)
+		}
+	}
+	if _, err := parseRealRat([]byte{0x40}); err == nil {
+		t.Error("parseRealRat succeeded for infinity")
+	}
+}
+
+type tagClassStruct struct {
+	Application int    `asn1:"class:1,tag:5"`
+	Context     int    `asn1:"class:2,tag:5"`
+	Explicit    int    `asn1:"explicit,class:3,tag:5"`
+	Universal   string `asn1:"class:0,tag:22"`
+}
+
+func TestTagClass(t *testing.T) {
+	in := tagClassStruct{1, 2, 3, "a"}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("300e" + "450101" + "850102" + "e503020103" + "160161")
+	if !bytes.Equal(data, want) {
+		t.Errorf("got %x want %x", data, want)
+	}
+	var got tagClassStruct
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != in {
+		t.Errorf("got %+v want %+v", got, in)
+	}
+
+	// The class of the element has to match, not just its number.
+	swapped, _ := hex.DecodeString("300e" + "850101" + "450102" + "e503020103" + "160161")
+	if _, err := Unmarshal(swapped, &got); err == nil {
+		t.Error("Unmarshal succeeded with the classes swapped")
+	}
+}
+
+type paramsStruct struct {
+	A int
+}
+
+func TestMarshalWithParams(t *testing.T) {
+	tests := []struct {
+		params string
+		out    string
+	}{
+		{"", "3003020105"},
+		{"application,tag:0", "6003020105"},
+		{"application,explicit,tag:0", "60053003020105"},
+		{"tag:1", "a103020105"},
+	}
+	for _, test := range tests {
+		data, err := MarshalWithParams(paramsStruct{5}, test.params)
+		if err != nil {
+			t.Errorf("%q: %s", test.params, err)
+			continue
+		}
+		if got := hex.EncodeToString(data); got != test.out {
+			t.Errorf("%q: got %s want %s", test.params, got, test.out)
+			continue
+		}
+		var got paramsStruct
+		if _, err := UnmarshalWithParams(data, &got, test.params); err != nil {
+			t.Errorf("%q: %s", test.params, err)
+		} else if got.A != 5 {
+			t.Errorf("%q: got %d want 5", test.params, got.A)
+		}
+	}
+}
+
+func TestMarshalSetOfSorted(t *testing.T) {
+	in := derSetOf{[]int{256, 3, -1, 1}}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The elements are ordered by their encodings, so the length octet puts
+	// -1 before 256 even though its contents octet is larger.
+	want := "300f310d" + "020101" + "020103" + "0201ff" + "02020100"
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	// The slice order isn't preserved: the elements are decoded in the
+	// order of the encoding.
+	var got derSetOf
+	if _, err := (UnmarshalOptions{DER: true}).Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.A, []int{1, 3, -1, 256}) {
+		t.Errorf("got %v want [1 3 -1 256]", got.A)
+	}
+}
+
+type generalizedTimeStruct struct {
+	T time.Time `asn1:"generalized"`
+}
+
+func TestGeneralizedTimeFraction(t *testing.T) {
+	tests := []struct {
+		in   time.Time
+		want string
+	}{
+		{time.Date(2023, 1, 4, 12, 0, 0, 0, time.UTC), "20230104120000Z"},
+		{time.Date(2023, 1, 4, 12, 0, 0, 123e6, time.UTC), "20230104120000.123Z"},
+		{time.Date(2023, 1, 4, 12, 0, 0, 1, time.UTC), "20230104120000.000000001Z"},
+		{time.Date(2023, 1, 4, 12, 0, 0, 5e8, time.FixedZone("", -90*60)), "20230104120000.5-0130"},
+	}
+	for i, test := range tests {
+		data, err := Marshal(generalizedTimeStruct{test.in})
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if got := string(data[4:]); got != test.want {
+			t.Errorf("#%d: got %q want %q", i, got, test.want)
+		}
+		var got generalizedTimeStruct
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("#%d: %s", i, err)
+		} else if !got.T.Equal(test.in) {
+			t.Errorf("#%d: got %v want %v", i, got.T, test.in)
+		}
+	}
+}
+
+func TestMarshalUTCTime(t *testing.T) {
+	tests := []struct {
+		in   time.Time
+		want string
+	}{
+		{time.Date(1991, 5, 6, 16, 45, 40, 0, time.UTC), "170d3931303530363136343534305a"},
+		// Times in other zones are converted to UTC.
+		{time.Date(1991, 5, 6, 9, 45, 40, 0, time.FixedZone("", -7*60*60)), "170d3931303530363136343534305a"},
+		// Sub-second precision is dropped.
+		{time.Date(2049, 12, 31, 23, 59, 59, 5e8, time.UTC), "170d3439313233313233353935395a"},
+		// A time in 2049 which is in 2050 UTC has to be a
+		// GeneralizedTime, which keeps its zone.
+		{time.Date(2049, 12, 31, 23, 0, 0, 0, time.FixedZone("", -2*60*60)), "181332303439313233313233303030302d30323030"},
+	}
+	for i, test := range tests {
+		data, err := Marshal(test.in)
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if got := hex.EncodeToString(data); got != test.want {
+			t.Errorf("#%d: got %s want %s", i, got, test.want)
+		}
+	}
+}
+
+type timeTypesStruct struct {
+	Date      time.Time `asn1:"date"`
+	TimeOfDay time.Time `asn1:"timeofday"`
+	DateTime  time.Time `asn1:"datetime"`
+	Implicit  time.Time `asn1:"date,tag:0"`
+}
+
+func TestTimeTypes(t *testing.T) {
+	in := timeTypesStruct{
+		Date:      time.Date(2023, 1, 4, 0, 0, 0, 0, time.UTC),
+		TimeOfDay: time.Date(0, 1, 1, 12, 30, 5, 0, time.UTC),
+		DateTime:  time.Date(2023, 1, 4, 12, 30, 5, 0, time.UTC),
+		Implicit:  time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "303a" +
+		"1f1f0a" + hex.EncodeToString([]byte("2023-01-04")) +
+		"1f2008" + hex.EncodeToString([]byte("12:30:05")) +
+		"1f2113" + hex.EncodeToString([]byte("2023-01-04T12:30:05")) +
+		"800a" + hex.EncodeToString([]byte("1999-12-31"))
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	var got timeTypesStruct
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != in {
+		t.Errorf("got %+v want %+v", got, in)
+	}
+
+	// A time.Time field takes any of the types by its universal tag.
+	var tm time.Time
+	if _, err := Unmarshal(data[2:15], &tm); err != nil {
+		t.Error(err)
+	} else if !tm.Equal(in.Date) {
+		t.Errorf("got %v want %v", tm, in.Date)
+	}
+
+	for _, s := range []string{"2023-13-04", "2023-02-30", "2023-1-04"} {
+		b := append([]byte{0x1f, 0x1f, byte(len(s))}, s...)
+		_, err := Unmarshal(b, &tm)
+		if _, ok := err.(StructuralError); !ok {
+			t.Errorf("DATE %q: got %v, want StructuralError", s, err)
+		}
+	}
+	for _, s := range []string{"25:00:00", "12:60:00"} {
+		b := append([]byte{0x1f, 0x20, byte(len(s))}, s...)
+		_, err := Unmarshal(b, &tm)
+		if _, ok := err.(StructuralError); !ok {
+			t.Errorf("TIME-OF-DAY %q: got %v, want StructuralError", s, err)
+		}
+	}
+}
+
+type validity struct {
+	NotBefore time.Time `asn1:"autotime"`
+	NotAfter  time.Time `asn1:"tag:0,autotime"`
+}
+
+func TestAutoTime(t *testing.T) {
+	in := validity{
+		NotBefore: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2050, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	// Fractional seconds are dropped from a GeneralizedTime.
+	data, err := Marshal(validity{in.NotBefore, in.NotAfter.Add(time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "3020" +
+		"170d" + hex.EncodeToString([]byte("230601120000Z")) +
+		"800f" + hex.EncodeToString([]byte("20500601120000Z"))
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	var got validity
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != in {
+		t.Errorf("got %+v want %+v", got, in)
+	}
+
+	// Either type is accepted whatever the year.
+	swapped := validity{
+		NotBefore: time.Date(2050, 6, 1, 12, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	data, _ = hex.DecodeString("3020" +
+		"180f" + hex.EncodeToString([]byte("20500601120000Z")) +
+		"800d" + hex.EncodeToString([]byte("230601120000Z")))
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != swapped {
+		t.Errorf("got %+v want %+v", got, swapped)
+	}
+
+	// Both types are in UTC, whatever the time's zone.
+	zone := time.FixedZone("", 60*60)
+	data, err = Marshal(validity{
+		NotBefore: time.Date(2023, 1, 1, 12, 0, 0, 0, zone),
+		NotAfter:  time.Date(2051, 1, 1, 12, 0, 0, 0, zone),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "3020" +
+		"170d" + hex.EncodeToString([]byte("230101110000Z")) +
+		"800f" + hex.EncodeToString([]byte("20510101110000Z"))
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+func TestFractionDigits(t *testing.T) {
+	in := time.Date(2024, 3, 5, 14, 7, 9, 123456789, time.UTC)
+	tests := []struct {
+		params string
+		t      time.Time
+		want   string
+	}{
+		{"generalized,frac:3", in, "20240305140709.123Z"},
+		{"generalized,frac:3", time.Date(2024, 3, 5, 14, 7, 9, 120456000, time.UTC), "20240305140709.12Z"},
+		{"generalized,frac:3", time.Date(2024, 3, 5, 14, 7, 9, 999999, time.UTC), "20240305140709Z"},
+		{"generalized,frac:0", in, "20240305140709Z"},
+		{"generalized,frac:9", in, "20240305140709.123456789Z"},
+		{"generalized", in, "20240305140709.123456789Z"},
+	}
+	for _, test := range tests {
+		data, err := MarshalWithParams(test.t, test.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(data[2:]); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.params, got, test.want)
+		}
+	}
+
+	// A microsecond time keeps its milliseconds.
+	micro := time.Date(2024, 3, 5, 14, 7, 9, 654321000, time.UTC)
+	data, err := MarshalWithParams(micro, "generalized,frac:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out time.Time
+	if _, err := Unmarshal(data, &out); err != nil || !out.Equal(micro.Truncate(time.Millisecond)) {
+		t.Errorf("got %v, %v, want %v", out, err, micro.Truncate(time.Millisecond))
+	}
+}
+
+type layoutTimes struct {
+	Minutes time.Time `asn1:"timelayout:200601021504"`
+	Local   time.Time `asn1:"tag:0,timelayout:20060102150405"`
+}
+
+func TestTimeLayout(t *testing.T) {
+	in := layoutTimes{
+		Minutes: time.Date(2024, 3, 5, 14, 7, 0, 0, time.UTC),
+		Local:   time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC),
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "301e" + "180c" + hex.EncodeToString([]byte("202403051407")) + "800e" + hex.EncodeToString([]byte("20240305140709"))
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	var out layoutTimes
+	if _, err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.Minutes.Equal(in.Minutes) || !out.Local.Equal(in.Local) {
+		t.Errorf("got %+v, want %+v", out, in)
+	}
+
+	// The seconds are lost with a layout lacking them.
+	in.Minutes = in.Minutes.Add(30 * time.Second)
+	data, _ = Marshal(in)
+	if _, err := Unmarshal(data, &out); err != nil || !out.Minutes.Equal(in.Minutes.Truncate(time.Minute)) {
+		t.Errorf("got %v, %v", out.Minutes, err)
+	}
+
+	// Contents not in the layout are a SyntaxError.
+	data, _ = hex.DecodeString("3011" + "180f" + hex.EncodeToString([]byte("20240305140709Z")))
+	var minutes struct {
+		T time.Time `asn1:"timelayout:200601021504"`
+	}
+	if _, err := Unmarshal(data, &minutes); err == nil {
+		t.Error("parsed contents not in the layout")
+	} else if _, ok := err.(SyntaxError); !ok {
+		t.Errorf("got %T, want SyntaxError", err)
+	}
+
+	// A layout without a four digit year is invalid.
+	if _, err := Marshal(struct {
+		T time.Time `asn1:"timelayout:0102150405"`
+	}{in.Local}); err == nil {
+		t.Error("marshaled with an invalid layout")
+	}
+}
+
+type Header struct {
+	Version int
+}
+
+type trailer struct {
+	Flags asn1.BitString
+}
+
+type embeddedStruct struct {
+	Header
+	Name string
+	trailer
+	Nested Header
+}
+
+func TestEmbeddedStruct(t *testing.T) {
+	in := embeddedStruct{Header{1}, "a", trailer{asn1.BitString{Bytes: []byte{0x80}, BitLength: 1}}, Header{2}}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fields of the embedded structs are elements of the outer
+	// SEQUENCE, while the named field is a SEQUENCE of its own.
+	want := "300f" + "020101" + "130161" + "03020780" + "3003020102"
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	var got embeddedStruct
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("got %+v want %+v", got, in)
+	}
+}
+
+type skippedFields struct {
+	A     int
+	Cache chan int `asn1:"-"`
+	note  string   `asn1:"-"`
+	_     int
+	B     string
+}
+
+type skippedChoice struct {
+	cached int     `asn1:"-"`
+	A      *int    `asn1:"tag:0"`
+	B      *string `asn1:"tag:1"`
+}
+
+func TestAutoString(t *testing.T) {
+	type names struct {
+		Plain string `asn1:"autostring"`
+		Dash  string `asn1:"autostring"`
+		Bare  string
+	}
+	in := names{"O'Brien, J. (ed.)", "Smith \u2014 Jones", "a*b"}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []int
+	for it := Elements(data[2:]); ; {
+		_, tag, _, _, _, err := it.Next()
+		if err != nil {
+			break
+		}
+		tags = append(tags, tag)
+	}
+	if want := []int{asn1.TagPrintableString, asn1.TagUTF8String, asn1.TagUTF8String}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("got tags %v, want %v", tags, want)
+	}
+	var out names
+	if _, err := Unmarshal(data, &out); err != nil || out != in {
+		t.Errorf("got %+v, %v, want %+v", out, err, in)
+	}
+}
+
+func TestMarshalEmptySlices(t *testing.T) {
+	type omitEmpty struct {
+		A []int  `asn1:"omitempty"`
+		B []byte `asn1:"omitempty,tag:0"`
+	}
+	type keepEmpty struct {
+		A []int
+		B []byte `asn1:"tag:0"`
+	}
+	tests := []struct {
+		in  any
+		out string
+	}{
+		{omitEmpty{}, "3000"},
+		{omitEmpty{A: []int{}, B: []byte{}}, "30043000" + "8000"},
+		{keepEmpty{}, "30043000" + "8000"},
+		{keepEmpty{A: []int{}, B: []byte{}}, "30043000" + "8000"},
+	}
+	for _, test := range tests {
+		data, err := Marshal(test.in)
+		if err != nil {
+			t.Errorf("%#v: %v", test.in, err)
+			continue
+		}
+		if got := hex.EncodeToString(data); got != test.out {
+			t.Errorf("%#v: got %s, want %s", test.in, got, test.out)
+		}
+	}
+}
+
+func TestSkippedFields(t *testing.T) {
+	in := skippedFields{A: 1, Cache: make(chan int), note: "in", B: "x"}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Neither the "-" fields nor the blank one are on the wire.
+	if got, want := hex.EncodeToString(data), "3006"+"020101"+"130178"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	cache := make(chan int)
+	got := skippedFields{Cache: cache, note: "out"}
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.A != 1 || got.B != "x" || got.Cache != cache || got.note != "out" {
+		t.Errorf("got %+v", got)
+	}
+
+	y := "y"
+	data, err = Marshal(struct {
+		C skippedChoice `asn1:"choice"`
+	}{skippedChoice{cached: 1, B: &y}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(data), "3003"+"810179"; got != want {
+		t.Errorf("CHOICE: got %s want %s", got, want)
+	}
+	var c struct {
+		C skippedChoice `asn1:"choice"`
+	}
+	c.C.cached = 2
+	if _, err := Unmarshal(data, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.C.cached != 2 || c.C.A != nil || c.C.B == nil || *c.C.B != "y" {
+		t.Errorf("CHOICE: got %+v", c.C)
+	}
+}
+
+type nestedExplicitStruct struct {
+	A int `asn1:"explicit:0:1"`
+	B int `asn1:"optional,explicit:2:3"`
+}
+
+func TestNestedExplicitTags(t *testing.T) {
+	tests := []struct {
+		in  nestedExplicitStruct
+		out string
+	}{
+		{nestedExplicitStruct{5, 0}, "3007" + "a005a103020105"},
+		{nestedExplicitStruct{5, 6}, "300e" + "a005a103020105" + "a205a303020106"},
+	}
+	for _, test := range tests {
+		data, err := Marshal(test.in)
+		if err != nil {
+			t.Errorf("%+v: %s", test.in, err)
+			continue
+		}
+		if got := hex.EncodeToString(data); got != test.out {
+			t.Errorf("%+v: got %s want %s", test.in, got, test.out)
+		}
+		var got nestedExplicitStruct
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("%+v: %s", test.in, err)
+		} else if got != test.in {
+			t.Errorf("got %+v want %+v", got, test.in)
+		}
+	}
+
+	for _, in := range []string{
+		// Only one of the tags.
+		"3005" + "a003020105",
+		// The tags in the wrong order.
+		"3007" + "a105a003020105",
+		// A primitive outer tag.
+		"3007" + "8005a103020105",
+		// Two elements in the outer tag.
+		"300a" + "a008a103020105020106",
+	} {
+		data, _ := hex.DecodeString(in)
+		var got nestedExplicitStruct
+		_, err := Unmarshal(data, &got)
+		if _, ok := err.(StructuralError); !ok {
+			t.Errorf("%s: got %v, want StructuralError", in, err)
+		}
+	}
+}
+
+func TestEncodedLen(t *testing.T) {
+	long := make([]byte, 1200)
+	tests := []any{
+		5,
+		"hello",
+		long,
+		cerStruct{},
+		nestedExplicitStruct{5, 6},
+		embeddedStruct{Header{1}, "a", trailer{}, Header{2}},
+		struct {
+			A []byte   `asn1:"tag:0"`
+			B [][]byte `asn1:"set"`
+			C tagClassStruct
+		}{long, [][]byte{long, {1}}, tagClassStruct{1, 2, 3, "a"}},
+	}
+	for _, o := range []MarshalOptions{{}, {CER: true}, {DER: true}} {
+		for i, test := range tests {
+			data, err := o.Marshal(test)
+			if err != nil {
+				t.Errorf("%+v #%d: %s", o, i, err)
+				continue
+			}
+			n, err := o.EncodedLen(test)
+			if err != nil {
+				t.Errorf("%+v #%d: %s", o, i, err)
+			} else if n != len(data) {
+				t.Errorf("%+v #%d: got %d want %d", o, i, n, len(data))
+			}
+		}
+	}
+
+	if _, err := EncodedLen(make(chan int)); err == nil {
+		t.Error("EncodedLen succeeded for unsupported type")
+	}
+}
+
+type setOfStrings struct {
+	Names []string `asn1:"set"`
+	Inner struct {
+		IDs []int `asn1:"set"`
+	}
+}
+
+func newSetOfStrings(n int) setOfStrings {
+	var v setOfStrings
+	for i := n; i > 0; i-- {
+		v.Names = append(v.Names, strconv.Itoa(i*7919%1000))
+		v.Inner.IDs = append(v.Inner.IDs, i*104729%10000)
+	}
+	return v
+}
+
+func TestConcurrentMarshal(t *testing.T) {
+	values := []setOfStrings{newSetOfStrings(1), newSetOfStrings(10), newSetOfStrings(100)}
+	want := make([][]byte, len(values))
+	for i, v := range values {
+		var err error
+		if want[i], err = Marshal(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				j := (g + i) % len(values)
+				got, err := Marshal(values[j])
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(got, want[j]) {
+					t.Errorf("concurrent Marshal of value %d differs", j)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func BenchmarkMarshalSetOf(b *testing.B) {
+	v := newSetOfStrings(50)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMarshalTo(t *testing.T) {
+	in := cerStruct{A: 1, B: []int{3, 2}}
+	want, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := MarshalTo(nil, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x want %x", got, want)
+	}
+
+	// Appending keeps the prefix and uses the spare capacity.
+	dst := make([]byte, 2, 64)
+	dst[0], dst[1] = 0xaa, 0xbb
+	got, err = MarshalTo(dst, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, append([]byte{0xaa, 0xbb}, want...)) {
+		t.Errorf("got %x want aabb%x", got, want)
+	}
+	if &got[0] != &dst[0] {
+		t.Error("MarshalTo didn't reuse the capacity of dst")
+	}
+
+	got, err = MarshalTo(dst, make(chan int))
+	if err == nil || !bytes.Equal(got, dst) {
+		t.Errorf("MarshalTo of an unsupported type returned %x, %v", got, err)
+	}
+}
+
+func TestMarshalWriteTo(t *testing.T) {
+	in := cerStruct{A: 1, B: []int{3, 2}}
+	want, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := MarshalWriteTo(&buf, in)
+	if err != nil || n != int64(len(want)) || !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got %x, %d, %v want %x", buf.Bytes(), n, err, want)
+	}
+
+	// shortWriter accepts half of each write without an error.
+	n, err = MarshalWriteTo(shortWriter{}, in)
+	if err != io.ErrShortWrite || n != int64(len(want)/2) {
+		t.Errorf("got %d, %v from a short write, want %d, io.ErrShortWrite", n, err, len(want)/2)
+	}
+
+	n, err = MarshalWriteTo(failingWriter{}, in)
+	if err != io.ErrClosedPipe || n != 0 {
+		t.Errorf("got %d, %v from a failed write", n, err)
+	}
+
+	buf.Reset()
+	if n, err = MarshalWriteTo(&buf, make(chan int)); err == nil || n != 0 || buf.Len() != 0 {
+		t.Errorf("MarshalWriteTo of an unsupported type wrote %d bytes, %v", n, err)
+	}
+}
+
+type benchmarkRecord struct {
+	Version  int `asn1:"optional,explicit,default:0,tag:0"`
+	Serial   *big.Int
+	Issuer   string `asn1:"utf8"`
+	Subject  string `asn1:"ia5,tag:1"`
+	NotAfter time.Time
+	Flags    asn1.BitString
+	Values   []int `asn1:"set"`
+	Inner    struct {
+		A, B int
+		C    []byte `asn1:"optional,tag:2"`
+	}
+}
+
+var benchmarkValue = benchmarkRecord{
+	Version:  2,
+	Serial:   big.NewInt(1 << 40),
+	Issuer:   "Example CA",
+	Subject:  "host.example.com",
+	NotAfter: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	Flags:    asn1.BitString{Bytes: []byte{0xa0}, BitLength: 3},
+	Values:   []int{3, 1, 2},
+}
+
+func BenchmarkMarshalStruct(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(benchmarkValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalStruct(b *testing.B) {
+	in, err := Marshal(benchmarkValue)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var v benchmarkRecord
+		if _, err := Unmarshal(in, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type concurrentRecord struct {
+	A int
+	B string `asn1:"tag:0"`
+	C []byte `asn1:"optional"`
+}
+
+// TestMarshalConcurrentFirstUse tests a struct type first used by several
+// goroutines at once, which all work out its fields; run it with -race.
+func TestMarshalConcurrentFirstUse(t *testing.T) {
+	want, err := hex.DecodeString("3008020101800378797a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			in := concurrentRecord{A: 1, B: "xyz"}
+			got, err := Marshal(in)
+			if err == nil && !bytes.Equal(got, want) {
+				err = fmt.Errorf("Marshal got %x, want %x", got, want)
+			}
+			var out concurrentRecord
+			if err == nil {
+				_, err = Unmarshal(got, &out)
+			}
+			if err == nil && !reflect.DeepEqual(out, in) {
+				err = fmt.Errorf("Unmarshal got %+v, want %+v", out, in)
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+type byteArrayFields struct {
+	Nonce [16]byte
+	Tag   [2]byte `asn1:"tag:0"`
+}
+
+func TestByteArrayFields(t *testing.T) {
+	in := byteArrayFields{Tag: [2]byte{0xab, 0xcd}}
+	for i := range in.Nonce {
+		in.Nonce[i] = byte(i)
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("30160410000102030405060708090a0b0c0d0e0f8002abcd")
+	if !bytes.Equal(data, want) {
+		t.Errorf("got %x, want %x", data, want)
+	}
+	var out byteArrayFields
+	if _, err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Errorf("got %+v, want %+v", out, in)
+	}
+
+	for i, in := range []string{
+		"3015040f000102030405060708090a0b0c0d0e8002abcd",
+		"30170411000102030405060708090a0b0c0d0e0f108002abcd",
+		"30170410000102030405060708090a0b0c0d0e0f8003abcdef",
+	} {
+		data, _ := hex.DecodeString(in)
+		var out byteArrayFields
+		_, err := Unmarshal(data, &out)
+		if _, ok := err.(StructuralError); !ok {
+			t.Errorf("#%d: got %v, want StructuralError", i, err)
+		}
+	}
+
+	if _, err := Marshal(struct{ A [2]int }{}); err == nil {
+		t.Error("Marshal of [2]int succeeded")
+	}
+}
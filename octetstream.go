@@ -0,0 +1,333 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"io"
+	"reflect"
+)
+
+// A StreamOctetString receives the contents of an OCTET STRING, which are
+// written to W as they are decoded rather than held in a []byte, so that a
+// large value needn't be in memory all at once. The segments of a constructed
+// encoding are written in turn, joining them. If W is nil the contents are
+// discarded.
+//
+// A Decoder writes the contents to W a chunk at a time as it reads them from
+// its input, when the StreamOctetString is the value decoded or a field of it,
+// or of a struct within it. Only the other elements of those structs are
+// buffered, each on its own. A StreamOctetString within a CHOICE, a SET, or a
+// struct with a RawContent, "any" or "tail" field is written to only once its
+// enclosing element has been read, as is one unmarshaled from a []byte.
+//
+// Marshal doesn't accept a StreamOctetString.
+type StreamOctetString struct {
+	W io.Writer
+}
+
+// writeOctetString writes the contents of an OCTET STRING, whose constructed
+// encoding may have been accepted, to w.
+func (d decoder) writeOctetString(w io.Writer, t tagAndLength, contents []byte) error {
+	if w == nil {
+		w = io.Discard
+	}
+	if !t.isCompound {
+		_, err := w.Write(contents)
+		return err
+	}
+	return walkConstructedString(contents, asn1.TagOctetString, d.remainingDepth(), func(segment []byte) error {
+		_, err := w.Write(segment)
+		return err
+	})
+}
+
+// streamable reports whether a Decoder streams the contents of an element
+// decoded into a value of type t: whether it is a StreamOctetString or a
+// SEQUENCE struct with a field that is streamable.
+func streamable(t reflect.Type) bool {
+	if t == streamOctetStringType {
+		return true
+	}
+	if t.Kind() != reflect.Struct || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return false
+	}
+	if _, tag, _, ok := getUniversalType(t); !ok || tag != asn1.TagSequence {
+		return false
+	}
+	info := getStructInfo(t)
+	if info.err != nil || info.namedBits || info.rawContents || info.unmatched || info.tail {
+		return false
+	}
+	for _, f := range info.fields {
+		if streamedField(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamedField reports whether the element of a struct field is streamed,
+// rather than being read and then unmarshaled.
+func streamedField(f sequenceField) bool {
+	return !f.params.choice && !f.params.set && len(f.params.outerTags) == 0 && streamable(f.typ)
+}
+
+// An octetStreamer decodes an element read by a Decoder into a value which is
+// streamable, writing the contents of its StreamOctetStrings as they are read.
+type octetStreamer struct {
+	dec   *Decoder
+	start int // dec.consumed at the start of the element
+
+	// limit is the offset of the end of the innermost element being read
+	// with a definite length, or -1 if there is none.
+	limit int
+
+	buf []byte // holds each chunk of contents written
+}
+
+// offset returns the position in the element of the next byte to be read.
+func (s *octetStreamer) offset() int {
+	return s.dec.consumed - s.start
+}
+
+// header reads the identifier and length octets of the next element, at the
+// given offset, checking them as Unmarshal would and that the element fits
+// within those being read. If first is set the input may end cleanly before
+// them, giving io.EOF.
+func (s *octetStreamer) header(at int, first bool) (header []byte, t tagAndLength, err error) {
+	if header, t, err = s.dec.readHeader(nil); err != nil {
+		if err == io.EOF && (!first || len(header) > 0) {
+			err = io.ErrUnexpectedEOF
+		}
+		return header, t, relocate(err, at)
+	}
+	if opts := s.dec.opts; opts.DER {
+		// As for a Tokenizer, the end-of-contents octets readHeader
+		// gives parseTagAndLength aren't in header.
+		if t.isIndefinite {
+			return header, t, decoder{}.locate(asn1.StructuralError{Msg: "indefinite length in DER"}, at)
+		}
+		if _, _, err = (decoder{UnmarshalOptions: opts}).parseTagAndLength(header, 0); err != nil {
+			return header, t, decoder{}.locate(err, at)
+		}
+	}
+	if s.limit >= 0 && (s.offset() > s.limit || !t.isIndefinite && t.length > s.limit-s.offset()) {
+		return header, t, decoder{}.locate(asn1.SyntaxError{Msg: "data truncated"}, at)
+	}
+	return header, t, nil
+}
+
+// contents calls fn to read the contents of an element, whose identifier and
+// length octets have been read, nested depth levels deep, with the offset at
+// which they start. They are checked to lie within the element.
+func (s *octetStreamer) contents(t tagAndLength, depth, at int, fn func(contentStart int) error) error {
+	if (decoder{UnmarshalOptions: s.dec.opts, depth: depth}).remainingDepth() < 0 {
+		return decoder{}.locate(errNestingTooDeep, at)
+	}
+	limit := s.limit
+	if !t.isIndefinite {
+		s.limit = s.offset() + t.length
+	}
+	err := fn(s.offset())
+	s.limit = limit
+	return err
+}
+
+// field decodes the next element into v, which is streamable and nested depth
+// levels deep, as parseField would. If v is optional and the element has
+// another tag it is left to be read again, and v is unchanged.
+func (s *octetStreamer) field(v reflect.Value, params fieldParameters, depth int) error {
+	at := s.offset()
+	header, t, err := s.header(at, depth == 0)
+	if err != nil {
+		return err
+	}
+
+	class, tag, compound := asn1.ClassUniversal, asn1.TagSequence, true
+	stream := v.Type() == streamOctetStringType
+	if stream {
+		tag, compound = asn1.TagOctetString, false
+	}
+	if params.tag != nil {
+		class, tag = params.tagClass(), *params.tag
+		compound = compound || params.explicit
+	}
+	// The constructed encoding of an OCTET STRING is accepted, except
+	// with DER.
+	constructedString := stream && !params.explicit && !s.dec.opts.DER
+	if t.class != class || t.tag != tag || t.isCompound != compound && !constructedString {
+		if params.optional {
+			s.dec.unread(header)
+			return nil
+		}
+		return decoder{}.locate(params.structuralError("tags don't match"), at)
+	}
+
+	return s.contents(t, depth+1, at, func(contentStart int) error {
+		switch {
+		case params.explicit:
+			ended, err := s.ended(t, contentStart)
+			if err != nil {
+				return err
+			}
+			if ended {
+				return decoder{}.locate(asn1.StructuralError{Msg: "explicit tag has no child"}, at)
+			}
+			inner := params
+			inner.explicit, inner.tag, inner.optional = false, nil, false
+			if err := s.field(v, inner, depth+1); err != nil {
+				return err
+			}
+			return s.finish(t, contentStart, depth+1)
+		case stream:
+			w, _ := v.Field(0).Interface().(io.Writer)
+			if w == nil {
+				w = io.Discard
+			}
+			return s.octets(w, t, depth+1)
+		}
+		return s.sequence(v, t, contentStart, depth+1)
+	})
+}
+
+// octets writes the contents of an OCTET STRING, whose identifier and length
+// octets have been read, to w.
+func (s *octetStreamer) octets(w io.Writer, t tagAndLength, depth int) error {
+	if !t.isCompound {
+		return s.copy(w, t.length)
+	}
+	contentStart := s.offset()
+	for {
+		ended, err := s.ended(t, contentStart)
+		if err != nil || ended {
+			return err
+		}
+		at := s.offset()
+		_, segment, err := s.header(at, false)
+		if err != nil {
+			return err
+		}
+		if segment.class != asn1.ClassUniversal || segment.tag != asn1.TagOctetString {
+			return decoder{}.locate(asn1.StructuralError{Msg: "constructed string contains invalid segment"}, at)
+		}
+		err = s.contents(segment, depth+1, at, func(int) error {
+			return s.octets(w, segment, depth+1)
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// copy reads n contents octets from the input and writes them to w, a chunk
+// at a time.
+func (s *octetStreamer) copy(w io.Writer, n int) error {
+	for n > 0 {
+		chunk := min(n, maxReadChunk)
+		b, err := s.dec.read(s.buf[:0], chunk)
+		s.buf = b[:0]
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// sequence decodes the contents of a SEQUENCE, whose identifier and length
+// octets have been read, into the struct v. The elements of the fields which
+// aren't streamed are read and then unmarshaled one at a time.
+func (s *octetStreamer) sequence(v reflect.Value, t tagAndLength, contentStart, depth int) error {
+	ended := false
+	for _, f := range getStructInfo(v.Type()).fields {
+		fv := v.FieldByIndex(f.index)
+		at := s.offset()
+		if !ended {
+			var err error
+			if ended, err = s.ended(t, contentStart); err != nil {
+				return err
+			}
+		}
+		var err error
+		switch {
+		case ended:
+			if !setDefaultValue(fv, f.params) && !f.extension {
+				err = decoder{}.locate(asn1.SyntaxError{Msg: "sequence truncated"}, at)
+			}
+		case streamedField(f):
+			err = s.field(fv, f.params, depth)
+		default:
+			err = s.buffered(fv, f.params, depth)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if ended && t.isIndefinite {
+		return nil
+	}
+	return s.finish(t, contentStart, depth)
+}
+
+// buffered reads the next element and unmarshals it into v, which is nested
+// depth levels deep. If v is optional and the element has another tag it is
+// left to be read again.
+func (s *octetStreamer) buffered(v reflect.Value, params fieldParameters, depth int) error {
+	at := s.offset()
+	d := decoder{UnmarshalOptions: s.dec.opts, base: at, depth: depth}
+	b, err := s.dec.readElement(nil, false, d.remainingDepth())
+	if err != nil {
+		return relocate(err, at)
+	}
+	if s.limit >= 0 && s.offset() > s.limit {
+		return decoder{}.locate(asn1.SyntaxError{Msg: "data truncated"}, at)
+	}
+	offset, err := d.parseField(v, b, 0, params)
+	if err != nil {
+		return err
+	}
+	s.dec.unread(b[offset:])
+	return nil
+}
+
+// ended reports whether the contents of an element, whose identifier and
+// length octets were read, have all been read. If its length is indefinite
+// that consumes its end-of-contents octets.
+func (s *octetStreamer) ended(t tagAndLength, contentStart int) (bool, error) {
+	if !t.isIndefinite {
+		return s.offset()-contentStart >= t.length, nil
+	}
+	header, next, err := s.header(s.offset(), false)
+	if err != nil {
+		return false, err
+	}
+	if next.class == asn1.ClassUniversal && next.tag == 0 && !next.isCompound && next.length == 0 {
+		return true, nil
+	}
+	s.dec.unread(header)
+	return false, nil
+}
+
+// finish reads and discards the rest of the contents of an element, whose
+// identifier and length octets were read, including its end-of-contents
+// octets. Elements after those decoded are skipped, as Unmarshal skips them.
+func (s *octetStreamer) finish(t tagAndLength, contentStart, depth int) error {
+	if !t.isIndefinite {
+		return s.dec.discard(t.length - (s.offset() - contentStart))
+	}
+	for {
+		at := s.offset()
+		eoc, err := s.dec.skipElement(false, (decoder{UnmarshalOptions: s.dec.opts, depth: depth}).remainingDepth())
+		if err != nil {
+			return relocate(err, at)
+		}
+		if eoc {
+			return nil
+		}
+	}
+}
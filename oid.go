@@ -0,0 +1,195 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// A BigObjectIdentifier is an OBJECT IDENTIFIER whose arcs may be too large
+// for an int, as some registration authorities assign.
+type BigObjectIdentifier []*big.Int
+
+// Equal reports whether oi and other represent the same identifier.
+func (oi BigObjectIdentifier) Equal(other BigObjectIdentifier) bool {
+	if len(oi) != len(other) {
+		return false
+	}
+	for i := range oi {
+		if oi[i].Cmp(other[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the arcs of oi separated by dots.
+func (oi BigObjectIdentifier) String() string {
+	var s strings.Builder
+	for i, v := range oi {
+		if i > 0 {
+			s.WriteByte('.')
+		}
+		s.WriteString(v.String())
+	}
+	return s.String()
+}
+
+// ParseObjectIdentifier parses an OBJECT IDENTIFIER in dotted decimal form,
+// such as "1.2.840.113549.1.1.11". It must have at least two arcs, none
+// negative, the first being 0, 1 or 2 and, unless the first is 2, the second
+// less than 40, so that it can be encoded.
+func ParseObjectIdentifier(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("asn1: invalid object identifier %q", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		// Atoi alone would accept a sign.
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return nil, fmt.Errorf("asn1: invalid object identifier %q", s)
+		}
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("asn1: object identifier %q has an arc out of range", s)
+		}
+		oid[i] = v
+	}
+	if oid[0] > 2 || oid[0] < 2 && oid[1] >= 40 {
+		return nil, fmt.Errorf("asn1: object identifier %q has its first arcs out of range", s)
+	}
+	return oid, nil
+}
+
+// A RelativeOID is an ASN.1 RELATIVE-OID, the arcs of an object identifier
+// which follow some other object identifier known from the context. Unlike an
+// ObjectIdentifier its first two arcs are not combined.
+type RelativeOID []int
+
+// String returns the arcs of oid separated by dots.
+func (oid RelativeOID) String() string {
+	return asn1.ObjectIdentifier(oid).String()
+}
+
+func makeRelativeOID(oid []int) (e encoder, err error) {
+	if len(oid) == 0 {
+		return nil, asn1.StructuralError{Msg: "empty RELATIVE-OID"}
+	}
+	var dst []byte
+	for _, v := range oid {
+		if v < 0 {
+			return nil, asn1.StructuralError{Msg: "invalid RELATIVE-OID"}
+		}
+		dst = appendBase128Int(dst, int64(v))
+	}
+	return bytesEncoder(dst), nil
+}
+
+// parseRelativeOID parses a RELATIVE-OID from the given byte slice.
+func parseRelativeOID(bytes []byte) (s []int, err error) {
+	if len(bytes) == 0 {
+		err = asn1.SyntaxError{Msg: "zero length RELATIVE-OID"}
+		return
+	}
+	for offset := 0; offset < len(bytes); {
+		var v int
+		if v, offset, err = _parseBase128Int(bytes, offset); err != nil {
+			return nil, err
+		}
+		s = append(s, v)
+	}
+	return
+}
+
+var (
+	big2  = big.NewInt(2)
+	big40 = big.NewInt(40)
+	big80 = big.NewInt(80)
+)
+
+func makeBigObjectIdentifier(oid BigObjectIdentifier) (e encoder, err error) {
+	if len(oid) < 2 {
+		return nil, asn1.StructuralError{Msg: "invalid object identifier"}
+	}
+	for _, v := range oid {
+		if v == nil || v.Sign() < 0 {
+			return nil, asn1.StructuralError{Msg: "invalid object identifier"}
+		}
+	}
+	if oid[0].Cmp(big2) > 0 || oid[0].Cmp(big2) < 0 && oid[1].Cmp(big40) >= 0 {
+		return nil, asn1.StructuralError{Msg: "invalid object identifier"}
+	}
+
+	// The first two arcs are combined as for an ObjectIdentifier.
+	first := new(big.Int).Mul(oid[0], big40)
+	first.Add(first, oid[1])
+	dst := appendBase128BigInt(nil, first)
+	for _, v := range oid[2:] {
+		dst = appendBase128BigInt(dst, v)
+	}
+	return bytesEncoder(dst), nil
+}
+
+// appendBase128BigInt appends the base 128 encoding of n, which must not be
+// negative, to dst.
+func appendBase128BigInt(dst []byte, n *big.Int) []byte {
+	l := max((n.BitLen()+6)/7, 1)
+	var b big.Int
+	for i := l - 1; i >= 0; i-- {
+		o := byte(b.Rsh(n, uint(i*7)).Uint64()) & 0x7f
+		if i != 0 {
+			o |= 0x80
+		}
+		dst = append(dst, o)
+	}
+	return dst
+}
+
+// parseBigObjectIdentifier parses an OBJECT IDENTIFIER with arcs of any size
+// from the given byte slice.
+func parseBigObjectIdentifier(bytes []byte) (s BigObjectIdentifier, err error) {
+	if len(bytes) == 0 {
+		err = asn1.SyntaxError{Msg: "zero length OBJECT IDENTIFIER"}
+		return
+	}
+
+	v, offset, err := parseBase128BigInt(bytes, 0)
+	if err != nil {
+		return
+	}
+	// See parseObjectIdentifier for how the first two arcs are packed.
+	if v.Cmp(big80) < 0 {
+		q, r := new(big.Int).QuoRem(v, big40, new(big.Int))
+		s = append(s, q, r)
+	} else {
+		s = append(s, big.NewInt(2), v.Sub(v, big80))
+	}
+
+	for offset < len(bytes) {
+		if v, offset, err = parseBase128BigInt(bytes, offset); err != nil {
+			return nil, err
+		}
+		s = append(s, v)
+	}
+	return
+}
+
+// parseBase128BigInt parses a base 128 integer of any size from the given
+// offset into a byte slice, returning it and the new offset.
+func parseBase128BigInt(bytes []byte, initOffset int) (ret *big.Int, offset int, err error) {
+	ret = new(big.Int)
+	for offset = initOffset; offset < len(bytes); {
+		b := bytes[offset]
+		offset++
+		ret.Lsh(ret, 7)
+		ret.Or(ret, big.NewInt(int64(b&0x7f)))
+		if b&0x80 == 0 {
+			return
+		}
+	}
+	err = asn1.SyntaxError{Msg: "truncated base 128 integer"}
+	return
+}
@@ -0,0 +1,133 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+type bigOIDStruct struct {
+	OID BigObjectIdentifier
+}
+
+func TestBigObjectIdentifier(t *testing.T) {
+	// 2^64 + 1 doesn't fit in an int64.
+	large, _ := new(big.Int).SetString("18446744073709551617", 10)
+	tests := []struct {
+		in  BigObjectIdentifier
+		out string
+	}{
+		{BigObjectIdentifier{big.NewInt(1), big.NewInt(2), big.NewInt(840), big.NewInt(113549)}, "06062a864886f70d"},
+		{BigObjectIdentifier{big.NewInt(1), big.NewInt(3), large}, "060b2b" + "82808080808080808001"},
+		{BigObjectIdentifier{big.NewInt(2), large}, "060a" + "82808080808080808051"},
+	}
+	for _, test := range tests {
+		data, err := Marshal(bigOIDStruct{test.in})
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err)
+			continue
+		}
+		if got := hex.EncodeToString(data[2:]); got != test.out {
+			t.Errorf("%s: got %s want %s", test.in, got, test.out)
+		}
+		var got bigOIDStruct
+		if _, err := Unmarshal(data, &got); err != nil {
+			t.Errorf("%s: %s", test.in, err)
+		} else if !got.OID.Equal(test.in) {
+			t.Errorf("got %s want %s", got.OID, test.in)
+		}
+	}
+
+	// An ObjectIdentifier can't hold the large arc.
+	data, _ := hex.DecodeString(tests[1].out)
+	var oid asn1.ObjectIdentifier
+	_, err := Unmarshal(data, &oid)
+	if _, ok := err.(StructuralError); !ok {
+		t.Errorf("got %v, want StructuralError", err)
+	}
+
+	for _, in := range []BigObjectIdentifier{
+		{big.NewInt(1)},
+		{big.NewInt(3), big.NewInt(1)},
+		{big.NewInt(1), big.NewInt(40)},
+		{big.NewInt(1), big.NewInt(2), big.NewInt(-1)},
+		{big.NewInt(1), nil},
+	} {
+		if _, err := Marshal(in); err == nil {
+			t.Errorf("%v: invalid OID marshaled", in)
+		}
+	}
+}
+
+type relativeOIDStruct struct {
+	Rel RelativeOID
+	OID asn1.ObjectIdentifier `asn1:"relative"`
+}
+
+func TestRelativeOID(t *testing.T) {
+	in := relativeOIDStruct{RelativeOID{8571, 3, 2}, asn1.ObjectIdentifier{1, 200}}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unlike an OBJECT IDENTIFIER the first two arcs are encoded apart.
+	want := "300b" + "0d04c27b0302" + "0d03018148"
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	var got relativeOIDStruct
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !asn1.ObjectIdentifier(got.Rel).Equal(asn1.ObjectIdentifier(in.Rel)) || !got.OID.Equal(in.OID) {
+		t.Errorf("got %+v want %+v", got, in)
+	}
+	if s := in.Rel.String(); s != "8571.3.2" {
+		t.Errorf("got %s want 8571.3.2", s)
+	}
+
+	// A RELATIVE-OID doesn't match an OBJECT IDENTIFIER field, nor the
+	// other way round.
+	var oid asn1.ObjectIdentifier
+	_, err = Unmarshal(data[2:8], &oid)
+	if _, ok := err.(StructuralError); !ok {
+		t.Errorf("got %v, want StructuralError", err)
+	}
+	var rel RelativeOID
+	_, err = Unmarshal([]byte{0x06, 0x02, 0x2a, 0x03}, &rel)
+	if _, ok := err.(StructuralError); !ok {
+		t.Errorf("got %v, want StructuralError", err)
+	}
+
+	if _, err := Marshal(RelativeOID{}); err == nil {
+		t.Error("empty RELATIVE-OID marshaled")
+	}
+}
+
+func TestParseObjectIdentifier(t *testing.T) {
+	for _, test := range []struct {
+		in  string
+		out asn1.ObjectIdentifier
+	}{
+		{"1.2.840.113549.1.1.11", asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}},
+		{"2.5.4.3", asn1.ObjectIdentifier{2, 5, 4, 3}},
+		{"0.39", asn1.ObjectIdentifier{0, 39}},
+		{"2.999.1", asn1.ObjectIdentifier{2, 999, 1}},
+	} {
+		oid, err := ParseObjectIdentifier(test.in)
+		if err != nil {
+			t.Errorf("%q: %s", test.in, err)
+		} else if !oid.Equal(test.out) {
+			t.Errorf("%q: got %v", test.in, oid)
+		}
+	}
+
+	for _, in := range []string{
+		"", "1", "3.5", "1.40", "0.40", "1..2", ".1.2", "1.2.", "1.-2", "1.+2", "1.2a", "1.2.99999999999999999999",
+	} {
+		if oid, err := ParseObjectIdentifier(in); err == nil {
+			t.Errorf("%q: got %v, want an error", in, oid)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"sync"
+)
+
+// oidNames maps the dotted form of each registered OBJECT IDENTIFIER to its
+// name.
+var oidNames = struct {
+	sync.RWMutex
+	m map[string]string
+}{m: map[string]string{
+	"1.2.840.113549.1.1.1":   "rsaEncryption",
+	"1.2.840.113549.1.1.5":   "sha1WithRSAEncryption",
+	"1.2.840.113549.1.1.11":  "sha256WithRSAEncryption",
+	"1.2.840.113549.1.1.12":  "sha384WithRSAEncryption",
+	"1.2.840.113549.1.1.13":  "sha512WithRSAEncryption",
+	"1.2.840.113549.1.7.1":   "data",
+	"1.2.840.113549.1.7.2":   "signedData",
+	"1.2.840.113549.1.9.1":   "emailAddress",
+	"1.2.840.10045.2.1":      "ecPublicKey",
+	"1.2.840.10045.4.3.2":    "ecdsa-with-SHA256",
+	"1.2.840.10045.4.3.3":    "ecdsa-with-SHA384",
+	"1.3.101.112":            "Ed25519",
+	"2.16.840.1.101.3.4.2.1": "sha256",
+	"2.5.4.3":                "commonName",
+	"2.5.4.6":                "countryName",
+	"2.5.4.7":                "localityName",
+	"2.5.4.8":                "stateOrProvinceName",
+	"2.5.4.10":               "organizationName",
+	"2.5.4.11":               "organizationalUnitName",
+	"2.5.29.14":              "subjectKeyIdentifier",
+	"2.5.29.15":              "keyUsage",
+	"2.5.29.17":              "subjectAltName",
+	"2.5.29.19":              "basicConstraints",
+	"2.5.29.35":              "authorityKeyIdentifier",
+	"2.5.29.37":              "extKeyUsage",
+}}
+
+// RegisterOID records name as the name of oid, which Dump then shows. A
+// later registration of the same oid replaces the name. Some well-known
+// algorithm and X.509 attribute identifiers are registered already.
+// RegisterOID may be called from several goroutines at once.
+func RegisterOID(oid asn1.ObjectIdentifier, name string) {
+	oidNames.Lock()
+	defer oidNames.Unlock()
+	oidNames.m[oid.String()] = name
+}
+
+// LookupOID returns the name registered for oid, and whether there is one.
+func LookupOID(oid asn1.ObjectIdentifier) (name string, ok bool) {
+	oidNames.RLock()
+	defer oidNames.RUnlock()
+	name, ok = oidNames.m[oid.String()]
+	return
+}
@@ -0,0 +1,47 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRegisterOID(t *testing.T) {
+	if name, ok := LookupOID(asn1.ObjectIdentifier{2, 5, 4, 3}); !ok || name != "commonName" {
+		t.Errorf("got %q, %t for commonName", name, ok)
+	}
+
+	oid := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 1}
+	if name, ok := LookupOID(oid); ok {
+		t.Fatalf("got %q for an unregistered OID", name)
+	}
+	RegisterOID(oid, "exampleAttribute")
+	if name, ok := LookupOID(oid); !ok || name != "exampleAttribute" {
+		t.Errorf("got %q, %t after registering", name, ok)
+	}
+	RegisterOID(oid, "exampleAttribute2")
+	if name, _ := LookupOID(oid); name != "exampleAttribute2" {
+		t.Errorf("got %q after registering again", name)
+	}
+}
+
+func TestRegisterOIDConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			oid := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 2, i}
+			RegisterOID(oid, fmt.Sprint("concurrent", i))
+			LookupOID(asn1.ObjectIdentifier{2, 5, 4, 3})
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 8; i++ {
+		oid := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 2, i}
+		if name, _ := LookupOID(oid); name != fmt.Sprint("concurrent", i) {
+			t.Errorf("%s: got %q", oid, name)
+		}
+	}
+}
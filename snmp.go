@@ -0,0 +1,67 @@
+package ber
+
+import (
+	"net"
+	"reflect"
+)
+
+// The application wide types of SNMP, from RFC 2578 section 2. Each is
+// marshaled with its APPLICATION tag, unless the field has an implicit tag
+// of its own, and an explicit tag wraps it.
+type (
+	// An IPAddress is an SNMP IpAddress, [APPLICATION 0] IMPLICIT OCTET
+	// STRING (SIZE (4)).
+	IPAddress [4]byte
+
+	// A Counter32 is an SNMP Counter32, [APPLICATION 1] IMPLICIT INTEGER
+	// (0..4294967295).
+	Counter32 uint32
+
+	// A Gauge32 is an SNMP Gauge32, [APPLICATION 2] IMPLICIT INTEGER
+	// (0..4294967295).
+	Gauge32 uint32
+
+	// TimeTicks is an SNMP TimeTicks, [APPLICATION 3] IMPLICIT INTEGER
+	// (0..4294967295), a time in hundredths of a second.
+	TimeTicks uint32
+
+	// Opaque is an SNMP Opaque, [APPLICATION 4] IMPLICIT OCTET STRING,
+	// usually holding the BER encoding of another value.
+	Opaque []byte
+
+	// A Counter64 is an SNMP Counter64, [APPLICATION 6] IMPLICIT INTEGER
+	// (0..18446744073709551615).
+	Counter64 uint64
+)
+
+// String returns a in dotted decimal form.
+func (a IPAddress) String() string {
+	return net.IP(a[:]).String()
+}
+
+var (
+	opaqueType    = reflect.TypeOf(Opaque{})
+	counter32Type = reflect.TypeOf(Counter32(0))
+	gauge32Type   = reflect.TypeOf(Gauge32(0))
+	timeTicksType = reflect.TypeOf(TimeTicks(0))
+	counter64Type = reflect.TypeOf(Counter64(0))
+)
+
+// applicationTag returns the APPLICATION tag number of the SNMP type t.
+func applicationTag(t reflect.Type) (tag int, ok bool) {
+	switch t {
+	case ipAddressType:
+		return 0, true
+	case counter32Type:
+		return 1, true
+	case gauge32Type:
+		return 2, true
+	case timeTicksType:
+		return 3, true
+	case opaqueType:
+		return 4, true
+	case counter64Type:
+		return 6, true
+	}
+	return 0, false
+}
@@ -0,0 +1,95 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+)
+
+var snmpTests = []struct {
+	in  any
+	out string
+}{
+	{IPAddress{192, 0, 2, 1}, "4004c0000201"},
+	{Counter32(math.MaxUint32), "410500ffffffff"},
+	{Gauge32(1), "420101"},
+	{TimeTicks(360000), "4303057e40"},
+	{Opaque{0x05, 0x00}, "44020500"},
+	{Counter64(math.MaxUint64), "460900ffffffffffffffff"},
+}
+
+func TestSNMPTypes(t *testing.T) {
+	for i, test := range snmpTests {
+		out, err := Marshal(test.in)
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if got := hex.EncodeToString(out); got != test.out {
+			t.Errorf("#%d: got %s want %s", i, got, test.out)
+		}
+		if class := int(out[0] >> 6); class != asn1.ClassApplication {
+			t.Errorf("#%d: got class %d, want APPLICATION", i, class)
+		}
+
+		got := reflect.New(reflect.TypeOf(test.in))
+		if _, err := Unmarshal(out, got.Interface()); err != nil {
+			t.Errorf("#%d: %s", i, err)
+		} else if !reflect.DeepEqual(got.Elem().Interface(), test.in) {
+			t.Errorf("#%d: got %v want %v", i, got.Elem(), test.in)
+		}
+
+		// The universal tag of the underlying type doesn't match.
+		_, tag, _, _ := getUniversalType(reflect.TypeOf(test.in))
+		universal := append([]byte{byte(tag)}, out[1:]...)
+		if _, err := Unmarshal(universal, got.Interface()); err == nil {
+			t.Errorf("#%d: universal tag accepted", i)
+		}
+	}
+}
+
+type varBind struct {
+	Name  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+func TestSNMPVarBind(t *testing.T) {
+	// sysUpTime.0 = TimeTicks 360000
+	in, _ := hex.DecodeString("300f" + "06082b06010201010300" + "4303057e40")
+	var vb varBind
+	if _, err := Unmarshal(in, &vb); err != nil {
+		t.Fatal(err)
+	}
+	var ticks TimeTicks
+	if _, err := Unmarshal(vb.Value.FullBytes, &ticks); err != nil {
+		t.Fatal(err)
+	}
+	if ticks != 360000 {
+		t.Errorf("got %d want 360000", ticks)
+	}
+
+	type tagged struct {
+		Uptime   TimeTicks `asn1:"explicit,tag:0"`
+		Counter  Counter32 `asn1:"tag:1"`
+		Optional Gauge32   `asn1:"optional"`
+		Counters []Counter64
+	}
+	v := tagged{Uptime: 1, Counter: 2, Counters: []Counter64{3, 4}}
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "3010" + "a003430101" + "810102" + "3006460103460104"
+	if got := hex.EncodeToString(out); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	var got tagged
+	if _, err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("got %+v want %+v", got, v)
+	}
+}
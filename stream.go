@@ -0,0 +1,412 @@
+package ber
+
+import (
+	"context"
+	"encoding/asn1"
+	"io"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// maxReadChunk bounds how much is allocated ahead of reading the contents of
+// an element, so a corrupt length can't force a huge allocation.
+const maxReadChunk = 64 << 10
+
+// A Decoder reads and decodes BER elements from an input stream.
+type Decoder struct {
+	r    io.Reader
+	opts UnmarshalOptions
+
+	// buf holds bytes of the next element already read from r: its
+	// identifier and length octets read by PeekTag, or what was read of
+	// it before DecodeContext was cancelled.
+	buf []byte
+
+	// ctx is the context of a call to DecodeContext, checked between
+	// reads from r.
+	ctx context.Context
+
+	// consumed counts the bytes taken from the input, less those put
+	// back in buf, to give the position of a streamed element.
+	consumed int
+}
+
+// NewDecoder returns a new decoder that reads from r.
+//
+// The decoder reads no more from r than the element being decoded, so r is
+// left positioned after it.
+func NewDecoder(r io.Reader) *Decoder {
+	return UnmarshalOptions{}.NewDecoder(r)
+}
+
+// NewDecoder returns a new decoder that reads from r and decodes with the
+// options o.
+func (o UnmarshalOptions) NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, opts: o}
+}
+
+// Decode reads the next element from its input and stores it in the value
+// pointed to by v, as Unmarshal does.
+//
+// If the element is larger than the MaxSize option allows, or an element
+// within it larger than MaxElementSize does, Decode returns a StructuralError
+// without reading the rest of it.
+//
+// Decode returns io.EOF if the input ends before the next element starts and
+// io.ErrUnexpectedEOF if it ends within the element. The Offset of a
+// StructuralError or SyntaxError is relative to the start of the element.
+//
+// The contents of an OCTET STRING decoded into a StreamOctetString are written
+// to it as they are read, so that the element needn't be held in memory, as
+// described there. MaxSize and MaxElementSize don't limit those contents,
+// and an error may come after some of them have been written.
+func (dec *Decoder) Decode(v any) error {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && !rv.IsNil() && streamable(rv.Elem().Type()) {
+		s := octetStreamer{dec: dec, start: dec.consumed, limit: -1}
+		return s.field(rv.Elem(), fieldParameters{}, 0)
+	}
+	b, err := dec.readElement(nil, true, decoder{UnmarshalOptions: dec.opts}.remainingDepth())
+	if err != nil {
+		if dec.ctx != nil && err == dec.ctx.Err() {
+			dec.unread(b)
+		}
+		return err
+	}
+	_, err = dec.opts.Unmarshal(b, v)
+	return err
+}
+
+// UnmarshalFrom reads one element from r and stores it in the value pointed
+// to by v, as Unmarshal does. See UnmarshalOptions.UnmarshalFrom.
+func UnmarshalFrom(r io.Reader, v any) error {
+	return UnmarshalOptions{}.UnmarshalFrom(r, v)
+}
+
+// UnmarshalFrom reads one element from r, as a Decoder with the options o
+// does, and stores it in the value pointed to by v. r must end after the
+// element: if there is more data, UnmarshalFrom returns a SyntaxError giving
+// the offset at which it starts. It returns io.EOF if r is empty and
+// io.ErrUnexpectedEOF if it ends within the element.
+func (o UnmarshalOptions) UnmarshalFrom(r io.Reader, v any) error {
+	dec := o.NewDecoder(r)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	var b [1]byte
+	switch _, err := io.ReadFull(r, b[:]); err {
+	case nil:
+		return SyntaxError{Msg: "trailing data", Offset: dec.consumed}
+	case io.EOF:
+		return nil
+	default:
+		return err
+	}
+}
+
+// DecodeContext is like Decode, but gives up reading the element and returns
+// ctx.Err() once ctx is done. If the input has a SetReadDeadline method, as a
+// net.Conn does, a read blocked when ctx is done is interrupted by setting a
+// deadline in the past, and the deadline is then cleared. Otherwise ctx is
+// only checked between reads.
+//
+// What was read of the element before ctx was done is kept, so the next call
+// to Decode or DecodeContext resumes reading the same element, unless the
+// contents of a StreamOctetString were being streamed.
+func (dec *Decoder) DecodeContext(ctx context.Context, v any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r, ok := dec.r.(interface{ SetReadDeadline(time.Time) error }); ok {
+		interrupted := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			r.SetReadDeadline(time.Unix(1, 0))
+			close(interrupted)
+		})
+		defer func() {
+			if !stop() {
+				<-interrupted
+				r.SetReadDeadline(time.Time{})
+			}
+		}()
+	}
+	dec.ctx = ctx
+	defer func() { dec.ctx = nil }()
+	return dec.Decode(v)
+}
+
+// PeekTag returns the class, tag number and whether the next element is
+// constructed, without consuming it: the next call to Decode decodes it all.
+// PeekTag reads the identifier and length octets of the element from the
+// input, but no more, so that the caller can choose what to decode it into,
+// as for the alternatives of a CHOICE.
+//
+// PeekTag returns io.EOF if the input ends before the next element starts and
+// io.ErrUnexpectedEOF if it ends within its identifier or length.
+func (dec *Decoder) PeekTag() (class, tag int, compound bool, err error) {
+	// The header is read as Decode would, and then put back.
+	b, t, err := dec.readHeader(nil)
+	dec.unread(b)
+	if err != nil {
+		if err == io.EOF && len(b) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, false, err
+	}
+	return t.class, t.tag, t.isCompound, nil
+}
+
+// Skip reads the next element from its input and discards it, without
+// decoding it or holding it in memory.
+//
+// Skip returns io.EOF if the input ends before the next element starts and
+// io.ErrUnexpectedEOF if it ends within the element.
+func (dec *Decoder) Skip() error {
+	_, err := dec.skipElement(true, decoder{UnmarshalOptions: dec.opts}.remainingDepth())
+	return err
+}
+
+// skipElement reads and discards the next element, as readElement reads it,
+// reporting whether it was end-of-contents octets.
+func (dec *Decoder) skipElement(first bool, maxDepth int) (eoc bool, err error) {
+	var scratch [16]byte
+	header, t, err := dec.readHeader(scratch[:0])
+	if err != nil {
+		if err == io.EOF && (!first || len(header) > 0) {
+			err = io.ErrUnexpectedEOF
+		}
+		return false, err
+	}
+
+	if t.isIndefinite {
+		if maxDepth <= 0 {
+			return false, errNestingTooDeep
+		}
+		for {
+			if eoc, err = dec.skipElement(false, maxDepth-1); err != nil || eoc {
+				return false, err
+			}
+		}
+	}
+
+	return len(header) == 2 && header[0] == 0x00 && header[1] == 0x00, dec.discard(t.length)
+}
+
+// discard reads and discards n bytes of the input.
+func (dec *Decoder) discard(n int) error {
+	buffered := min(n, len(dec.buf))
+	dec.buf = dec.buf[buffered:]
+	m, err := io.CopyN(io.Discard, dec.r, int64(n-buffered))
+	dec.consumed += buffered + int(m)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readElement appends the next element read from the input, including the
+// elements it contains if its length is indefinite, to dst. If first is set
+// the input may end cleanly before the element, giving io.EOF. Elements of
+// indefinite length may be nested to at most maxDepth levels.
+func (dec *Decoder) readElement(dst []byte, first bool, maxDepth int) ([]byte, error) {
+	start := len(dst)
+	dst, t, err := dec.readHeader(dst)
+	if err != nil {
+		if err == io.EOF && (!first || len(dst) > start) {
+			err = io.ErrUnexpectedEOF
+		}
+		return dst, err
+	}
+	if dec.opts.MaxSize > 0 && t.length > dec.opts.MaxSize-len(dst) {
+		return dst, decoder{}.locate(asn1.StructuralError{Msg: "element too large"}, start)
+	}
+	if dec.opts.MaxElementSize > 0 && !t.isCompound && t.length > dec.opts.MaxElementSize {
+		return dst, decoder{}.locate(asn1.StructuralError{Msg: "element too large"}, start)
+	}
+
+	if t.isIndefinite {
+		if maxDepth <= 0 {
+			return dst, errNestingTooDeep
+		}
+		for {
+			childStart := len(dst)
+			if dst, err = dec.readElement(dst, false, maxDepth-1); err != nil {
+				return dst, err
+			}
+			if len(dst)-childStart == 2 && dst[childStart] == 0x00 && dst[childStart+1] == 0x00 {
+				return dst, nil
+			}
+		}
+	}
+
+	return dec.readContents(dst, t.length)
+}
+
+// readContents appends the n contents octets of an element read from the
+// input to dst, a chunk at a time so that no more is allocated than has been
+// read.
+func (dec *Decoder) readContents(dst []byte, n int) ([]byte, error) {
+	for n > 0 {
+		chunk := min(n, maxReadChunk)
+		var err error
+		if dst, err = dec.read(dst, chunk); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return dst, err
+		}
+		n -= chunk
+	}
+	return dst, nil
+}
+
+// readHeader appends the identifier and length octets of the next element
+// read from the input to dst, returning them parsed.
+func (dec *Decoder) readHeader(dst []byte) (_ []byte, t tagAndLength, err error) {
+	start := len(dst)
+	if dst, err = dec.read(dst, 1); err != nil {
+		return dst, t, err
+	}
+	if dst[start]&0x1f == 0x1f {
+		// The tag number follows in base 128, the final octet having its
+		// top bit clear.
+		for {
+			if dst, err = dec.read(dst, 1); err != nil {
+				return dst, t, err
+			}
+			if dst[len(dst)-1]&0x80 == 0 {
+				break
+			}
+			if len(dst)-start > 5 {
+				return dst, t, asn1.StructuralError{Msg: "base 128 integer too large"}
+			}
+		}
+	}
+
+	if dst, err = dec.read(dst, 1); err != nil {
+		return dst, t, err
+	}
+	indefinite := false
+	switch l := dst[len(dst)-1]; {
+	case l == 0x80:
+		indefinite = true
+	case l == 0xff:
+		// Rather than reading 127 octets of length first.
+		return dst, t, decoder{}.locate(asn1.StructuralError{Msg: "reserved length octet"}, 0)
+	case l&0x80 != 0:
+		if dst, err = dec.read(dst, int(l&0x7f)); err != nil {
+			return dst, t, err
+		}
+	}
+
+	header := dst[start:]
+	if indefinite {
+		// parseTagAndLength looks for the end-of-contents octets of an
+		// indefinite length, so give it an empty element.
+		header = append(slices.Clip(header), 0x00, 0x00)
+	}
+	if t, _, err = parseTagAndLength(header, 0); err != nil {
+		err = decoder{}.locate(err, 0)
+	}
+	return dst, t, err
+}
+
+// read appends n bytes read from the input to dst, starting with any already
+// in buf. It returns io.EOF only if no bytes were read.
+func (dec *Decoder) read(dst []byte, n int) ([]byte, error) {
+	buffered := min(n, len(dec.buf))
+	dst = append(dst, dec.buf[:buffered]...)
+	dec.buf = dec.buf[buffered:]
+	dec.consumed += buffered
+	if n -= buffered; n == 0 {
+		return dst, nil
+	}
+
+	dst = slices.Grow(dst, n)
+	m, err := dec.readFull(dst[len(dst) : len(dst)+n])
+	dec.consumed += m
+	if err == io.EOF && buffered > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return dst[:len(dst)+m], err
+}
+
+// unread puts b, read from the input, back to be read again.
+func (dec *Decoder) unread(b []byte) {
+	dec.buf = append(b, dec.buf...)
+	dec.consumed -= len(b)
+}
+
+// readFull reads len(b) bytes from the input into b, as io.ReadFull does,
+// returning ctx.Err() instead if the context of DecodeContext is done before
+// or during a read.
+func (dec *Decoder) readFull(b []byte) (n int, err error) {
+	if dec.ctx == nil {
+		return io.ReadFull(dec.r, b)
+	}
+	for n < len(b) && err == nil {
+		if err = dec.ctx.Err(); err != nil {
+			return n, err
+		}
+		var m int
+		m, err = dec.r.Read(b[n:])
+		n += m
+	}
+	switch {
+	case n == len(b):
+		err = nil
+	case dec.ctx.Err() != nil:
+		// The error is likely from the deadline DecodeContext set.
+		err = dec.ctx.Err()
+	case err == io.EOF && n > 0:
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// relocate adds offset to the Offset of err, a StructuralError or SyntaxError
+// located relative to the start of an element which is offset bytes into
+// another. An unlocated error is located at offset.
+func relocate(err error, offset int) error {
+	switch e := err.(type) {
+	case StructuralError:
+		e.Offset += offset
+		return e
+	case SyntaxError:
+		e.Offset += offset
+		return e
+	}
+	return decoder{}.locate(err, offset)
+}
+
+// An Encoder writes BER elements to an output stream.
+type Encoder struct {
+	w   io.Writer
+	err error
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the encoding of v, as returned by Marshal, to the output.
+//
+// Each element is written with a single call to Write. Once a write has failed
+// the output may hold part of an element, so Encode returns that error from
+// then on.
+func (enc *Encoder) Encode(v any) error {
+	if enc.err != nil {
+		return enc.err
+	}
+	b, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	n, err := enc.w.Write(b)
+	if err == nil && n != len(b) {
+		err = io.ErrShortWrite
+	}
+	enc.err = err
+	return err
+}
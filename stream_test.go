@@ -0,0 +1,513 @@
+package ber
+
+import (
+	"bytes"
+	"context"
+	"encoding/asn1"
+	"encoding/hex"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type streamMessage struct {
+	ID   int
+	Name string
+}
+
+func TestDecoder(t *testing.T) {
+	in, _ := hex.DecodeString("30080201011303616263" +
+		"308180020102137b" + string(bytes.Repeat([]byte("78"), 123)) +
+		"30800201031300" + "0000")
+	want := []streamMessage{{1, "abc"}, {2, string(bytes.Repeat([]byte("x"), 123))}, {3, ""}}
+
+	// Feed the decoder a byte at a time.
+	r, w := io.Pipe()
+	go func() {
+		for _, b := range in {
+			w.Write([]byte{b})
+		}
+		w.Close()
+	}()
+
+	dec := NewDecoder(r)
+	for i, want := range want {
+		var got streamMessage
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		if got != want {
+			t.Errorf("#%d: got %+v want %+v", i, got, want)
+		}
+	}
+	var m streamMessage
+	if err := dec.Decode(&m); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestDecoderLeavesReaderAfterElement(t *testing.T) {
+	in, _ := hex.DecodeString("3080020101130000000201")
+	r := bytes.NewReader(in)
+	var m streamMessage
+	if err := NewDecoder(r).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 2 {
+		t.Errorf("%d bytes left unread, want 2", r.Len())
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	tests := []string{
+		"30",
+		"3082",
+		"300802010113036162",
+		"3080020101",
+		"30800201011300",
+		"1f",
+	}
+	for i, test := range tests {
+		in, _ := hex.DecodeString(test)
+		var m streamMessage
+		if err := NewDecoder(bytes.NewReader(in)).Decode(&m); err != io.ErrUnexpectedEOF {
+			t.Errorf("#%d: got %v, want io.ErrUnexpectedEOF", i, err)
+		}
+	}
+
+	var m streamMessage
+	err := NewDecoder(bytes.NewReader([]byte{0x02, 0x80})).Decode(&m)
+	if _, ok := err.(SyntaxError); !ok {
+		t.Errorf("got %v, want SyntaxError", err)
+	}
+
+	// A malformed length is an error as soon as it is read.
+	err = NewDecoder(bytes.NewReader([]byte{0x30, 0xff})).Decode(&m)
+	if want := (StructuralError{Msg: "reserved length octet"}); err != want {
+		t.Errorf("got %v, want %v", err, want)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	msgs := []any{
+		streamMessage{1, "abc"},
+		streamMessage{2, string(bytes.Repeat([]byte("x"), 300))},
+		streamMessage{3, ""},
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for i, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+	}
+	if err := enc.Encode(make(chan int)); err == nil {
+		t.Error("Encode succeeded for unsupported type")
+	}
+
+	dec := NewDecoder(&buf)
+	for i, want := range msgs {
+		var got streamMessage
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		if got != want {
+			t.Errorf("#%d: got %+v want %+v", i, got, want)
+		}
+	}
+	var m streamMessage
+	if err := dec.Decode(&m); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) { return len(p) / 2, nil }
+
+func TestEncoderShortWrite(t *testing.T) {
+	enc := NewEncoder(shortWriter{})
+	if err := enc.Encode(streamMessage{1, "abc"}); err != io.ErrShortWrite {
+		t.Errorf("got %v, want io.ErrShortWrite", err)
+	}
+	if err := enc.Encode(streamMessage{2, "abc"}); err != io.ErrShortWrite {
+		t.Errorf("got %v, want io.ErrShortWrite after failed write", err)
+	}
+}
+
+// repeatReader reads b over and over.
+type repeatReader struct {
+	b      []byte
+	offset int
+}
+
+func (r *repeatReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		c := copy(p[n:], r.b[r.offset:])
+		n += c
+		r.offset = (r.offset + c) % len(r.b)
+	}
+	return n, nil
+}
+
+func TestDecoderMaxSize(t *testing.T) {
+	opts := UnmarshalOptions{MaxSize: 1024}
+
+	// A length too large is rejected before any of the contents is read.
+	r := bytes.NewReader([]byte{0x04, 0x84, 0x7f, 0xff, 0xff, 0xff, 0x01})
+	var b []byte
+	err := opts.NewDecoder(r).Decode(&b)
+	if se, ok := err.(StructuralError); !ok || se.Msg != "element too large" {
+		t.Errorf("got %v, want element too large", err)
+	}
+	if r.Len() != 1 {
+		t.Errorf("%d bytes left unread, want 1", r.Len())
+	}
+
+	// As is an endless run of elements within an indefinite length.
+	var rv asn1.RawValue
+	endless := io.MultiReader(bytes.NewReader([]byte{0x30, 0x80}), &repeatReader{b: []byte{0x05, 0x00}})
+	err = opts.NewDecoder(endless).Decode(&rv)
+	if se, ok := err.(StructuralError); !ok || se.Msg != "element too large" {
+		t.Errorf("got %v, want element too large", err)
+	}
+
+	// An element of exactly MaxSize bytes is accepted.
+	in := append([]byte{0x04, 0x82, 0x03, 0xfc}, make([]byte, 1020)...)
+	if err := opts.NewDecoder(bytes.NewReader(in)).Decode(&b); err != nil {
+		t.Error(err)
+	} else if len(b) != 1020 {
+		t.Errorf("got %d bytes, want 1020", len(b))
+	}
+}
+
+func TestDecoderPeekTag(t *testing.T) {
+	// An INTEGER, an OCTET STRING, a SEQUENCE of indefinite length and
+	// a [200] INTEGER.
+	in, _ := hex.DecodeString("020105" + "04026869" + "30800201011300" + "0000" + "9f81480107")
+
+	// Feed the decoder a byte at a time, so that the identifier and length
+	// are read across several reads.
+	r, w := io.Pipe()
+	go func() {
+		for _, b := range in {
+			w.Write([]byte{b})
+		}
+		w.Close()
+	}()
+
+	dec := NewDecoder(r)
+	var got []any
+	for {
+		class, tag, compound, err := dec.PeekTag()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Peeking again doesn't read any further.
+		if c, tg, cp, err := dec.PeekTag(); c != class || tg != tag || cp != compound || err != nil {
+			t.Fatalf("peeked %d %d %t %v, then %d %d %t", class, tag, compound, err, c, tg, cp)
+		}
+
+		switch {
+		case class == asn1.ClassUniversal && tag == asn1.TagInteger:
+			var i int
+			err = dec.Decode(&i)
+			got = append(got, i)
+		case class == asn1.ClassUniversal && tag == asn1.TagOctetString:
+			var b []byte
+			err = dec.Decode(&b)
+			got = append(got, string(b))
+		case class == asn1.ClassUniversal && tag == asn1.TagSequence && compound:
+			var m streamMessage
+			err = dec.Decode(&m)
+			got = append(got, m)
+		case class == asn1.ClassContextSpecific && tag == 200:
+			var rv asn1.RawValue
+			err = dec.Decode(&rv)
+			got = append(got, rv.Bytes)
+		default:
+			t.Fatalf("unexpected tag %d %d", class, tag)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []any{5, "hi", streamMessage{1, ""}, []byte{7}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestDecoderPeekTagTruncated(t *testing.T) {
+	for i, test := range []string{"1f", "1f81", "30", "3082", "308201"} {
+		in, _ := hex.DecodeString(test)
+		dec := NewDecoder(bytes.NewReader(in))
+		if _, _, _, err := dec.PeekTag(); err != io.ErrUnexpectedEOF {
+			t.Errorf("#%d: got %v, want io.ErrUnexpectedEOF", i, err)
+		}
+	}
+
+	// Having peeked, a truncated element is still reported as such.
+	dec := NewDecoder(bytes.NewReader([]byte{0x04, 0x02, 0x61}))
+	if _, _, _, err := dec.PeekTag(); err != nil {
+		t.Fatal(err)
+	}
+	var b []byte
+	if err := dec.Decode(&b); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestDecoderSkip(t *testing.T) {
+	// A SEQUENCE of indefinite length holding another, a long OCTET STRING
+	// and then the message wanted.
+	in, _ := hex.DecodeString("3080" + "3080020101" + "0000" + "1300" + "0000" +
+		"048200c8" + strings.Repeat("00", 200) +
+		"30080201011303616263")
+	r := bytes.NewReader(in)
+	dec := NewDecoder(r)
+	for i := 0; i < 2; i++ {
+		if err := dec.Skip(); err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+	}
+
+	var m streamMessage
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m != (streamMessage{1, "abc"}) {
+		t.Errorf("got %+v", m)
+	}
+	if err := dec.Skip(); err != io.EOF {
+		t.Errorf("got %v at the end, want io.EOF", err)
+	}
+
+	// Skipping a peeked element works too.
+	dec = NewDecoder(bytes.NewReader([]byte{0x02, 0x01, 0x05, 0x02, 0x01, 0x06}))
+	if _, _, _, err := dec.PeekTag(); err != nil {
+		t.Fatal(err)
+	}
+	var i int
+	if err := dec.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&i); err != nil || i != 6 {
+		t.Errorf("got %d, %v after skipping a peeked element, want 6", i, err)
+	}
+
+	for i, test := range []string{"30", "3080", "3080020101", "308030800000", "0402", "040261"} {
+		in, _ := hex.DecodeString(test)
+		if err := NewDecoder(bytes.NewReader(in)).Skip(); err != io.ErrUnexpectedEOF {
+			t.Errorf("#%d: got %v, want io.ErrUnexpectedEOF", i, err)
+		}
+	}
+}
+
+func TestDecoderDecodeContext(t *testing.T) {
+	r, w := io.Pipe()
+	dec := NewDecoder(r)
+	ctx, cancel := context.WithCancel(context.Background())
+	var m streamMessage
+	errc := make(chan error, 1)
+	go func() {
+		errc <- dec.DecodeContext(ctx, &m)
+	}()
+
+	// The header has been read when Write returns. The decoder notices
+	// the cancellation before its next read, or once a read blocked
+	// then returns.
+	w.Write([]byte{0x30, 0x07})
+	cancel()
+	go func() {
+		w.Write([]byte{0x02})
+		w.Write([]byte{0x01, 0x01, 0x13, 0x02, 0x61, 0x62})
+	}()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	// Decoding resumes with the bytes already read.
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if want := (streamMessage{1, "ab"}); m != want {
+		t.Errorf("got %+v want %+v", m, want)
+	}
+
+	if err := dec.DecodeContext(ctx, &m); err != context.Canceled {
+		t.Errorf("got %v for a done context, want context.Canceled", err)
+	}
+}
+
+func TestDecoderDecodeContextDeadline(t *testing.T) {
+	// A net.Conn has deadlines, so a blocked read is interrupted.
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	dec := NewDecoder(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	var m streamMessage
+	go func() {
+		errc <- dec.DecodeContext(ctx, &m)
+	}()
+	server.Write([]byte{0x30})
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	// The deadline is cleared for the next read.
+	go server.Write([]byte{0x07, 0x02, 0x01, 0x01, 0x13, 0x02, 0x61, 0x62})
+	if err := dec.DecodeContext(context.Background(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if want := (streamMessage{1, "ab"}); m != want {
+		t.Errorf("got %+v want %+v", m, want)
+	}
+}
+
+type streamUpload struct {
+	ID      int
+	Payload StreamOctetString
+	Note    string `asn1:"optional,tag:0"`
+}
+
+// chunkWriter records the largest write made to it.
+type chunkWriter struct {
+	bytes.Buffer
+	largest int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.largest = max(w.largest, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestDecoderStreamOctetString(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 1<<18)
+	in, err := Marshal(struct {
+		ID      int
+		Payload []byte
+	}{7, payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, _ := hex.DecodeString("30080201011303616263")
+	in = append(in, next...)
+
+	// MaxSize would reject the element if it were read into memory.
+	dec := UnmarshalOptions{MaxSize: 1024}.NewDecoder(bytes.NewReader(in))
+	var sink chunkWriter
+	got := streamUpload{Payload: StreamOctetString{W: &sink}}
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 7 || !bytes.Equal(sink.Bytes(), payload) {
+		t.Errorf("got ID %d and %d bytes, want 7 and %d", got.ID, sink.Len(), len(payload))
+	}
+	if sink.largest > maxReadChunk {
+		t.Errorf("wrote %d bytes at once, want at most %d", sink.largest, maxReadChunk)
+	}
+	var m streamMessage
+	if err := dec.Decode(&m); err != nil || m != (streamMessage{1, "abc"}) {
+		t.Errorf("got %+v, %v after the streamed element", m, err)
+	}
+}
+
+func TestStreamOctetStringConstructed(t *testing.T) {
+	tests := []struct {
+		in      string
+		id      int
+		payload string
+		note    string
+	}{
+		// Nested segments within an indefinite length, and an extension
+		// element which is skipped.
+		{"3080" + "020101" + "2480" + "04026162" + "2403040163" + "0000" + "80026869" + "0500" + "0000", 1, "abc", "hi"},
+		{"3013020102240a0403616263240304016480026869", 2, "abcd", "hi"},
+		{"300702010304026162", 3, "ab", ""},
+	}
+	for _, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		var streamed, unmarshaled bytes.Buffer
+		got := streamUpload{Payload: StreamOctetString{W: &streamed}}
+		if err := NewDecoder(bytes.NewReader(in)).Decode(&got); err != nil {
+			t.Errorf("%s: %v", test.in, err)
+			continue
+		}
+		if got.ID != test.id || streamed.String() != test.payload || got.Note != test.note {
+			t.Errorf("%s: got %d, %q, %q from a Decoder", test.in, got.ID, streamed.String(), got.Note)
+		}
+
+		got = streamUpload{Payload: StreamOctetString{W: &unmarshaled}}
+		if _, err := Unmarshal(in, &got); err != nil {
+			t.Errorf("%s: %v", test.in, err)
+			continue
+		}
+		if got.ID != test.id || unmarshaled.String() != test.payload || got.Note != test.note {
+			t.Errorf("%s: got %d, %q, %q from Unmarshal", test.in, got.ID, unmarshaled.String(), got.Note)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestStreamOctetStringErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		opts UnmarshalOptions
+		w    io.Writer
+		want error
+	}{
+		{"3007020101040561626364", UnmarshalOptions{}, nil, SyntaxError{Msg: "data truncated", Offset: 5}},
+		{"3080020101248002010500000000", UnmarshalOptions{}, nil, StructuralError{Msg: "constructed string contains invalid segment", Offset: 7}},
+		{"3008020101240304016100", UnmarshalOptions{DER: true}, nil, StructuralError{Msg: "tags don't match in field Payload", Offset: 5}},
+		{"308002010104026162", UnmarshalOptions{}, nil, io.ErrUnexpectedEOF},
+		{"300702010104026162", UnmarshalOptions{}, failingWriter{}, io.ErrClosedPipe},
+	}
+	for _, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		got := streamUpload{Payload: StreamOctetString{W: test.w}}
+		if err := test.opts.NewDecoder(bytes.NewReader(in)).Decode(&got); err != test.want {
+			t.Errorf("%s: got %v, want %v", test.in, err, test.want)
+		}
+	}
+
+	if _, err := Marshal(streamUpload{}); err == nil {
+		t.Error("marshaled a StreamOctetString")
+	}
+}
+
+func TestUnmarshalFrom(t *testing.T) {
+	tests := []struct {
+		in   string
+		want error
+	}{
+		{"30080201011303616263", nil},
+		{"30800201011303616263" + "0000", nil},
+		{"3008020101130361626305", SyntaxError{Msg: "trailing data", Offset: 10}},
+		{"", io.EOF},
+		{"3008020101", io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		var m streamMessage
+		err := UnmarshalFrom(bytes.NewReader(in), &m)
+		if err != test.want {
+			t.Errorf("%s: got %v, want %v", test.in, err, test.want)
+			continue
+		}
+		if err == nil && m != (streamMessage{1, "abc"}) {
+			t.Errorf("%s: got %+v", test.in, m)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"io"
+)
+
+// A Token is a StartElement, a Primitive or an EndElement.
+type Token any
+
+// A StartElement begins a constructed element. The tokens of the elements it
+// contains follow, and then an EndElement.
+type StartElement struct {
+	Class, Tag int
+	Indefinite bool // whether its length is indefinite
+}
+
+// A Primitive is a primitive element with its contents octets.
+type Primitive struct {
+	Class, Tag int
+	Content    []byte
+}
+
+// An EndElement ends the constructed element begun by the StartElement with
+// the same class and tag. For an element with an indefinite length it stands
+// for the end-of-contents octets.
+type EndElement struct {
+	Class, Tag int
+}
+
+// A Tokenizer reads BER elements from an input stream as a sequence of
+// tokens, without decoding them into Go values, so that large structures can
+// be processed one element at a time.
+type Tokenizer struct {
+	dec *Decoder
+
+	// open holds the constructed elements begun but not yet ended,
+	// innermost last.
+	open []openElement
+
+	// offset is the number of bytes read, to give the position of errors.
+	offset int
+	err    error
+}
+
+// An openElement is a constructed element being tokenized.
+type openElement struct {
+	class, tag int
+	indefinite bool
+	remaining  int // unread bytes of its contents, if its length is definite
+}
+
+// NewTokenizer returns a new tokenizer that reads from r.
+func NewTokenizer(r io.Reader) *Tokenizer {
+	return UnmarshalOptions{}.NewTokenizer(r)
+}
+
+// NewTokenizer returns a new tokenizer that reads from r, with the options o.
+// Of these MaxDepth limits how deeply elements may be nested, MaxElementSize
+// how long the contents of a Primitive may be, and DER rules out indefinite
+// lengths and non-minimal identifier and length octets.
+func (o UnmarshalOptions) NewTokenizer(r io.Reader) *Tokenizer {
+	return &Tokenizer{dec: o.NewDecoder(r)}
+}
+
+// Token returns the next token. The Content of a Primitive is allocated for
+// it, so may be kept.
+//
+// Token returns io.EOF if the input ends cleanly after a complete element,
+// and io.ErrUnexpectedEOF if it ends within one. If the input is not well
+// formed the error is a SyntaxError or StructuralError giving the offset at
+// which the problem was found. Once Token has returned an error it returns the
+// same error from then on.
+func (tk *Tokenizer) Token() (Token, error) {
+	if tk.err != nil {
+		return nil, tk.err
+	}
+	tok, err := tk.next()
+	if err != nil {
+		// The errors of readHeader are already located, relative to the
+		// start of the element.
+		err = relocate(err, tk.offset)
+		tk.err = err
+	}
+	return tok, err
+}
+
+// next returns the next token, or an error located relative to tk.offset.
+func (tk *Tokenizer) next() (Token, error) {
+	if n := len(tk.open); n > 0 && !tk.open[n-1].indefinite && tk.open[n-1].remaining == 0 {
+		return tk.end(), nil
+	}
+
+	var scratch [16]byte
+	header, t, err := tk.dec.readHeader(scratch[:0])
+	if err != nil {
+		if err == io.EOF && (len(tk.open) > 0 || len(header) > 0) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err := tk.consume(len(header)); err != nil {
+		return nil, err
+	}
+	opts := tk.dec.opts
+
+	if t.class == asn1.ClassUniversal && t.tag == 0 && !t.isCompound && t.length == 0 {
+		if n := len(tk.open); n == 0 || !tk.open[n-1].indefinite {
+			return nil, asn1.StructuralError{Msg: "unexpected end-of-contents octets"}
+		}
+		tk.offset += len(header)
+		return tk.end(), nil
+	}
+	if opts.DER {
+		// The end-of-contents octets readHeader gives parseTagAndLength
+		// for an indefinite length aren't in header.
+		if t.isIndefinite {
+			return nil, asn1.StructuralError{Msg: "indefinite length in DER"}
+		}
+		if _, _, err := (decoder{UnmarshalOptions: opts}).parseTagAndLength(header, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.isCompound {
+		if len(tk.open) >= (decoder{UnmarshalOptions: opts}).remainingDepth() {
+			return nil, errNestingTooDeep
+		}
+		if !t.isIndefinite && !tk.fits(t.length) {
+			return nil, asn1.SyntaxError{Msg: "data truncated"}
+		}
+		tk.offset += len(header)
+		tk.open = append(tk.open, openElement{t.class, t.tag, t.isIndefinite, t.length})
+		return StartElement{Class: t.class, Tag: t.tag, Indefinite: t.isIndefinite}, nil
+	}
+
+	if opts.MaxElementSize > 0 && t.length > opts.MaxElementSize {
+		return nil, asn1.StructuralError{Msg: "element too large"}
+	}
+	if err := tk.consume(t.length); err != nil {
+		return nil, err
+	}
+	content, err := tk.dec.readContents(nil, t.length)
+	if err != nil {
+		return nil, err
+	}
+	tk.offset += len(header) + t.length
+	return Primitive{Class: t.class, Tag: t.tag, Content: content}, nil
+}
+
+// fits reports whether n more bytes fit within the open elements with a
+// definite length.
+func (tk *Tokenizer) fits(n int) bool {
+	for _, e := range tk.open {
+		if !e.indefinite && n > e.remaining {
+			return false
+		}
+	}
+	return true
+}
+
+// consume accounts for n bytes read within the open elements with a definite
+// length, none of which may be overrun.
+func (tk *Tokenizer) consume(n int) error {
+	if !tk.fits(n) {
+		return asn1.SyntaxError{Msg: "data truncated"}
+	}
+	for i := range tk.open {
+		if !tk.open[i].indefinite {
+			tk.open[i].remaining -= n
+		}
+	}
+	return nil
+}
+
+// end closes the innermost open element.
+func (tk *Tokenizer) end() EndElement {
+	e := tk.open[len(tk.open)-1]
+	tk.open = tk.open[:len(tk.open)-1]
+	return EndElement{Class: e.class, Tag: e.tag}
+}
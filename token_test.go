@@ -0,0 +1,75 @@
+package ber
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestTokenizer(t *testing.T) {
+	// SEQUENCE { 1, [0] { "ab" }, SEQUENCE {} } with an indefinite length,
+	// followed by NULL.
+	in, _ := hex.DecodeString("3080" + "020101" + "a004" + "04026162" + "3000" + "0000" + "0500")
+	want := []Token{
+		StartElement{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, Indefinite: true},
+		Primitive{Class: asn1.ClassUniversal, Tag: asn1.TagInteger, Content: []byte{0x01}},
+		StartElement{Class: asn1.ClassContextSpecific, Tag: 0},
+		Primitive{Class: asn1.ClassUniversal, Tag: asn1.TagOctetString, Content: []byte("ab")},
+		EndElement{Class: asn1.ClassContextSpecific, Tag: 0},
+		StartElement{Class: asn1.ClassUniversal, Tag: asn1.TagSequence},
+		EndElement{Class: asn1.ClassUniversal, Tag: asn1.TagSequence},
+		EndElement{Class: asn1.ClassUniversal, Tag: asn1.TagSequence},
+		Primitive{Class: asn1.ClassUniversal, Tag: asn1.TagNull},
+	}
+	tk := NewTokenizer(bytes.NewReader(in))
+	var got []Token
+	for {
+		tok, err := tk.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, tok)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestTokenizerErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		opts UnmarshalOptions
+		want error
+	}{
+		{"30030201", UnmarshalOptions{}, io.ErrUnexpectedEOF},
+		{"3080020101", UnmarshalOptions{}, io.ErrUnexpectedEOF},
+		{"3003020201", UnmarshalOptions{}, SyntaxError{Msg: "data truncated", Offset: 2}},
+		{"3003a004", UnmarshalOptions{}, SyntaxError{Msg: "data truncated", Offset: 2}},
+		{"30020000", UnmarshalOptions{}, StructuralError{Msg: "unexpected end-of-contents octets", Offset: 2}},
+		{"308000000000", UnmarshalOptions{}, StructuralError{Msg: "unexpected end-of-contents octets", Offset: 4}},
+		{"30800000", UnmarshalOptions{DER: true}, StructuralError{Msg: "indefinite length in DER"}},
+		{"3000048103010203", UnmarshalOptions{DER: true}, StructuralError{Msg: "non-minimal length in DER", Offset: 2}},
+		{"040401020304", UnmarshalOptions{MaxElementSize: 3}, StructuralError{Msg: "element too large"}},
+		{"3080308030800000000000", UnmarshalOptions{MaxDepth: 2}, StructuralError{Msg: "nesting too deep", Offset: 4}},
+	}
+	for _, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		tk := test.opts.NewTokenizer(bytes.NewReader(in))
+		var err error
+		for err == nil {
+			_, err = tk.Token()
+		}
+		if err != test.want {
+			t.Errorf("%s: got %v, want %v", test.in, err, test.want)
+		}
+		if _, again := tk.Token(); again != err {
+			t.Errorf("%s: got %v after %v", test.in, again, err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package ber
+
+import "encoding/hex"
+
+// A UUID is an RFC 4122 universally unique identifier. It is marshaled as a
+// 16 octet OCTET STRING.
+type UUID [16]byte
+
+// String returns u in the canonical form of RFC 4122, five groups of lower
+// case hex digits separated by hyphens: xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx.
+func (u UUID) String() string {
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
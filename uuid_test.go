@@ -0,0 +1,47 @@
+package ber
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+type uuidStruct struct {
+	ID UUID
+}
+
+func TestUUIDRoundTrip(t *testing.T) {
+	id := UUID{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	data, err := Marshal(uuidStruct{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("30120410123e4567e89b12d3a456426614174000")
+	if !bytes.Equal(data, want) {
+		t.Errorf("got %x want %x", data, want)
+	}
+	var got uuidStruct
+	if _, err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != id {
+		t.Errorf("got %v want %v", got.ID, id)
+	}
+	if s := id.String(); s != "123e4567-e89b-12d3-a456-426614174000" {
+		t.Errorf("got %s", s)
+	}
+}
+
+func TestUUIDInvalidLength(t *testing.T) {
+	for i, in := range []string{
+		"3011040f123e4567e89b12d3a4564266141740",
+		"30130411123e4567e89b12d3a45642661417400000",
+	} {
+		data, _ := hex.DecodeString(in)
+		var got uuidStruct
+		_, err := Unmarshal(data, &got)
+		if _, ok := err.(StructuralError); !ok {
+			t.Errorf("#%d: got %v, want StructuralError", i, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"fmt"
+)
+
+// A Value is a BER element decoded without reference to a Go type, so that
+// any encoding can be inspected. A primitive element holds its contents
+// octets, a constructed one the elements it contains.
+type Value struct {
+	Class, Tag int
+	Compound   bool
+	Indefinite bool    // whether the length was indefinite
+	Bytes      []byte  // the contents of a primitive element
+	Children   []Value // the elements within a constructed element
+}
+
+// DecodeValue decodes the element at the start of b into a Value tree,
+// returning it and the bytes following it. The Bytes of the primitive
+// elements refer to b.
+func DecodeValue(b []byte) (v Value, rest []byte, err error) {
+	v, offset, err := decodeValue(b, 0, defaultMaxDepth)
+	if err != nil {
+		return Value{}, nil, err
+	}
+	return v, b[offset:], nil
+}
+
+// decodeValue decodes the element at the given offset in b, which may be
+// nested to at most maxDepth levels, returning it and the offset after it.
+func decodeValue(b []byte, initOffset, maxDepth int) (v Value, offset int, err error) {
+	if maxDepth <= 0 {
+		return v, 0, decoder{}.locate(errNestingTooDeep, initOffset)
+	}
+	t, offset, err := parseTagAndLengthDepth(b, initOffset, maxDepth)
+	if err != nil {
+		return v, 0, decoder{}.locate(err, initOffset)
+	}
+	if invalidLength(offset, t.length, len(b)) {
+		return v, 0, SyntaxError{Msg: "data truncated", Offset: initOffset, truncated: true}
+	}
+
+	v = Value{Class: t.class, Tag: t.tag, Compound: t.isCompound, Indefinite: t.isIndefinite}
+	end := offset + t.length
+	if !t.isCompound {
+		v.Bytes = b[offset:end]
+	}
+	for offset < end && t.isCompound {
+		var child Value
+		if child, offset, err = decodeValue(b[:end], offset, maxDepth-1); err != nil {
+			return Value{}, 0, err
+		}
+		v.Children = append(v.Children, child)
+	}
+	if t.isIndefinite {
+		end += 2
+	}
+	return v, end, nil
+}
+
+// DecodeToMap decodes the constructed element at the start of b, such as a
+// SEQUENCE of context specific fields, into a map from the tag number of each
+// element within it to that element decoded as a Value, and returns the bytes
+// following it. This suits a schema known only at run time. The class of each
+// element is left in its Value; no two elements may have the same tag number.
+func DecodeToMap(b []byte) (m map[int]Value, rest []byte, err error) {
+	if len(b) == 0 {
+		return nil, nil, SyntaxError{Msg: "data truncated", truncated: true}
+	}
+	t, offset, err := parseTagAndLength(b, 0)
+	if err != nil {
+		return nil, nil, decoder{}.locate(err, 0)
+	}
+	if invalidLength(offset, t.length, len(b)) {
+		return nil, nil, SyntaxError{Msg: "data truncated", truncated: true}
+	}
+	if !t.isCompound {
+		return nil, nil, StructuralError{Msg: "primitive element decoded to a map"}
+	}
+
+	end := offset + t.length
+	m = make(map[int]Value)
+	for offset < end {
+		start := offset
+		var v Value
+		if v, offset, err = decodeValue(b[:end], offset, defaultMaxDepth-1); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := m[v.Tag]; ok {
+			return nil, nil, StructuralError{Msg: fmt.Sprintf("duplicate tag %d in map", v.Tag), Offset: start}
+		}
+		m[v.Tag] = v
+	}
+	if t.isIndefinite {
+		end += 2
+	}
+	return m, b[end:], nil
+}
+
+// Valid reports whether b is exactly one well formed BER element, returning
+// a SyntaxError or StructuralError giving the offset of the first problem if
+// not. Every length must fit within the element containing it, each element of
+// indefinite length must end with end-of-contents octets and nothing may
+// follow the element. The contents of primitive elements aren't checked, so
+// b may still fail to unmarshal into a particular Go type.
+func Valid(b []byte) error {
+	offset, err := validElement(b, 0, defaultMaxDepth)
+	if err == nil && offset < len(b) {
+		err = SyntaxError{Msg: "trailing data", Offset: offset}
+	}
+	return err
+}
+
+// validElement checks the element at the given offset in b, as Valid does,
+// and returns the offset after it.
+func validElement(b []byte, initOffset, maxDepth int) (offset int, err error) {
+	if initOffset == len(b) {
+		return 0, SyntaxError{Msg: "data truncated", Offset: initOffset, truncated: true}
+	}
+	if maxDepth <= 0 {
+		return 0, decoder{}.locate(errNestingTooDeep, initOffset)
+	}
+	t, offset, err := parseTagAndLengthDepth(b, initOffset, maxDepth)
+	if err != nil {
+		return 0, decoder{}.locate(err, initOffset)
+	}
+	if invalidLength(offset, t.length, len(b)) {
+		return 0, SyntaxError{Msg: "data truncated", Offset: initOffset, truncated: true}
+	}
+	if t.class == asn1.ClassUniversal && t.tag == 0 {
+		return 0, SyntaxError{Msg: "unexpected end-of-contents octets", Offset: initOffset}
+	}
+
+	end := offset + t.length
+	for offset < end && t.isCompound {
+		if offset, err = validElement(b[:end], offset, maxDepth-1); err != nil {
+			return 0, err
+		}
+	}
+	if t.isIndefinite {
+		end += 2
+	}
+	return end, nil
+}
+
+// EncodeValue returns the BER encoding of the Value tree v. Lengths are
+// definite and minimal, except that a constructed element with Indefinite set
+// has an indefinite length, followed by end-of-contents octets. So an encoding
+// using minimal lengths decodes by DecodeValue to a Value which encodes back
+// to the same bytes.
+func EncodeValue(v Value) ([]byte, error) {
+	e, err := makeValue(v)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, e.Len())
+	e.Encode(b)
+	return b, nil
+}
+
+func makeValue(v Value) (e encoder, err error) {
+	switch {
+	case v.Class < asn1.ClassUniversal || v.Class > asn1.ClassPrivate || v.Tag < 0:
+		return nil, asn1.StructuralError{Msg: "invalid Value class or tag"}
+	case !v.Compound && v.Indefinite:
+		return nil, asn1.StructuralError{Msg: "primitive Value with an indefinite length"}
+	case !v.Compound && len(v.Children) > 0:
+		return nil, asn1.StructuralError{Msg: "primitive Value with children"}
+	case v.Compound && len(v.Bytes) > 0:
+		return nil, asn1.StructuralError{Msg: "constructed Value with Bytes"}
+	}
+
+	body := encoder(bytesEncoder(v.Bytes))
+	if v.Compound {
+		children := make(multiEncoder, len(v.Children))
+		for i, child := range v.Children {
+			if children[i], err = makeValue(child); err != nil {
+				return nil, err
+			}
+		}
+		body = children
+	}
+	return MarshalOptions{CER: v.Indefinite}.tagged(v.Class, v.Tag, v.Compound, body), nil
+}
+
+// Walk calls fn for v and then, in order, for each of the elements within it,
+// depth first. The depth of v is 0, that of its children 1 and so on. If fn
+// returns false the elements within the Value it was given are skipped.
+func (v Value) Walk(fn func(depth int, v Value) bool) {
+	v.walk(0, fn)
+}
+
+func (v Value) walk(depth int, fn func(depth int, v Value) bool) {
+	if !fn(depth, v) {
+		return
+	}
+	for _, child := range v.Children {
+		child.walk(depth+1, fn)
+	}
+}
@@ -0,0 +1,228 @@
+package ber
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeValue(t *testing.T) {
+	// SEQUENCE { INTEGER 5, [0] { BOOLEAN TRUE }, OCTET STRING "ab" }, with
+	// the [0] of indefinite length, and then NULL.
+	in, _ := hex.DecodeString("300e" + "020105" + "a0800101ff0000" + "04026162" + "0500")
+	v, rest, err := DecodeValue(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Value{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, Compound: true, Children: []Value{
+		{Class: asn1.ClassUniversal, Tag: asn1.TagInteger, Bytes: []byte{5}},
+		{Class: asn1.ClassContextSpecific, Tag: 0, Compound: true, Indefinite: true, Children: []Value{
+			{Class: asn1.ClassUniversal, Tag: asn1.TagBoolean, Bytes: []byte{0xff}},
+		}},
+		{Class: asn1.ClassUniversal, Tag: asn1.TagOctetString, Bytes: []byte("ab")},
+	}}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("got %+v want %+v", v, want)
+	}
+	if hex.EncodeToString(rest) != "0500" {
+		t.Errorf("got rest %x want 0500", rest)
+	}
+
+	var walked []string
+	v.Walk(func(depth int, v Value) bool {
+		walked = append(walked, fmt.Sprintf("%d:%d", depth, v.Tag))
+		return true
+	})
+	if got := strings.Join(walked, " "); got != "0:16 1:2 1:0 2:1 1:4" {
+		t.Errorf("walked %s", got)
+	}
+
+	// Returning false skips the children.
+	walked = nil
+	v.Walk(func(depth int, v Value) bool {
+		walked = append(walked, fmt.Sprintf("%d:%d", depth, v.Tag))
+		return depth == 0
+	})
+	if got := strings.Join(walked, " "); got != "0:16 1:2 1:0 1:4" {
+		t.Errorf("walked %s", got)
+	}
+}
+
+func TestDecodeToMap(t *testing.T) {
+	// SEQUENCE { [0] 5, [1] { UTF8String "a" }, [2] "" }, and then NULL.
+	in, _ := hex.DecodeString("300a" + "800105" + "a1030c0161" + "8200" + "0500")
+	m, rest, err := DecodeToMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]Value{
+		0: {Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte{5}},
+		1: {Class: asn1.ClassContextSpecific, Tag: 1, Compound: true, Children: []Value{
+			{Class: asn1.ClassUniversal, Tag: asn1.TagUTF8String, Bytes: []byte("a")},
+		}},
+		2: {Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte{}},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %+v want %+v", m, want)
+	}
+	if hex.EncodeToString(rest) != "0500" {
+		t.Errorf("got rest %x want 0500", rest)
+	}
+
+	for _, test := range []struct {
+		in  string
+		err error
+	}{
+		{"3006" + "800101" + "800102", StructuralError{Msg: "duplicate tag 0 in map", Offset: 5}},
+		{"020105", StructuralError{Msg: "primitive element decoded to a map"}},
+		{"3005800101", SyntaxError{Msg: "data truncated", truncated: true}},
+	} {
+		in, _ := hex.DecodeString(test.in)
+		if _, _, err := DecodeToMap(in); err != test.err {
+			t.Errorf("%s: got %v want %v", test.in, err, test.err)
+		}
+	}
+}
+
+func TestDecodeValueErrors(t *testing.T) {
+	tests := []struct {
+		in     string
+		offset int
+	}{
+		{"3005020105", 0},
+		{"3003020205", 2},
+		{"3080020105", 0},
+		{"a080020105", 0},
+	}
+	for i, test := range tests {
+		in, _ := hex.DecodeString(test.in)
+		_, _, err := DecodeValue(in)
+		se, ok := err.(SyntaxError)
+		if !ok {
+			t.Errorf("#%d: got %v, want SyntaxError", i, err)
+		} else if se.Offset != test.offset {
+			t.Errorf("#%d: got offset %d want %d", i, se.Offset, test.offset)
+		}
+	}
+
+	deep := nestedSeqs(defaultMaxDepth+1, false)
+	if _, _, err := DecodeValue(deep); err == nil {
+		t.Error("too deeply nested value accepted")
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	tests := []string{
+		"0500",
+		"300e" + "020105" + "a0030101ff" + "04026162" + "3000",
+		"3080" + "020105" + "a0800101ff0000" + "0000",
+		"7f2103" + "9f6400",
+		"3081" + "80" + "0479" + strings.Repeat("00", 121) + "0403616263",
+	}
+	for i, test := range tests {
+		in, _ := hex.DecodeString(test)
+		v, _, err := DecodeValue(in)
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		out, err := EncodeValue(v)
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+		} else if got := hex.EncodeToString(out); got != test {
+			t.Errorf("#%d: got %s want %s", i, got, test)
+		}
+	}
+
+	// A tree can be changed before it is encoded again.
+	in, _ := hex.DecodeString("3006020105020106")
+	v, _, _ := DecodeValue(in)
+	v.Children[1].Bytes = []byte{0x01, 0x00}
+	v.Children = append(v.Children, Value{Class: asn1.ClassUniversal, Tag: asn1.TagNull})
+	out, err := EncodeValue(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(out); got != "30090201050202010005"+"00" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestEncodeValueInvalid(t *testing.T) {
+	for i, v := range []Value{
+		{Class: 4},
+		{Tag: -1},
+		{Tag: asn1.TagInteger, Indefinite: true},
+		{Tag: asn1.TagInteger, Children: []Value{{Tag: asn1.TagNull}}},
+		{Tag: asn1.TagSequence, Compound: true, Bytes: []byte{0x05, 0x00}},
+		{Tag: asn1.TagSequence, Compound: true, Children: []Value{{Class: -1}}},
+	} {
+		if _, err := EncodeValue(v); err == nil {
+			t.Errorf("#%d: encoded %+v without error", i, v)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	valid := []string{
+		"0500",
+		"300e" + "020105" + "a0030101ff" + "04026162" + "3000",
+		"3080" + "020105" + "a0800101ff0000" + "0000",
+		"2480" + "04026162" + "0000",
+	}
+	for i, test := range valid {
+		in, _ := hex.DecodeString(test)
+		if err := Valid(in); err != nil {
+			t.Errorf("#%d: %s", i, err)
+		}
+	}
+
+	invalid := []struct {
+		in     string
+		offset int
+	}{
+		{"", 0},
+		{"3005020105", 0},              // truncated length
+		{"3003020205", 2},              // truncated within the SEQUENCE
+		{"3080020105", 0},              // missing end-of-contents
+		{"3080a080020105" + "0000", 0}, // missing outer end-of-contents
+		{"0500" + "00", 2},             // trailing data
+		{"3002" + "0000", 2},           // end-of-contents in a definite length
+		{"0280", 0},                    // indefinite length primitive
+		{"1f", 0},                      // truncated tag
+	}
+	for i, test := range invalid {
+		in, _ := hex.DecodeString(test.in)
+		err := Valid(in)
+		se, ok := err.(SyntaxError)
+		if !ok {
+			t.Errorf("#%d: got %v, want SyntaxError", i, err)
+		} else if se.Offset != test.offset {
+			t.Errorf("#%d: got offset %d want %d", i, se.Offset, test.offset)
+		}
+	}
+
+	// A length which would overflow the offset of the end of the
+	// indefinite length element holding it.
+	in, _ := hex.DecodeString("3080" + "04887fffffffffffffff" + "0000")
+	if err := Valid(in); err == nil {
+		t.Error("overflowing length accepted")
+	}
+
+	if err := Valid(nestedSeqs(defaultMaxDepth+1, false)); err == nil {
+		t.Error("too deeply nested element accepted")
+	}
+}
+
+func BenchmarkValid(b *testing.B) {
+	in, _ := hex.DecodeString("3080" + "020105" + "a0800101ff0000" + "04026162" + "0000")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := Valid(in); err != nil {
+			b.Fatal(err)
+		}
+	}
+}